@@ -0,0 +1,67 @@
+package geoclue2
+
+import (
+	"fmt"
+	"strings"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// AccessDeniedError is the name of the DBus error geoclue2 returns when it
+// doesn't allow an application to access the location.
+const AccessDeniedError = "org.freedesktop.DBus.Error.AccessDenied"
+
+// AccuracyLevel is the level of accuracy requested by, or available to, a
+// client. Applications should request the lowest level they need.
+type AccuracyLevel uint32
+
+// Accuracy levels defined by geoclue2.
+const (
+	AccuracyNone         AccuracyLevel = 0
+	AccuracyCountry      AccuracyLevel = 1
+	AccuracyCity         AccuracyLevel = 4
+	AccuracyNeighborhood AccuracyLevel = 5
+	AccuracyStreet       AccuracyLevel = 6
+	AccuracyExact        AccuracyLevel = 8
+)
+
+var accuracyLevelNames = map[AccuracyLevel]string{
+	AccuracyNone:         "none",
+	AccuracyCountry:      "country",
+	AccuracyCity:         "city",
+	AccuracyNeighborhood: "neighborhood",
+	AccuracyStreet:       "street",
+	AccuracyExact:        "exact",
+}
+
+func (a AccuracyLevel) String() string {
+	if name, ok := accuracyLevelNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("AccuracyLevel(%d)", uint32(a))
+}
+
+// ParseAccuracyLevel parses the name of an accuracy level, e.g. "city", as
+// returned by AccuracyLevel.String.
+func ParseAccuracyLevel(s string) (AccuracyLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for level, name := range accuracyLevelNames {
+		if name == s {
+			return level, nil
+		}
+	}
+	return AccuracyNone, fmt.Errorf("invalid accuracy level %q", s)
+}
+
+// IsAccessDenied returns true if err is the error geoclue2 returns when it
+// denies access to the location, e.g. because the user or the agent didn't
+// authorize the application.
+func IsAccessDenied(err error) bool {
+	switch e := err.(type) {
+	case dbus.Error:
+		return e.Name == AccessDeniedError
+	case *dbus.Error:
+		return e != nil && e.Name == AccessDeniedError
+	}
+	return false
+}
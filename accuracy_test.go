@@ -0,0 +1,34 @@
+package geoclue2
+
+import (
+	"fmt"
+	"testing"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccuracyLevel(t *testing.T) {
+	for _, level := range []AccuracyLevel{
+		AccuracyNone, AccuracyCountry, AccuracyCity, AccuracyNeighborhood,
+		AccuracyStreet, AccuracyExact,
+	} {
+		parsed, err := ParseAccuracyLevel(level.String())
+		assert.NoError(t, err)
+		assert.Equal(t, level, parsed)
+	}
+	level, err := ParseAccuracyLevel(" City ")
+	assert.NoError(t, err)
+	assert.Equal(t, AccuracyCity, level)
+	_, err = ParseAccuracyLevel("galaxy")
+	assert.Error(t, err)
+	assert.Equal(t, "AccuracyLevel(7)", AccuracyLevel(7).String())
+}
+
+func TestIsAccessDenied(t *testing.T) {
+	assert.True(t, IsAccessDenied(dbus.NewError(AccessDeniedError, nil)))
+	assert.True(t, IsAccessDenied(*dbus.NewError(AccessDeniedError, nil)))
+	assert.False(t, IsAccessDenied(dbus.NewError("org.freedesktop.DBus.Error.ServiceUnknown", nil)))
+	assert.False(t, IsAccessDenied(fmt.Errorf("access denied")))
+	assert.False(t, IsAccessDenied(nil))
+}
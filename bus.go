@@ -0,0 +1,191 @@
+package geoclue2
+
+import (
+	"sync"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// Signal sent to the channels registered with a connection created by Connect
+// when the connection is lost, named like the signal libdbus sends in this
+// case. The next call made reconnects.
+const disconnected = "org.freedesktop.DBus.Local.Disconnected"
+
+// Dialer opens a new connection to a bus, ready for use: authenticated, and
+// registered on the bus with Hello.
+type Dialer func() (*dbus.Conn, error)
+
+// SystemBus opens a private connection to the system bus, where geoclue2
+// runs.
+func SystemBus() (*dbus.Conn, error) {
+	return setUpConn(dbus.SystemBusPrivate())
+}
+
+// SessionBus opens a private connection to the session bus, e.g. for a fake
+// geoclue2 service running in the session.
+func SessionBus() (*dbus.Conn, error) {
+	return setUpConn(dbus.SessionBusPrivate())
+}
+
+// BusAddress returns a Dialer for the bus at address, e.g.
+// "unix:path=/tmp/bus", the private bus of a test daemon.
+func BusAddress(address string) Dialer {
+	return func() (*dbus.Conn, error) {
+		return setUpConn(dbus.Dial(address))
+	}
+}
+
+// BusDialer returns the Dialer for bus, which is "system", "session" or the
+// address of a bus.
+func BusDialer(bus string) Dialer {
+	switch bus {
+	case "system":
+		return SystemBus
+	case "session":
+		return SessionBus
+	}
+	return BusAddress(bus)
+}
+
+func setUpConn(conn *dbus.Conn, err error) (*dbus.Conn, error) {
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Connect creates a GeoClue2 using a connection opened with dial, e.g.
+// SystemBus. Unlike with NewGeoClue2, the connection belongs to the GeoClue2:
+// it's closed by Stop, and replaced with a new one if it's closed, e.g.
+// because the bus was restarted.
+func Connect(dial Dialer, desktopID string) (*GeoClue2, error) {
+	conn := &busConn{dial: dial, logger: DefaultLogger()}
+	if _, err := conn.connect(); err != nil {
+		return nil, err
+	}
+	return newGeoClue2(conn, desktopID), nil
+}
+
+// busConn is a DbusConn that opens its connection itself, and opens a new one
+// when it's closed.
+type busConn struct {
+	dial   Dialer
+	lock   sync.Mutex
+	logger Logger
+	conn   *dbus.Conn
+	// Closed when conn is closed by Close.
+	done    chan struct{}
+	signals []chan<- *dbus.Signal
+}
+
+// connect returns the current connection, or opens a new one if it's closed.
+// If that fails, it returns the error with the closed connection. Only the
+// first call, made by Connect, can return a nil connection.
+func (b *busConn) connect() (*dbus.Conn, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn != nil && b.conn.Context().Err() == nil {
+		return b.conn, nil
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return b.conn, err
+	}
+	if b.conn != nil {
+		b.logger.Info("reconnected to bus")
+	}
+	b.conn = conn
+	b.done = make(chan struct{})
+	ch := make(chan *dbus.Signal, 16)
+	conn.Signal(ch)
+	go b.forward(ch, b.done)
+	return conn, nil
+}
+
+// forward forwards the signals received on ch to the registered channels,
+// until the connection is closed. If it's closed by something else than
+// Close, a Disconnected signal is sent as well.
+func (b *busConn) forward(ch <-chan *dbus.Signal, done <-chan struct{}) {
+	for sig := range ch {
+		b.send(sig, done)
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+	b.log().Warn("lost connection to bus")
+	b.send(&dbus.Signal{
+		Path: "/org/freedesktop/DBus/Local",
+		Name: disconnected,
+	}, done)
+}
+
+func (b *busConn) send(sig *dbus.Signal, done <-chan struct{}) {
+	b.lock.Lock()
+	signals := make([]chan<- *dbus.Signal, len(b.signals))
+	copy(signals, b.signals)
+	b.lock.Unlock()
+	for _, ch := range signals {
+		select {
+		case ch <- sig:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (b *busConn) setLogger(l Logger) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.logger = l
+}
+
+func (b *busConn) log() Logger {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.logger
+}
+
+func (b *busConn) Signal(ch chan<- *dbus.Signal) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, c := range b.signals {
+		if c == ch {
+			return
+		}
+	}
+	b.signals = append(b.signals, ch)
+}
+
+func (b *busConn) Object(iface string, path dbus.ObjectPath) dbus.BusObject {
+	// If reconnecting fails, calls on the closed connection fail.
+	conn, err := b.connect()
+	if err != nil {
+		b.log().Warn("reconnecting to bus", "error", err)
+	}
+	return conn.Object(iface, path)
+}
+
+// Close closes the connection. A new one is opened if it's used again.
+func (b *busConn) Close() error {
+	b.lock.Lock()
+	select {
+	case <-b.done:
+		b.lock.Unlock()
+		return nil
+	default:
+	}
+	close(b.done)
+	conn := b.conn
+	b.lock.Unlock()
+	return conn.Close()
+}
@@ -0,0 +1,158 @@
+package geoclue2_test
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/ldx/go-geoclue2"
+	"github.com/ldx/go-geoclue2/fakegeoclue"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeGeoClue starts a private bus with a fake geoclue2 service on it.
+func startFakeGeoClue(t *testing.T) (*fakegeoclue.Bus, *fakegeoclue.Service) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	bus, err := fakegeoclue.StartBus()
+	if err != nil {
+		t.Fatalf("starting bus: %v", err)
+	}
+	s := fakegeoclue.New()
+	if err := s.ServeBus(bus.Address); err != nil {
+		bus.Close()
+		t.Fatalf("serving fake geoclue2: %v", err)
+	}
+	return bus, s
+}
+
+func receive(t *testing.T, ch chan geoclue2.Location) geoclue2.Location {
+	select {
+	case loc := <-ch:
+		return loc
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for location")
+	}
+	return geoclue2.Location{}
+}
+
+// recordingDialer keeps the connections it opens. After the first one, it
+// fails the given number of times.
+type recordingDialer struct {
+	dial     geoclue2.Dialer
+	lock     sync.Mutex
+	conns    []*dbus.Conn
+	failures int
+}
+
+func (d *recordingDialer) Dial() (*dbus.Conn, error) {
+	d.lock.Lock()
+	if len(d.conns) > 0 && d.failures > 0 {
+		d.failures--
+		d.lock.Unlock()
+		return nil, errors.New("testing dial error")
+	}
+	d.lock.Unlock()
+	conn, err := d.dial()
+	if err == nil {
+		d.lock.Lock()
+		d.conns = append(d.conns, conn)
+		d.lock.Unlock()
+	}
+	return conn, err
+}
+
+func (d *recordingDialer) conn(i int) *dbus.Conn {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if i >= len(d.conns) {
+		return nil
+	}
+	return d.conns[i]
+}
+
+func TestConnect(t *testing.T) {
+	bus, s := startFakeGeoClue(t)
+	defer bus.Close()
+	defer s.Close()
+	d := &recordingDialer{dial: geoclue2.BusAddress(bus.Address)}
+	gc2, err := geoclue2.Connect(d.Dial, "test")
+	assert.NoError(t, err)
+	gc2.Start()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	first := geoclue2.Location{Latitude: 1, Longitude: 2, Accuracy: 10}
+	assert.NoError(t, s.SetLocation(first))
+	assert.Equal(t, first, receive(t, ch))
+
+	// When the connection is lost, a new one is opened, and the client is
+	// set up again.
+	assert.NoError(t, d.conn(0).Close())
+	assert.Equal(t, first, receive(t, ch))
+	second := geoclue2.Location{Latitude: 3, Longitude: 4, Accuracy: 10}
+	assert.NoError(t, s.SetLocation(second))
+	assert.Equal(t, second, receive(t, ch))
+	assert.NotNil(t, d.conn(1))
+
+	gc2.Stop()
+	assert.Error(t, d.conn(1).Context().Err())
+}
+
+func TestConnectRetry(t *testing.T) {
+	bus, s := startFakeGeoClue(t)
+	defer bus.Close()
+	defer s.Close()
+	d := &recordingDialer{dial: geoclue2.BusAddress(bus.Address), failures: 3}
+	gc2, err := geoclue2.Connect(d.Dial, "test")
+	assert.NoError(t, err)
+	gc2.SetRetryInterval(10*time.Millisecond, 50*time.Millisecond)
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	first := geoclue2.Location{Latitude: 1, Longitude: 2, Accuracy: 10}
+	assert.NoError(t, s.SetLocation(first))
+	assert.Equal(t, first, receive(t, ch))
+
+	// Reconnecting is retried until it succeeds.
+	assert.NoError(t, d.conn(0).Close())
+	assert.Equal(t, first, receive(t, ch))
+	assert.NoError(t, gc2.Err())
+}
+
+func TestConnectLogger(t *testing.T) {
+	bus, s := startFakeGeoClue(t)
+	defer bus.Close()
+	defer s.Close()
+	d := &recordingDialer{dial: geoclue2.BusAddress(bus.Address)}
+	gc2, err := geoclue2.Connect(d.Dial, "test")
+	assert.NoError(t, err)
+	lost := make(chan string, 16)
+	gc2.SetLogger(geoclue2.LoggerFunc(func(level geoclue2.Level, msg string, kv ...interface{}) {
+		lost <- msg
+	}))
+	gc2.Start()
+	defer gc2.Stop()
+	// The connection logs with the logger of the GeoClue2.
+	assert.NoError(t, d.conn(0).Close())
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-lost:
+			if msg == "lost connection to bus" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for log message")
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	_, err := geoclue2.Connect(geoclue2.BusAddress("unix:path=/nonexistent/bus"), "")
+	assert.Error(t, err)
+}
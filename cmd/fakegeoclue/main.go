@@ -0,0 +1,224 @@
+// Command fakegeoclue serves a fake geoclue2 service, for developing
+// location-aware applications without a GPS, or anywhere else than where
+// they'll be used. It claims org.freedesktop.GeoClue2 and serves positions
+// to all clients, whatever their desktop ID.
+//
+// Usage:
+//
+//	fakegeoclue [flags] (-at LAT,LON | -walk LAT,LON | -gpx FILE)
+//
+// The positions come from one of:
+//
+//	-at LAT,LON     a fixed position
+//	-walk LAT,LON   a random walk starting at the position
+//	-gpx FILE       the points of the tracks and routes in a GPX file
+//
+// Positions can be followed by the accuracy, e.g. "47.4979,19.0402 ±25m".
+//
+// By default the service runs on the session bus. Most applications,
+// including the ones using this library, look for geoclue2 on the system
+// bus, where only the geoclue user may own the name. With -bus private, a
+// private bus is started, and its address printed as
+// DBUS_SYSTEM_BUS_ADDRESS=..., to run applications against it:
+//
+//	$ fakegeoclue -bus private -walk 47.4979,19.0402
+//	DBUS_SYSTEM_BUS_ADDRESS=unix:path=/tmp/fakegeoclue123/bus,guid=...
+//	$ DBUS_SYSTEM_BUS_ADDRESS=unix:path=... geoclue2 watch
+//
+// Applications using this library can also be pointed at the session bus,
+// see geoclue2.SessionBus, or at any bus address, e.g.
+// "geoclue2 watch -bus session".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+	"github.com/ldx/go-geoclue2/fakegeoclue"
+)
+
+// Exit statuses.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+var progName = filepath.Base(os.Args[0])
+
+type options struct {
+	bus      string
+	at       string
+	walk     string
+	gpx      string
+	accuracy float64
+	interval time.Duration
+	speed    float64
+	speedup  float64
+	loop     bool
+	deny     string
+}
+
+func main() {
+	opts := options{}
+	verbosity := flag.Int("v", 0, "log verbosity: 2 to log the positions served, 5 for debug messages")
+	flag.StringVar(&opts.bus, "bus", "session", "bus to serve on: session, system, private or a DBus address")
+	flag.StringVar(&opts.at, "at", "", "serve a fixed position")
+	flag.StringVar(&opts.walk, "walk", "", "serve a random walk starting at this position")
+	flag.StringVar(&opts.gpx, "gpx", "", "serve the points of this GPX file")
+	flag.Float64Var(&opts.accuracy, "accuracy", 10, "accuracy in meters of positions without one")
+	flag.DurationVar(&opts.interval, "interval", time.Second, "time between steps of the walk, and between GPX points without timestamps")
+	flag.Float64Var(&opts.speed, "speed", 1.4, "speed of the walk in meters per second")
+	flag.Float64Var(&opts.speedup, "speedup", 1, "replay GPX points this many times faster than recorded")
+	flag.BoolVar(&opts.loop, "loop", false, "replay the GPX points in a loop")
+	flag.StringVar(&opts.deny, "deny", "", "comma separated desktop IDs to deny access to")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] (-at LAT,LON | -walk LAT,LON | -gpx FILE)\n\nFlags:\n", progName)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	logger := geoclue2.NewTextLogger(os.Stderr, logLevel(*verbosity))
+	geoclue2.SetDefaultLogger(logger)
+	os.Exit(run(opts, logger))
+}
+
+// logLevel returns the lowest level logged with verbosity v: warnings and
+// errors by default, info messages from 2 and debug messages from 5.
+func logLevel(v int) geoclue2.Level {
+	switch {
+	case v >= 5:
+		return geoclue2.LevelDebug
+	case v >= 2:
+		return geoclue2.LevelInfo
+	}
+	return geoclue2.LevelWarn
+}
+
+func run(opts options, logger geoclue2.Logger) int {
+	if flag.NArg() > 0 {
+		flag.Usage()
+		return exitUsage
+	}
+	src, err := newSource(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+		return exitUsage
+	}
+	s := fakegeoclue.New()
+	closeBus, err := serve(s, opts.bus)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+		return exitError
+	}
+	defer closeBus()
+	defer s.Close()
+	for _, id := range strings.Split(opts.deny, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			s.Deny(id)
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	if err := play(s, src, opts.accuracy, sigs, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// newSource creates the source of positions selected by the flags.
+func newSource(opts options) (source, error) {
+	n := 0
+	for _, s := range []string{opts.at, opts.walk, opts.gpx} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("exactly one of -at, -walk and -gpx is required")
+	}
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %v", opts.interval)
+	}
+	switch {
+	case opts.at != "":
+		loc := geoclue2.Location{}
+		if err := loc.UnmarshalText([]byte(opts.at)); err != nil {
+			return nil, err
+		}
+		return &fixedSource{loc: loc}, nil
+	case opts.walk != "":
+		loc := geoclue2.Location{}
+		if err := loc.UnmarshalText([]byte(opts.walk)); err != nil {
+			return nil, err
+		}
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return newWalkSource(loc, opts.interval, opts.speed, r), nil
+	}
+	if opts.speedup <= 0 {
+		return nil, fmt.Errorf("invalid speedup %v", opts.speedup)
+	}
+	return newTrackSource(opts.gpx, opts.interval, opts.speedup, opts.loop)
+}
+
+// serve serves s on the bus selected with -bus. It returns a function that
+// stops the private bus, if one was started.
+func serve(s *fakegeoclue.Service, bus string) (func(), error) {
+	if bus == "private" {
+		b, err := fakegeoclue.StartBus()
+		if err != nil {
+			return nil, fmt.Errorf("starting private bus: %v", err)
+		}
+		if err := s.ServeBus(b.Address); err != nil {
+			b.Close()
+			return nil, err
+		}
+		fmt.Printf("DBUS_SYSTEM_BUS_ADDRESS=%s\n", b.Address)
+		return func() { b.Close() }, nil
+	}
+	conn, err := geoclue2.BusDialer(bus)()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the %s bus: %v", bus, err)
+	}
+	if err := s.ServeName(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {}, nil
+}
+
+// play serves the positions of src until it runs out of them, then keeps
+// serving the last one, until a signal is received. Positions are
+// timestamped when they're served, like geoclue2 does for most sources.
+func play(s *fakegeoclue.Service, src source, accuracy float64, sigs <-chan os.Signal, logger geoclue2.Logger) error {
+	for {
+		loc, wait, ok := src.next()
+		if !ok {
+			<-sigs
+			return nil
+		}
+		if loc.Accuracy == 0 {
+			loc.Accuracy = accuracy
+		}
+		loc.Timestamp = geoclue2.FromTime(time.Now())
+		if err := s.SetLocation(loc); err != nil {
+			return err
+		}
+		logger.Info("serving location", "latitude", loc.Latitude, "longitude", loc.Longitude, "accuracy", loc.Accuracy)
+		timer := time.NewTimer(wait)
+		select {
+		case <-sigs:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+)
+
+// source produces the positions served. next returns the next position, and
+// how long to serve it before asking for the next one. ok is false when
+// there are no more positions.
+type source interface {
+	next() (loc geoclue2.Location, wait time.Duration, ok bool)
+}
+
+// fixedSource serves a single position.
+type fixedSource struct {
+	loc  geoclue2.Location
+	done bool
+}
+
+func (s *fixedSource) next() (geoclue2.Location, time.Duration, bool) {
+	if s.done {
+		return geoclue2.Location{}, 0, false
+	}
+	s.done = true
+	return s.loc, 0, true
+}
+
+// trackSource replays the points of a track. The time between points is
+// taken from their timestamps, divided by speedup, or interval if they don't
+// have timestamps. Gaps longer than maxWait are shortened.
+type trackSource struct {
+	points   []geoclue2.Location
+	interval time.Duration
+	speedup  float64
+	loop     bool
+	i        int
+}
+
+// Longest time between two points of a track.
+const maxWait = time.Minute
+
+func newTrackSource(path string, interval time.Duration, speedup float64, loop bool) (*trackSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	track, err := geoclue2.ReadGPX(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	s := &trackSource{
+		interval: interval,
+		speedup:  speedup,
+		loop:     loop,
+	}
+	for _, seg := range track.Segments() {
+		s.points = append(s.points, seg...)
+	}
+	if len(s.points) == 0 {
+		return nil, fmt.Errorf("no track points in %s", path)
+	}
+	return s, nil
+}
+
+func (s *trackSource) next() (geoclue2.Location, time.Duration, bool) {
+	if s.i >= len(s.points) {
+		if !s.loop {
+			return geoclue2.Location{}, 0, false
+		}
+		s.i = 0
+	}
+	loc := s.points[s.i]
+	s.i++
+	return loc, s.wait(loc), true
+}
+
+// wait returns how long to serve loc, the point before s.i. After the last
+// point, it's the interval.
+func (s *trackSource) wait(loc geoclue2.Location) time.Duration {
+	if s.i >= len(s.points) {
+		return s.interval
+	}
+	next := s.points[s.i]
+	if loc.Timestamp.IsZero() || next.Timestamp.IsZero() {
+		return s.interval
+	}
+	d := time.Duration(float64(next.Timestamp.Time().Sub(loc.Timestamp.Time())) / s.speedup)
+	if d < 0 {
+		return s.interval
+	}
+	if d > maxWait {
+		return maxWait
+	}
+	return d
+}
+
+// walkSource moves randomly from a starting position, at a constant speed,
+// turning a little at every step.
+type walkSource struct {
+	loc      geoclue2.Location
+	interval time.Duration
+	speed    float64
+	rand     *rand.Rand
+	started  bool
+}
+
+// Standard deviation of the change of heading per step, in degrees.
+const walkTurn = 30
+
+func newWalkSource(start geoclue2.Location, interval time.Duration, speed float64, r *rand.Rand) *walkSource {
+	start.Speed = speed
+	start.Heading = r.Float64() * 360
+	return &walkSource{
+		loc:      start,
+		interval: interval,
+		speed:    speed,
+		rand:     r,
+	}
+}
+
+func (s *walkSource) next() (geoclue2.Location, time.Duration, bool) {
+	if !s.started {
+		s.started = true
+		return s.loc, s.interval, true
+	}
+	heading := math.Mod(s.loc.Heading+s.rand.NormFloat64()*walkTurn+360, 360)
+	loc := s.loc.DestinationPoint(heading, s.speed*s.interval.Seconds())
+	loc.Accuracy = s.loc.Accuracy
+	loc.Altitude = s.loc.Altitude
+	loc.Speed = s.speed
+	loc.Heading = heading
+	s.loc = loc
+	return loc, s.interval, true
+}
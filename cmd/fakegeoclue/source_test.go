@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+	"github.com/ldx/go-geoclue2/fakegeoclue"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <trkseg>
+      <trkpt lat="47.5" lon="19.05"><time>2020-09-13T12:26:40Z</time></trkpt>
+      <trkpt lat="47.6" lon="19.06"><time>2020-09-13T12:26:50Z</time></trkpt>
+      <trkpt lat="47.7" lon="19.07"><time>2020-09-13T14:26:50Z</time></trkpt>
+      <trkpt lat="47.8" lon="19.08"></trkpt>
+    </trkseg>
+  </trk>
+</gpx>
+`
+
+func writeTestGPX(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fakegeoclue")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "track.gpx")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testGPX), 0644))
+	return path
+}
+
+func TestFixedSource(t *testing.T) {
+	src := &fixedSource{loc: geoclue2.Location{Latitude: 1, Longitude: 2}}
+	loc, _, ok := src.next()
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, loc.Latitude)
+	_, _, ok = src.next()
+	assert.False(t, ok)
+}
+
+func TestTrackSource(t *testing.T) {
+	path := writeTestGPX(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	src, err := newTrackSource(path, 3*time.Second, 2, false)
+	assert.NoError(t, err)
+	var waits []time.Duration
+	for {
+		loc, wait, ok := src.next()
+		if !ok {
+			break
+		}
+		assert.NotZero(t, loc.Latitude)
+		waits = append(waits, wait)
+	}
+	// Twice as fast, long gaps shortened, the interval without timestamps.
+	assert.Equal(t, []time.Duration{5 * time.Second, maxWait, 3 * time.Second, 3 * time.Second}, waits)
+
+	src, err = newTrackSource(path, time.Second, 1, true)
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		src.next()
+	}
+	loc, _, ok := src.next()
+	assert.True(t, ok)
+	assert.Equal(t, 47.5, loc.Latitude)
+
+	_, err = newTrackSource(filepath.Join(filepath.Dir(path), "missing.gpx"), time.Second, 1, false)
+	assert.Error(t, err)
+}
+
+func TestWalkSource(t *testing.T) {
+	start := geoclue2.Location{Latitude: 47.5, Longitude: 19.05, Accuracy: 5}
+	src := newWalkSource(start, 2*time.Second, 1.5, rand.New(rand.NewSource(1)))
+	prev, wait, ok := src.next()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+	assert.Equal(t, 47.5, prev.Latitude)
+	for i := 0; i < 10; i++ {
+		loc, _, ok := src.next()
+		assert.True(t, ok)
+		assert.InDelta(t, 3, prev.DistanceTo(loc), 0.01)
+		assert.Equal(t, 5.0, loc.Accuracy)
+		assert.Equal(t, 1.5, loc.Speed)
+		assert.True(t, loc.Heading >= 0 && loc.Heading < 360)
+		prev = loc
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	_, err := newSource(options{interval: time.Second})
+	assert.Error(t, err)
+	_, err = newSource(options{at: "1,2", walk: "1,2", interval: time.Second})
+	assert.Error(t, err)
+	_, err = newSource(options{at: "100,2", interval: time.Second})
+	assert.Error(t, err)
+	src, err := newSource(options{at: "1,2 ±25m", interval: time.Second})
+	assert.NoError(t, err)
+	loc, _, _ := src.next()
+	assert.Equal(t, 25.0, loc.Accuracy)
+	_, err = newSource(options{walk: "1,2", interval: 0})
+	assert.Error(t, err)
+}
+
+func TestPlay(t *testing.T) {
+	s := fakegeoclue.New()
+	conn, err := s.ServePeer()
+	assert.NoError(t, err)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "")
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 1)
+	gc2.Subscribe(ch)
+	sigs := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() {
+		done <- play(s, &fixedSource{loc: geoclue2.Location{Latitude: 1, Longitude: 2}}, 10, sigs, geoclue2.DiscardLogger)
+	}()
+	select {
+	case loc := <-ch:
+		assert.Equal(t, 1.0, loc.Latitude)
+		assert.Equal(t, 10.0, loc.Accuracy)
+		assert.False(t, loc.Timestamp.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for location")
+	}
+	sigs <- os.Interrupt
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/ldx/go-geoclue2"
+)
+
+const (
+	defaultGeoclueConfig = "/etc/geoclue/geoclue.conf"
+	busName              = "org.freedesktop.DBus"
+	busPath              = "/org/freedesktop/DBus"
+	geoclueName          = "org.freedesktop.GeoClue2"
+	managerPath          = "/org/freedesktop/GeoClue2/Manager"
+	managerInterface     = "org.freedesktop.GeoClue2.Manager"
+	clientInterface      = "org.freedesktop.GeoClue2.Client"
+	agentPath            = "/org/freedesktop/GeoClue2/Agent"
+	agentInterface       = "org.freedesktop.GeoClue2.Agent"
+	propertiesGet        = "org.freedesktop.DBus.Properties.Get"
+	propertiesSet        = "org.freedesktop.DBus.Properties.Set"
+	// How long to wait for peers that might be agents to answer.
+	agentProbeTimeout = 500 * time.Millisecond
+)
+
+// busObjects looks up objects on a bus, like dbus.Conn.
+type busObjects interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+}
+
+type severity int
+
+const (
+	severityOK severity = iota
+	severityInfo
+	severityWarning
+	severityFailure
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityOK:
+		return "OK"
+	case severityInfo:
+		return "INFO"
+	case severityWarning:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// finding is the result of a check, with a hint on how to fix it.
+type finding struct {
+	severity severity
+	message  string
+	hint     string
+}
+
+// diagnostics checks the environment geoclue2 clients run in.
+type diagnostics struct {
+	bus        busObjects
+	busSpec    string // The -bus flag: system, session or a DBus address.
+	desktopID  string
+	level      geoclue2.AccuracyLevel
+	configPath string
+	// How long to wait for each bus call, and for the authorization of the
+	// client.
+	timeout  time.Duration
+	findings []finding
+}
+
+func (d *diagnostics) report(s severity, hint, format string, args ...interface{}) {
+	d.findings = append(d.findings, finding{
+		severity: s,
+		message:  fmt.Sprintf(format, args...),
+		hint:     hint,
+	})
+}
+
+func (d *diagnostics) call(obj dbus.BusObject, method string, args ...interface{}) *dbus.Call {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	return obj.CallWithContext(ctx, method, 0, args...)
+}
+
+func (d *diagnostics) property(obj dbus.BusObject, iface, name string, into interface{}) error {
+	var v dbus.Variant
+	if err := d.call(obj, propertiesGet, iface, name).Store(&v); err != nil {
+		return err
+	}
+	return dbus.Store([]interface{}{v.Value()}, into)
+}
+
+// run runs all checks. Checks that need the service are skipped if it's not
+// available.
+func (d *diagnostics) run() {
+	if !d.checkService() {
+		return
+	}
+	d.checkManager()
+	d.checkAgents()
+	d.checkConfig()
+	d.checkAuthorization()
+}
+
+// busDescription describes the bus being diagnosed in hints.
+func (d *diagnostics) busDescription() string {
+	switch d.busSpec {
+	case "", "system":
+		return "the DBus system bus"
+	case "session":
+		return "the DBus session bus"
+	}
+	return "the DBus bus at " + d.busSpec
+}
+
+// servicesDir returns the directory of the DBus service files of the bus
+// being diagnosed, or "" for a bus given by its address.
+func (d *diagnostics) servicesDir() string {
+	switch d.busSpec {
+	case "", "system":
+		return "/usr/share/dbus-1/system-services"
+	case "session":
+		return "/usr/share/dbus-1/services"
+	}
+	return ""
+}
+
+func (d *diagnostics) checkService() bool {
+	bus := d.bus.Object(busName, busPath)
+	var activatable, running []string
+	if err := d.call(bus, busName+".ListActivatableNames").Store(&activatable); err != nil {
+		d.report(severityFailure, "check that "+d.busDescription()+" is running",
+			"listing activatable services: %v", err)
+		return false
+	}
+	if err := d.call(bus, busName+".ListNames").Store(&running); err != nil {
+		d.report(severityFailure, "check that "+d.busDescription()+" is running",
+			"listing running services: %v", err)
+		return false
+	}
+	serviceHint := ""
+	if dir := d.servicesDir(); dir != "" {
+		serviceHint = " in " + dir
+	}
+	isActivatable, isRunning := contains(activatable, geoclueName), contains(running, geoclueName)
+	switch {
+	case isActivatable && isRunning:
+		d.report(severityOK, "", "%s is activatable and running", geoclueName)
+	case isActivatable:
+		d.report(severityOK, "", "%s is activatable, it will be started on demand", geoclueName)
+	case isRunning:
+		d.report(severityWarning, "the DBus service file of geoclue2 may be missing"+serviceHint,
+			"%s is running, but it's not activatable", geoclueName)
+	default:
+		d.report(severityFailure, "install geoclue2, e.g. the geoclue-2.0 package, and check its DBus service file"+serviceHint,
+			"%s is not available on %s", geoclueName, d.busDescription())
+		return false
+	}
+	return true
+}
+
+func (d *diagnostics) checkManager() {
+	manager := d.bus.Object(geoclueName, managerPath)
+	var inUse bool
+	if err := d.property(manager, managerInterface, "InUse", &inUse); err != nil {
+		d.report(severityFailure, "geoclue2 may fail to start, check its logs with 'journalctl -u geoclue'",
+			"getting Manager.InUse: %v", err)
+		return
+	}
+	d.report(severityInfo, "", "Manager.InUse: %v", inUse)
+	var level uint32
+	if err := d.property(manager, managerInterface, "AvailableAccuracyLevel", &level); err != nil {
+		d.report(severityFailure, "", "getting Manager.AvailableAccuracyLevel: %v", err)
+		return
+	}
+	available := geoclue2.AccuracyLevel(level)
+	switch {
+	case available == geoclue2.AccuracyNone:
+		d.report(severityFailure, "location services are disabled; enable them in the privacy settings of the desktop, and check that sources are enabled in "+d.configPath,
+			"Manager.AvailableAccuracyLevel: %s", available)
+	case available < d.level:
+		d.report(severityWarning, "locations will be less accurate than requested; a GPS source or WiFi based positioning may be disabled in "+d.configPath,
+			"Manager.AvailableAccuracyLevel: %s, lower than the requested %s", available, d.level)
+	default:
+		d.report(severityOK, "", "Manager.AvailableAccuracyLevel: %s", available)
+	}
+}
+
+// checkAgents looks for agents by probing the peers on the bus for the
+// agent object geoclue2 calls back.
+func (d *diagnostics) checkAgents() {
+	bus := d.bus.Object(busName, busPath)
+	var names []string
+	if err := d.call(bus, busName+".ListNames").Store(&names); err != nil {
+		d.report(severityWarning, "", "listing bus peers: %v", err)
+		return
+	}
+	var agents []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, ":") {
+			continue
+		}
+		agent := d.bus.Object(name, agentPath)
+		ctx, cancel := context.WithTimeout(context.Background(), agentProbeTimeout)
+		err := agent.CallWithContext(ctx, propertiesGet, 0, agentInterface, "MaxAccuracyLevel").Err
+		cancel()
+		if err != nil {
+			continue
+		}
+		agents = append(agents, d.describePeer(name))
+	}
+	if len(agents) == 0 {
+		d.report(severityWarning, "unless the application is allowed as a system application in "+d.configPath+
+			", geoclue2 asks an agent for authorization; run a desktop session with an agent, e.g. GNOME, or the demo agent of geoclue2 (/usr/libexec/geoclue-2.0/demos/agent)",
+			"no geoclue2 agent is registered")
+		return
+	}
+	d.report(severityOK, "", "agents: %s", strings.Join(agents, ", "))
+}
+
+// describePeer returns the unique bus name of a peer, with its process name
+// if it can be found.
+func (d *diagnostics) describePeer(name string) string {
+	bus := d.bus.Object(busName, busPath)
+	var pid uint32
+	if err := d.call(bus, busName+".GetConnectionUnixProcessID", name).Store(&pid); err != nil {
+		return name
+	}
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return fmt.Sprintf("%s (pid %d)", name, pid)
+	}
+	return fmt.Sprintf("%s (%s, pid %d)", name, strings.TrimSpace(string(comm)), pid)
+}
+
+func (d *diagnostics) checkConfig() {
+	f, err := os.Open(d.configPath)
+	if err != nil {
+		d.report(severityInfo, "", "reading geoclue2 configuration: %v", err)
+		return
+	}
+	defer f.Close()
+	config, err := parseGeoclueConfig(f)
+	if err != nil {
+		d.report(severityWarning, "", "parsing %s: %v", d.configPath, err)
+		return
+	}
+	app, ok := config[d.desktopID]
+	switch {
+	case !ok:
+		d.report(severityInfo, "to skip asking the agent, add a ["+d.desktopID+"] section with allowed=true and system=true to "+d.configPath,
+			"desktop ID %q is not listed in %s, the agent decides", d.desktopID, d.configPath)
+	case app["allowed"] == "false":
+		d.report(severityFailure, "set allowed=true in the ["+d.desktopID+"] section of "+d.configPath,
+			"desktop ID %q is disallowed in %s", d.desktopID, d.configPath)
+	case app["system"] == "true":
+		d.report(severityOK, "", "desktop ID %q is allowed as a system application in %s", d.desktopID, d.configPath)
+	default:
+		d.report(severityOK, "", "desktop ID %q is allowed in %s, the agent decides", d.desktopID, d.configPath)
+	}
+}
+
+// checkAuthorization creates and starts a client, to see if geoclue2 lets
+// the desktop ID access the location.
+func (d *diagnostics) checkAuthorization() {
+	manager := d.bus.Object(geoclueName, managerPath)
+	var path dbus.ObjectPath
+	if err := d.call(manager, managerInterface+".GetClient").Store(&path); err != nil {
+		d.report(severityFailure, "", "creating a client: %v", err)
+		return
+	}
+	defer d.call(manager, managerInterface+".DeleteClient", path)
+	client := d.bus.Object(geoclueName, path)
+	err := d.call(client, propertiesSet, clientInterface, "DesktopId", dbus.MakeVariant(d.desktopID)).Err
+	if err == nil && d.level != geoclue2.AccuracyNone {
+		err = d.call(client, propertiesSet, clientInterface, "RequestedAccuracyLevel", dbus.MakeVariant(uint32(d.level))).Err
+	}
+	if err == nil {
+		err = d.call(client, clientInterface+".Start").Err
+	}
+	switch {
+	case err == nil:
+		d.report(severityOK, "", "desktop ID %q is authorized", d.desktopID)
+		d.call(client, clientInterface+".Stop")
+	case geoclue2.IsAccessDenied(err):
+		d.report(severityFailure, "allow the application in the location settings of the desktop, or in "+d.configPath,
+			"desktop ID %q is not authorized: %v", d.desktopID, err)
+	default:
+		d.report(severityFailure, "", "starting a client: %v", err)
+	}
+}
+
+// failed returns true if any check failed.
+func (d *diagnostics) failed() bool {
+	for _, f := range d.findings {
+		if f.severity == severityFailure {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *diagnostics) write(w io.Writer) error {
+	for _, f := range d.findings {
+		if _, err := fmt.Fprintf(w, "[%-4s] %s\n", f.severity, f.message); err != nil {
+			return err
+		}
+		if f.hint == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "       hint: %s\n", f.hint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGeoclueConfig parses the ini style configuration file of geoclue2
+// into sections of keys and values.
+func parseGeoclueConfig(r io.Reader) (map[string]map[string]string, error) {
+	config := make(map[string]map[string]string)
+	var section map[string]string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid section header %q", n, line)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			section = make(map[string]string)
+			config[name] = section
+		default:
+			i := strings.IndexByte(line, '=')
+			if i < 0 || section == nil {
+				return nil, fmt.Errorf("line %d: invalid line %q", n, line)
+			}
+			section[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return config, scanner.Err()
+}
+
+func runDiag(args []string) int {
+	fs := flag.NewFlagSet("diag", flag.ContinueOnError)
+	opts := addClientFlags(fs)
+	configPath := fs.String("config", defaultGeoclueConfig, "path of the geoclue2 configuration file")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for geoclue2 and the agent to answer")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	level, err := geoclue2.ParseAccuracyLevel(opts.accuracy)
+	if err != nil {
+		return exitStatus(err)
+	}
+	conn, err := opts.dial()()
+	if err != nil {
+		return exitStatus(err)
+	}
+	defer conn.Close()
+	d := &diagnostics{
+		bus:        conn,
+		busSpec:    opts.bus,
+		desktopID:  opts.desktopID,
+		level:      level,
+		configPath: *configPath,
+		timeout:    *timeout,
+	}
+	if d.desktopID == "" {
+		d.desktopID = geoclue2.DefaultDesktopID
+	}
+	d.run()
+	if err := d.write(os.Stdout); err != nil {
+		return exitStatus(err)
+	}
+	if d.failed() {
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/ldx/go-geoclue2"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+# Comment
+[agent]
+whitelist=geoclue-demo-agent;gnome-shell
+
+[wifi]
+enable=true
+
+[allowed-app]
+allowed=true
+system=true
+
+[disallowed-app]
+allowed=false
+`
+
+func TestParseGeoclueConfig(t *testing.T) {
+	config, err := parseGeoclueConfig(strings.NewReader(testConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, "geoclue-demo-agent;gnome-shell", config["agent"]["whitelist"])
+	assert.Equal(t, "true", config["allowed-app"]["system"])
+	assert.Equal(t, "false", config["disallowed-app"]["allowed"])
+	_, err = parseGeoclueConfig(strings.NewReader("key=value\n"))
+	assert.Error(t, err)
+	_, err = parseGeoclueConfig(strings.NewReader("[section\n"))
+	assert.Error(t, err)
+}
+
+// fakeBus answers the calls diagnostics makes. Calls are identified by
+// destination, path and method; properties by their name.
+type fakeBus struct {
+	replies map[string][]interface{}
+	errors  map[string]error
+	calls   []string
+}
+
+func (b *fakeBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &geoclue2.MockBusObject{
+		DoCallWithContext: func(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+			key := fmt.Sprintf("%s %s %s", dest, path, method)
+			if method == propertiesGet || method == propertiesSet {
+				key = fmt.Sprintf("%s %s %v", dest, path, args[1])
+			}
+			b.calls = append(b.calls, key)
+			if err, ok := b.errors[key]; ok {
+				return &dbus.Call{Err: err}
+			}
+			body, ok := b.replies[key]
+			if !ok {
+				return &dbus.Call{Err: dbus.NewError("org.freedesktop.DBus.Error.UnknownObject", nil)}
+			}
+			return &dbus.Call{Body: body}
+		},
+	}
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{
+		replies: map[string][]interface{}{
+			"org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListActivatableNames": {
+				[]string{"org.freedesktop.DBus", geoclueName},
+			},
+			"org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListNames": {
+				[]string{"org.freedesktop.DBus", ":1.1", ":1.2"},
+			},
+			"org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.GetConnectionUnixProcessID": {
+				uint32(0),
+			},
+			geoclueName + " " + managerPath + " InUse":                  {dbus.MakeVariant(true)},
+			geoclueName + " " + managerPath + " AvailableAccuracyLevel": {dbus.MakeVariant(uint32(8))},
+			":1.2 " + agentPath + " MaxAccuracyLevel":                   {dbus.MakeVariant(uint32(8))},
+			geoclueName + " " + managerPath + " " + managerInterface + ".GetClient": {
+				dbus.ObjectPath("/org/freedesktop/GeoClue2/Client/1"),
+			},
+			geoclueName + " /org/freedesktop/GeoClue2/Client/1 DesktopId":                     {},
+			geoclueName + " /org/freedesktop/GeoClue2/Client/1 RequestedAccuracyLevel":        {},
+			geoclueName + " /org/freedesktop/GeoClue2/Client/1 " + clientInterface + ".Start": {},
+			geoclueName + " /org/freedesktop/GeoClue2/Client/1 " + clientInterface + ".Stop":  {},
+			geoclueName + " " + managerPath + " " + managerInterface + ".DeleteClient":        {},
+		},
+		errors: make(map[string]error),
+	}
+}
+
+func newTestDiagnostics(t *testing.T, bus *fakeBus, desktopID string) *diagnostics {
+	dir, err := ioutil.TempDir("", "geoclue2-diag")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "geoclue.conf")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(testConfig), 0644))
+	return &diagnostics{
+		bus:        bus,
+		desktopID:  desktopID,
+		level:      geoclue2.AccuracyExact,
+		configPath: path,
+		timeout:    time.Second,
+	}
+}
+
+func severities(d *diagnostics) []severity {
+	var ret []severity
+	for _, f := range d.findings {
+		ret = append(ret, f.severity)
+	}
+	return ret
+}
+
+func TestDiagnostics(t *testing.T) {
+	bus := newFakeBus()
+	d := newTestDiagnostics(t, bus, "allowed-app")
+	defer os.RemoveAll(filepath.Dir(d.configPath))
+	d.run()
+	assert.Equal(t, []severity{
+		severityOK, severityInfo, severityOK, severityOK, severityOK, severityOK,
+	}, severities(d))
+	assert.False(t, d.failed())
+	assert.Contains(t, d.findings[3].message, ":1.2")
+	assert.Contains(t, bus.calls, geoclueName+" "+managerPath+" "+managerInterface+".DeleteClient")
+	buf := &bytes.Buffer{}
+	assert.NoError(t, d.write(buf))
+	assert.Contains(t, buf.String(), "[OK  ] desktop ID \"allowed-app\" is authorized\n")
+}
+
+func TestDiagnosticsDenied(t *testing.T) {
+	bus := newFakeBus()
+	bus.replies[geoclueName+" "+managerPath+" AvailableAccuracyLevel"] = []interface{}{dbus.MakeVariant(uint32(4))}
+	delete(bus.replies, ":1.2 "+agentPath+" MaxAccuracyLevel")
+	bus.errors[geoclueName+" /org/freedesktop/GeoClue2/Client/1 "+clientInterface+".Start"] =
+		dbus.NewError(geoclue2.AccessDeniedError, []interface{}{"denied"})
+	d := newTestDiagnostics(t, bus, "disallowed-app")
+	defer os.RemoveAll(filepath.Dir(d.configPath))
+	d.run()
+	assert.Equal(t, []severity{
+		severityOK, severityInfo, severityWarning, severityWarning, severityFailure, severityFailure,
+	}, severities(d))
+	assert.True(t, d.failed())
+	for _, f := range d.findings[2:] {
+		assert.NotEmpty(t, f.hint, f.message)
+	}
+}
+
+func TestDiagnosticsNoService(t *testing.T) {
+	bus := newFakeBus()
+	bus.replies["org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListActivatableNames"] =
+		[]interface{}{[]string{}}
+	d := newTestDiagnostics(t, bus, "allowed-app")
+	defer os.RemoveAll(filepath.Dir(d.configPath))
+	d.run()
+	assert.Equal(t, []severity{severityFailure}, severities(d))
+}
+
+func TestDiagnosticsSessionBus(t *testing.T) {
+	bus := newFakeBus()
+	bus.errors["org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListActivatableNames"] =
+		fmt.Errorf("no bus")
+	d := newTestDiagnostics(t, bus, "allowed-app")
+	defer os.RemoveAll(filepath.Dir(d.configPath))
+	d.busSpec = "session"
+	d.run()
+	assert.Equal(t, []severity{severityFailure}, severities(d))
+	assert.Equal(t, "check that the DBus session bus is running", d.findings[0].hint)
+
+	bus = newFakeBus()
+	bus.replies["org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListActivatableNames"] =
+		[]interface{}{[]string{}}
+	bus.replies["org.freedesktop.DBus /org/freedesktop/DBus org.freedesktop.DBus.ListNames"] =
+		[]interface{}{[]string{}}
+	d.bus = bus
+	d.busSpec = "unix:path=/tmp/bus"
+	d.findings = nil
+	d.run()
+	assert.Equal(t, []severity{severityFailure}, severities(d))
+	assert.Contains(t, d.findings[0].message, "the DBus bus at unix:path=/tmp/bus")
+	assert.NotContains(t, d.findings[0].hint, "system")
+}
@@ -0,0 +1,221 @@
+// Command geoclue2 queries the location from the geoclue2 service.
+//
+// Usage:
+//
+//	geoclue2 [flags] <command> [command flags]
+//
+// Commands:
+//
+//	where   print the current location and exit
+//	watch   print location updates until interrupted
+//	diag    check why getting the location doesn't work
+//
+// The exit status is 0 on success, 1 on errors, 2 on invalid usage, 3 if
+// geoclue2 denied access to the location and 4 on timeout.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/ldx/go-geoclue2"
+)
+
+// Exit statuses.
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitUsage   = 2
+	exitDenied  = 3
+	exitTimeout = 4
+)
+
+// How often to check if geoclue2 denied access while waiting for a location.
+const errPollInterval = 100 * time.Millisecond
+
+var progName = filepath.Base(os.Args[0])
+
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands = map[string]command{
+	"where": {"print the current location and exit", runWhere},
+	"watch": {"print location updates until interrupted", runWatch},
+	"diag":  {"check why getting the location doesn't work", runDiag},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", progName)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n\nFlags:\n", progName)
+	flag.PrintDefaults()
+}
+
+func main() {
+	verbosity := flag.Int("v", 0, "log verbosity: 2 for info messages, 5 for debug messages")
+	flag.Usage = usage
+	flag.Parse()
+	geoclue2.SetDefaultLogger(geoclue2.NewTextLogger(os.Stderr, logLevel(*verbosity)))
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(cmd.run(flag.Args()[1:]))
+}
+
+// logLevel returns the lowest level logged with verbosity v: warnings and
+// errors by default, info messages from 2 and debug messages from 5.
+func logLevel(v int) geoclue2.Level {
+	switch {
+	case v >= 5:
+		return geoclue2.LevelDebug
+	case v >= 2:
+		return geoclue2.LevelInfo
+	}
+	return geoclue2.LevelWarn
+}
+
+// clientOptions are the flags shared by the commands that talk to geoclue2.
+type clientOptions struct {
+	bus       string
+	desktopID string
+	accuracy  string
+}
+
+func addClientFlags(fs *flag.FlagSet) *clientOptions {
+	opts := &clientOptions{}
+	fs.StringVar(&opts.bus, "bus", "system", "bus to find geoclue2 on: system, session or a DBus address, e.g. of a fakegeoclue private bus")
+	fs.StringVar(&opts.desktopID, "desktop-id", geoclue2.DefaultDesktopID, "desktop ID to identify as to geoclue2")
+	fs.StringVar(&opts.accuracy, "accuracy", "exact", "requested accuracy level: country, city, neighborhood, street or exact")
+	return opts
+}
+
+// dial returns a Dialer for the bus selected with -bus, whose errors say
+// which bus it is.
+func (o *clientOptions) dial() geoclue2.Dialer {
+	dial := geoclue2.BusDialer(o.bus)
+	return func() (*dbus.Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, fmt.Errorf("connecting to the %s bus: %v", o.bus, err)
+		}
+		return conn, nil
+	}
+}
+
+// connect creates a GeoClue2 configured with the options, connected to the
+// bus selected with -bus. Stopping it closes the connection.
+func (o *clientOptions) connect() (*geoclue2.GeoClue2, error) {
+	level, err := geoclue2.ParseAccuracyLevel(o.accuracy)
+	if err != nil {
+		return nil, err
+	}
+	gc2, err := geoclue2.Connect(o.dial(), o.desktopID)
+	if err != nil {
+		return nil, err
+	}
+	gc2.SetAccuracyLevel(level)
+	return gc2, nil
+}
+
+// watchAccess checks periodically if geoclue2 denied access to gc2, until ctx
+// is done. On denial, it sends the error to the returned channel and calls
+// cancel.
+func watchAccess(ctx context.Context, gc2 *geoclue2.GeoClue2, cancel func()) <-chan error {
+	denied := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(errPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := gc2.Err(); geoclue2.IsAccessDenied(err) {
+					denied <- err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return denied
+}
+
+// waitForLocation waits until gc2 gets a location or timeout passes. It gives
+// up early if geoclue2 denies access.
+func waitForLocation(gc2 *geoclue2.GeoClue2, timeout time.Duration) (*geoclue2.Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	denied := watchAccess(ctx, gc2, cancel)
+	loc, err := gc2.WaitForLocation(ctx)
+	if err == nil {
+		return loc, nil
+	}
+	select {
+	case err := <-denied:
+		return nil, err
+	default:
+	}
+	if err == context.DeadlineExceeded {
+		return nil, &timeoutError{timeout: timeout, last: gc2.Err()}
+	}
+	return nil, err
+}
+
+// timeoutError is returned when no location arrives in time.
+type timeoutError struct {
+	timeout time.Duration
+	// The last error setting up the client, if any.
+	last error
+}
+
+func (e *timeoutError) Error() string {
+	if e.last != nil {
+		return fmt.Sprintf("no location after %v, last error: %v", e.timeout, e.last)
+	}
+	return fmt.Sprintf("no location after %v", e.timeout)
+}
+
+// exitStatus reports err, and returns the exit status for it.
+func exitStatus(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+	switch {
+	case geoclue2.IsAccessDenied(err):
+		fmt.Fprintf(os.Stderr, "geoclue2 denied access to the location; check that location services are enabled, and that the desktop ID is allowed in /etc/geoclue/geoclue.conf\n")
+		return exitDenied
+	case isTimeout(err):
+		return exitTimeout
+	}
+	return exitError
+}
+
+func isTimeout(err error) bool {
+	_, ok := err.(*timeoutError)
+	return ok
+}
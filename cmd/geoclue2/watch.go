@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+)
+
+// How often the GPX file of the watch command is rewritten at most.
+const gpxWriteInterval = 30 * time.Second
+
+// Output formats of the watch command.
+var watchFormats = map[string]func(w io.Writer) geoclue2.LocationWriter{
+	"jsonl": func(w io.Writer) geoclue2.LocationWriter {
+		return geoclue2.NewJSONLinesWriter(w)
+	},
+	"csv": func(w io.Writer) geoclue2.LocationWriter {
+		// The default columns are always valid.
+		c, _ := geoclue2.NewCSVWriter(w)
+		return c
+	},
+	"nmea": func(w io.Writer) geoclue2.LocationWriter {
+		return geoclue2.NewNMEAWriter(w)
+	},
+}
+
+func runWatch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	opts := addClientFlags(fs)
+	format := fs.String("format", "jsonl", "output format: jsonl, csv or nmea")
+	minDistance := fs.Float64("min-distance", 0, "only print an update after moving this many meters")
+	minInterval := fs.Duration("min-interval", 0, "minimum time between updates printed")
+	gpxPath := fs.String("gpx", "", "also record the updates as a GPX track in this file")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	newWriter, ok := watchFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "invalid format %q\n", *format)
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	gc2, err := opts.connect()
+	if err != nil {
+		return exitStatus(err)
+	}
+	out := newOutput(newWriter(os.Stdout), *gpxPath)
+	ch := make(chan geoclue2.Location, 16)
+	gc2.Start()
+	defer gc2.Stop()
+	gc2.SubscribeFiltered(ch, geoclue2.SubscriptionFilter{
+		MinDistance: *minDistance,
+		MinInterval: *minInterval,
+	})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	denied := watchAccess(ctx, gc2, cancel)
+	for {
+		select {
+		case <-sigs:
+			return exitStatus(out.close())
+		case err := <-denied:
+			out.close()
+			return exitStatus(err)
+		case loc, ok := <-ch:
+			if !ok {
+				return exitStatus(out.close())
+			}
+			if err := out.write(loc); err != nil {
+				out.close()
+				return exitStatus(err)
+			}
+		}
+	}
+}
+
+// output writes location updates with a LocationWriter. If a GPX path is set,
+// it also records them in a track, and rewrites the GPX file at most every
+// gpxInterval and on close, so that only the last updates are missing if
+// the command is killed.
+type output struct {
+	w           geoclue2.LocationWriter
+	track       *geoclue2.Track
+	gpxPath     string
+	gpxInterval time.Duration
+	gpxWritten  time.Time
+	gpxPending  bool
+}
+
+func newOutput(w geoclue2.LocationWriter, gpxPath string) *output {
+	o := &output{
+		w:           w,
+		gpxPath:     gpxPath,
+		gpxInterval: gpxWriteInterval,
+	}
+	if gpxPath != "" {
+		o.track = geoclue2.NewTrack("geoclue2 watch")
+	}
+	return o
+}
+
+func (o *output) write(loc geoclue2.Location) error {
+	if err := o.w.Write(loc); err != nil {
+		return err
+	}
+	if o.track == nil {
+		return nil
+	}
+	o.track.Add(loc)
+	o.gpxPending = true
+	if time.Since(o.gpxWritten) < o.gpxInterval {
+		return nil
+	}
+	return o.writeGPX()
+}
+
+func (o *output) writeGPX() error {
+	o.gpxWritten = time.Now()
+	o.gpxPending = false
+	return writeGPXFile(o.gpxPath, o.track)
+}
+
+func (o *output) close() error {
+	err := o.w.Close()
+	if o.gpxPending {
+		if gpxErr := o.writeGPX(); err == nil {
+			err = gpxErr
+		}
+	}
+	return err
+}
+
+// writeGPXFile replaces the file at path with the track, via a temporary
+// file, so readers never see a partial file.
+func writeGPXFile(path string, track *geoclue2.Track) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	// TempFile creates the file readable only by the owner.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := track.WriteGPX(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
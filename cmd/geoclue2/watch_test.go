@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readGPX returns the latitudes of the track points in the GPX file at path.
+func readGPX(t *testing.T, path string) []float64 {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	var gpx struct {
+		Points []struct {
+			Lat float64 `xml:"lat,attr"`
+		} `xml:"trk>trkseg>trkpt"`
+	}
+	assert.NoError(t, xml.Unmarshal(data, &gpx))
+	var lats []float64
+	for _, p := range gpx.Points {
+		lats = append(lats, p.Lat)
+	}
+	return lats
+}
+
+func TestOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoclue2-watch")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "track.gpx")
+
+	buf := &bytes.Buffer{}
+	out := newOutput(watchFormats["csv"](buf), path)
+	loc := testLocation()
+	assert.NoError(t, out.write(loc))
+	assert.Len(t, readGPX(t, path), 1)
+	loc.Latitude += 0.001
+	loc.Timestamp.Seconds++
+	assert.NoError(t, out.write(loc))
+	// The file isn't rewritten until gpxInterval has passed, or on close.
+	assert.Len(t, readGPX(t, path), 1)
+	assert.NoError(t, out.close())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "time,latitude,longitude,accuracy,altitude,speed,heading", lines[0])
+
+	points := readGPX(t, path)
+	assert.Len(t, points, 2)
+	assert.Equal(t, 47.4989, points[1])
+	// Only the GPX file is left behind.
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, os.FileMode(0644), files[0].Mode().Perm())
+}
+
+func TestWatchFormats(t *testing.T) {
+	for _, format := range []string{"jsonl", "csv", "nmea"} {
+		buf := &bytes.Buffer{}
+		w := watchFormats[format](buf)
+		assert.NoError(t, w.Write(testLocation()))
+		assert.NoError(t, w.Close())
+		assert.NotEmpty(t, buf.String(), format)
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, newOutput(watchFormats["nmea"](buf), "").write(testLocation()))
+	assert.True(t, strings.HasPrefix(buf.String(), "$GPGGA,120000.00,4729.87400,N,01902.41200,E,"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+)
+
+// Output formats of the where command.
+var whereFormats = map[string]func(w io.Writer, loc geoclue2.Location) error{
+	"text":    writeText,
+	"json":    writeJSON,
+	"geojson": writeGeoJSON,
+	"shell":   writeShell,
+}
+
+func runWhere(args []string) int {
+	fs := flag.NewFlagSet("where", flag.ContinueOnError)
+	opts := addClientFlags(fs)
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for a location")
+	format := fs.String("format", "text", "output format: text, json, geojson or shell")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	write, ok := whereFormats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "invalid format %q\n", *format)
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	gc2, err := opts.connect()
+	if err != nil {
+		return exitStatus(err)
+	}
+	gc2.Start()
+	defer gc2.Stop()
+	loc, err := waitForLocation(gc2, *timeout)
+	if err != nil {
+		return exitStatus(err)
+	}
+	return exitStatus(write(os.Stdout, *loc))
+}
+
+func writeText(w io.Writer, loc geoclue2.Location) error {
+	_, err := fmt.Fprintln(w, loc.String())
+	return err
+}
+
+func writeJSON(w io.Writer, loc geoclue2.Location) error {
+	return json.NewEncoder(w).Encode(loc)
+}
+
+// writeGeoJSON writes the location as a GeoJSON Point feature, with the rest
+// of the location in its properties.
+func writeGeoJSON(w io.Writer, loc geoclue2.Location) error {
+	props, err := json.Marshal(loc)
+	if err != nil {
+		return err
+	}
+	coords := []float64{loc.Longitude, loc.Latitude}
+	if alt, ok := loc.AltitudeMeters(); ok {
+		coords = append(coords, alt)
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type":        "Point",
+			"coordinates": coords,
+		},
+		"properties": json.RawMessage(props),
+	})
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellFloat(v float64, known bool) string {
+	if !known {
+		return "''"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// writeShell writes the location as shell variable assignments, for
+// eval "$(geoclue2 where -format shell)". Unknown values are empty.
+func writeShell(w io.Writer, loc geoclue2.Location) error {
+	vars := []struct {
+		name, value string
+	}{
+		{"LATITUDE", shellFloat(loc.Latitude, true)},
+		{"LONGITUDE", shellFloat(loc.Longitude, true)},
+		{"ACCURACY", shellFloat(loc.Accuracy, true)},
+		{"ALTITUDE", shellFloat(loc.AltitudeMeters())},
+		{"SPEED", shellFloat(loc.SpeedMetersPerSecond())},
+		{"HEADING", shellFloat(loc.HeadingDegrees())},
+		{"DESCRIPTION", shellQuote(loc.Description)},
+		{"TIMESTAMP", shellQuote(loc.Timestamp.String())},
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", v.name, v.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
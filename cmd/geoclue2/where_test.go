@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ldx/go-geoclue2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testLocation() geoclue2.Location {
+	return geoclue2.Location{
+		Latitude:    47.4979,
+		Longitude:   19.0402,
+		Accuracy:    25,
+		Altitude:    geoclue2.UnknownAltitude,
+		Speed:       1.5,
+		Heading:     geoclue2.UnknownHeading,
+		Description: "Budapest's center",
+		Timestamp:   geoclue2.FromTime(time.Date(2020, 6, 21, 12, 0, 0, 0, time.UTC)),
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, writeText(b, testLocation()))
+	assert.Equal(t, "47.497900,19.040200 ±25m 1.5m/s \"Budapest's center\"\n", b.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, writeJSON(b, testLocation()))
+	var loc geoclue2.Location
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &loc))
+	assert.Equal(t, testLocation(), loc)
+}
+
+func TestWriteGeoJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, writeGeoJSON(b, testLocation()))
+	var feature struct {
+		Type     string
+		Geometry struct {
+			Type        string
+			Coordinates []float64
+		}
+		Properties map[string]interface{}
+	}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &feature))
+	assert.Equal(t, "Feature", feature.Type)
+	assert.Equal(t, "Point", feature.Geometry.Type)
+	assert.Equal(t, []float64{19.0402, 47.4979}, feature.Geometry.Coordinates)
+	assert.Equal(t, 25.0, feature.Properties["accuracy"])
+	assert.Nil(t, feature.Properties["heading"])
+	assert.Equal(t, "2020-06-21T12:00:00Z", feature.Properties["timestamp"])
+}
+
+func TestWriteShell(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, writeShell(b, testLocation()))
+	assert.Equal(t, `LATITUDE=47.4979
+LONGITUDE=19.0402
+ACCURACY=25
+ALTITUDE=''
+SPEED=1.5
+HEADING=''
+DESCRIPTION='Budapest'\''s center'
+TIMESTAMP='2020-06-21T12:00:00Z'
+`, b.String())
+}
+
+func TestExitStatus(t *testing.T) {
+	assert.Equal(t, exitOK, exitStatus(nil))
+	assert.Equal(t, exitTimeout, exitStatus(&timeoutError{timeout: time.Second}))
+	assert.Equal(t, exitError, exitStatus(assert.AnError))
+}
@@ -2,43 +2,39 @@ package main
 
 import (
 	"flag"
+	"log"
 	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	dbus "github.com/godbus/dbus/v5"
 	"github.com/ldx/go-geoclue2"
-	"k8s.io/klog"
 )
 
 func main() {
-	klog.InitFlags(nil)
+	bus := flag.String("bus", "system", "Bus to connect to: system, session or a bus address")
 	flag.Parse()
 
-	conn, err := dbus.SystemBus()
+	gc2, err := geoclue2.Connect(geoclue2.BusDialer(*bus), "")
 	if err != nil {
 		panic(err)
 	}
-	defer conn.Close()
-
-	gc2 := geoclue2.NewGeoClue2(conn, "")
 	gc2.Start()
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
 	for {
 		t := rand.Intn(10)
 		select {
 		case <-ch:
-			klog.Infof("stopping")
+			log.Printf("stopping")
 			gc2.Stop()
-			klog.Infof("stopped")
+			log.Printf("stopped")
 			return
 		case <-time.After(time.Duration(t) * time.Second):
 			loc := gc2.GetLatestLocation()
-			klog.Infof("latest location: %+v\n", loc)
+			log.Printf("latest location: %+v", loc)
 			continue
 		}
 	}
@@ -3,41 +3,37 @@ package main
 import (
 	"context"
 	"flag"
+	"log"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 
-	dbus "github.com/godbus/dbus/v5"
 	"github.com/ldx/go-geoclue2"
-	"k8s.io/klog"
 )
 
 func main() {
 	workers := flag.Int("workers", 3, "Number of workers receiving updates")
-	klog.InitFlags(nil)
+	bus := flag.String("bus", "system", "Bus to connect to: system, session or a bus address")
 	flag.Parse()
 
-	conn, err := dbus.SystemBus()
+	gc2, err := geoclue2.Connect(geoclue2.BusDialer(*bus), "")
 	if err != nil {
 		panic(err)
 	}
-	defer conn.Close()
-
-	gc2 := geoclue2.NewGeoClue2(conn, "")
 	gc2.Start()
 
 	wg := sync.WaitGroup{}
 	ctx, cancel := context.WithCancel(context.Background())
 	for i := 0; i < *workers; i++ {
+		wg.Add(1)
 		go func(j int) {
-			wg.Add(1)
 			defer wg.Done()
 			loc, err := gc2.WaitForLocation(ctx)
 			if err != nil {
-				klog.Infof("waiting for location (client %d): %v\n", j, err)
+				log.Printf("waiting for location (client %d): %v", j, err)
 			}
-			klog.Infof("location update (client %d): %+v\n", j, loc)
+			log.Printf("location update (client %d): %+v", j, loc)
 		}(i)
 	}
 
@@ -47,7 +43,7 @@ func main() {
 		done <- struct{}{}
 	}()
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	for {
 		select {
@@ -0,0 +1,418 @@
+package geoclue2
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ldx/go-geoclue2/nmea"
+)
+
+// LocationWriter writes a stream of locations, e.g. to a file.
+type LocationWriter interface {
+	// Write writes a location.
+	Write(loc Location) error
+	// Close finishes the output. It doesn't close the underlying writer.
+	Close() error
+}
+
+// Stream feeds the location updates of a provider to a LocationWriter.
+type Stream struct {
+	w      LocationWriter
+	lock   sync.Mutex
+	err    error
+	logger Logger
+	sub    subscription
+}
+
+// NewStream subscribes to p and writes all location updates it broadcasts to
+// w, until Stop is called. Write errors are logged with the Logger set with
+// SetDefaultLogger, and the first one is returned by Stop.
+func NewStream(p Provider, w LocationWriter) *Stream {
+	s := &Stream{w: w, logger: DefaultLogger()}
+	s.sub.start(p, func(loc Location) {
+		if err := s.w.Write(loc); err != nil {
+			s.logger.Warn("writing location", "error", err)
+			s.lock.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.lock.Unlock()
+		}
+	})
+	return s
+}
+
+// Stop unsubscribes from the provider and closes the writer. It returns the
+// first error encountered writing locations or closing the writer.
+func (s *Stream) Stop() error {
+	s.sub.stop()
+	err := s.w.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// CSVColumn is a column of the CSV output.
+type CSVColumn string
+
+// The columns CSVWriter can produce.
+const (
+	CSVTime        CSVColumn = "time"
+	CSVLatitude    CSVColumn = "latitude"
+	CSVLongitude   CSVColumn = "longitude"
+	CSVAccuracy    CSVColumn = "accuracy"
+	CSVAltitude    CSVColumn = "altitude"
+	CSVSpeed       CSVColumn = "speed"
+	CSVHeading     CSVColumn = "heading"
+	CSVDescription CSVColumn = "description"
+	CSVSource      CSVColumn = "source"
+)
+
+// DefaultCSVColumns are the columns written if none are specified.
+var DefaultCSVColumns = []CSVColumn{
+	CSVTime, CSVLatitude, CSVLongitude, CSVAccuracy, CSVAltitude, CSVSpeed, CSVHeading,
+}
+
+// CSVWriter is a LocationWriter producing CSV with a header row. Unknown
+// altitudes, speeds and headings are written as empty cells, and times in RFC
+// 3339 format.
+type CSVWriter struct {
+	w             *csv.Writer
+	columns       []CSVColumn
+	headerWritten bool
+}
+
+// NewCSVWriter creates a CSV writer with the given columns, or
+// DefaultCSVColumns if none are given.
+func NewCSVWriter(w io.Writer, columns ...CSVColumn) (*CSVWriter, error) {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	for _, c := range columns {
+		switch c {
+		case CSVTime, CSVLatitude, CSVLongitude, CSVAccuracy, CSVAltitude,
+			CSVSpeed, CSVHeading, CSVDescription, CSVSource:
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q", c)
+		}
+	}
+	return &CSVWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+	}, nil
+}
+
+// ParseCSVColumns parses a comma separated list of column names.
+func ParseCSVColumns(s string) []CSVColumn {
+	var columns []CSVColumn
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			columns = append(columns, CSVColumn(c))
+		}
+	}
+	return columns
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Write writes loc as a CSV record, preceded by the header for the first
+// record.
+func (c *CSVWriter) Write(loc Location) error {
+	if !c.headerWritten {
+		header := make([]string, len(c.columns))
+		for i, col := range c.columns {
+			header[i] = string(col)
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	record := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		switch col {
+		case CSVTime:
+			record[i] = loc.Timestamp.String()
+		case CSVLatitude:
+			record[i] = formatFloat(loc.Latitude)
+		case CSVLongitude:
+			record[i] = formatFloat(loc.Longitude)
+		case CSVAccuracy:
+			record[i] = formatFloat(loc.Accuracy)
+		case CSVAltitude:
+			if loc.HasAltitude() {
+				record[i] = formatFloat(loc.Altitude)
+			}
+		case CSVSpeed:
+			if loc.HasSpeed() {
+				record[i] = formatFloat(loc.Speed)
+			}
+		case CSVHeading:
+			if loc.HasHeading() {
+				record[i] = formatFloat(loc.Heading)
+			}
+		case CSVDescription:
+			record[i] = loc.Description
+		case CSVSource:
+			record[i] = loc.Source
+		}
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	// Flush after every record, so the output can be followed live.
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Close flushes any buffered data.
+func (c *CSVWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// JSONLinesWriter is a LocationWriter producing one JSON object per line, as
+// encoded by Location.MarshalJSON.
+type JSONLinesWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONLinesWriter creates a JSON lines writer.
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	return &JSONLinesWriter{enc: json.NewEncoder(w)}
+}
+
+// Write writes loc as a line of JSON.
+func (j *JSONLinesWriter) Write(loc Location) error {
+	return j.enc.Encode(loc)
+}
+
+// Close is a no-op, there's nothing to finish.
+func (j *JSONLinesWriter) Close() error {
+	return nil
+}
+
+// NMEAWriter is a LocationWriter producing NMEA 0183 sentences, so locations
+// can be fed to software expecting a GPS receiver. Every location is written
+// as a GGA and an RMC sentence. The HDOP is estimated from the accuracy, the
+// inverse of what NMEAProvider does.
+type NMEAWriter struct {
+	w io.Writer
+}
+
+// NewNMEAWriter creates an NMEA writer.
+func NewNMEAWriter(w io.Writer) *NMEAWriter {
+	return &NMEAWriter{w: w}
+}
+
+// Write writes loc as GGA and RMC sentences.
+func (n *NMEAWriter) Write(loc Location) error {
+	var t nmea.Time
+	var d nmea.Date
+	if !loc.Timestamp.IsZero() {
+		ts := loc.Timestamp.Time().UTC()
+		t = nmea.Time{
+			Valid:       true,
+			Hour:        ts.Hour(),
+			Minute:      ts.Minute(),
+			Second:      ts.Second(),
+			Millisecond: ts.Nanosecond() / int(time.Millisecond),
+		}
+		d = nmea.Date{
+			Valid: true,
+			Day:   ts.Day(),
+			Month: int(ts.Month()),
+			Year:  ts.Year(),
+		}
+	}
+	unknown := math.NaN()
+	gga := &nmea.GGA{
+		Time:          t,
+		Latitude:      loc.Latitude,
+		Longitude:     loc.Longitude,
+		FixQuality:    1,
+		NumSatellites: -1,
+		HDOP:          unknown,
+		Altitude:      unknown,
+		Separation:    unknown,
+	}
+	if loc.Accuracy > 0 {
+		gga.HDOP = loc.Accuracy / nmeaUERE
+	}
+	if alt, ok := loc.AltitudeMeters(); ok {
+		gga.Altitude = alt
+	}
+	rmc := &nmea.RMC{
+		Time:              t,
+		Valid:             true,
+		Latitude:          loc.Latitude,
+		Longitude:         loc.Longitude,
+		SpeedKnots:        unknown,
+		Course:            unknown,
+		Date:              d,
+		MagneticVariation: unknown,
+		Mode:              "A",
+	}
+	if speed, ok := loc.SpeedMetersPerSecond(); ok {
+		rmc.SpeedKnots = speed / knotsToMetersPerSecond
+	}
+	if heading, ok := loc.HeadingDegrees(); ok {
+		rmc.Course = heading
+	}
+	b := &strings.Builder{}
+	for _, s := range []nmea.Sentence{gga, rmc} {
+		line, err := nmea.Format(s)
+		if err != nil {
+			return err
+		}
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	_, err := io.WriteString(n.w, b.String())
+	return err
+}
+
+// Close is a no-op, there's nothing to finish.
+func (n *NMEAWriter) Close() error {
+	return nil
+}
+
+// Number of vertices of the polygon approximating the accuracy circle.
+const kmlCircleVertices = 36
+
+// KMLWriter is a LocationWriter producing a KML document, e.g. for Google
+// Earth. Every location becomes a Placemark with a point and a polygon
+// showing the accuracy circle around it.
+type KMLWriter struct {
+	w             io.Writer
+	name          string
+	headerWritten bool
+	count         int
+}
+
+// NewKMLWriter creates a KML writer. The name is used for the KML document.
+func NewKMLWriter(w io.Writer, name string) *KMLWriter {
+	return &KMLWriter{
+		w:    w,
+		name: name,
+	}
+}
+
+const kmlHeader = `<kml xmlns="http://www.opengis.net/kml/2.2">
+<Document>
+<name>%s</name>
+<Style id="fix">
+  <IconStyle><scale>0.6</scale></IconStyle>
+  <LineStyle><color>ffff7f00</color><width>1</width></LineStyle>
+  <PolyStyle><color>40ff7f00</color></PolyStyle>
+</Style>
+`
+
+func xmlEscape(s string) string {
+	b := &strings.Builder{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+func (k *KMLWriter) writeHeader() error {
+	if k.headerWritten {
+		return nil
+	}
+	k.headerWritten = true
+	if _, err := io.WriteString(k.w, xml.Header); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(k.w, kmlHeader, xmlEscape(k.name))
+	return err
+}
+
+// Write writes loc as a Placemark.
+func (k *KMLWriter) Write(loc Location) error {
+	if err := k.writeHeader(); err != nil {
+		return err
+	}
+	k.count++
+	b := &strings.Builder{}
+	b.WriteString("<Placemark>\n")
+	fmt.Fprintf(b, "  <name>%d</name>\n", k.count)
+	if ts := loc.Timestamp.String(); ts != "" {
+		fmt.Fprintf(b, "  <TimeStamp><when>%s</when></TimeStamp>\n", ts)
+	}
+	b.WriteString("  <styleUrl>#fix</styleUrl>\n")
+	b.WriteString("  <description>")
+	b.WriteString(xmlEscape(kmlDescription(loc)))
+	b.WriteString("</description>\n")
+	b.WriteString("  <MultiGeometry>\n")
+	point := formatFloat(loc.Longitude) + "," + formatFloat(loc.Latitude)
+	if loc.HasAltitude() {
+		point += "," + formatFloat(loc.Altitude)
+	}
+	fmt.Fprintf(b, "    <Point><coordinates>%s</coordinates></Point>\n", point)
+	if loc.Accuracy > 0 {
+		b.WriteString("    <Polygon><outerBoundaryIs><LinearRing><coordinates>")
+		for i, p := range accuracyCircle(loc.Latitude, loc.Longitude, loc.Accuracy) {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(formatFloat(p[1]) + "," + formatFloat(p[0]))
+		}
+		b.WriteString("</coordinates></LinearRing></outerBoundaryIs></Polygon>\n")
+	}
+	b.WriteString("  </MultiGeometry>\n")
+	b.WriteString("</Placemark>\n")
+	_, err := io.WriteString(k.w, b.String())
+	return err
+}
+
+func kmlDescription(loc Location) string {
+	parts := []string{fmt.Sprintf("accuracy: %s m", formatFloat(loc.Accuracy))}
+	if loc.HasAltitude() {
+		parts = append(parts, fmt.Sprintf("altitude: %s m", formatFloat(loc.Altitude)))
+	}
+	if loc.HasSpeed() {
+		parts = append(parts, fmt.Sprintf("speed: %s m/s", formatFloat(loc.Speed)))
+	}
+	if loc.HasHeading() {
+		parts = append(parts, fmt.Sprintf("heading: %s°", formatFloat(loc.Heading)))
+	}
+	if loc.Description != "" {
+		parts = append(parts, loc.Description)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// accuracyCircle returns a closed ring of [lat, lon] pairs approximating a
+// circle with the given radius in meters around a point.
+func accuracyCircle(lat, lon, radius float64) [][2]float64 {
+	ring := make([][2]float64, 0, kmlCircleVertices+1)
+	for i := 0; i <= kmlCircleVertices; i++ {
+		bearing := 360 * float64(i%kmlCircleVertices) / kmlCircleVertices
+		lat2, lon2 := sphericalDestination(lat, lon, bearing, radius)
+		ring = append(ring, [2]float64{lat2, lon2})
+	}
+	return ring
+}
+
+// Close writes the end of the KML document. An empty document is written if
+// no location has been written.
+func (k *KMLWriter) Close() error {
+	if err := k.writeHeader(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(k.w, "</Document>\n</kml>\n")
+	return err
+}
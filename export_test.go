@@ -0,0 +1,152 @@
+package geoclue2
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVWriter(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	w, err := NewCSVWriter(buf)
+	assert.NoError(t, err)
+	loc := fix(47.5, 19.05, 10, start)
+	loc.Altitude = 120.5
+	loc.Speed = 0
+	loc.Heading = 90
+	assert.NoError(t, w.Write(loc))
+	assert.NoError(t, w.Write(fix(47.6, 19.06, 20, start.Add(time.Second))))
+	assert.NoError(t, w.Close())
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"time", "latitude", "longitude", "accuracy", "altitude", "speed", "heading"},
+		{"2020-09-13T12:26:40Z", "47.5", "19.05", "10", "120.5", "0", "90"},
+		{"2020-09-13T12:26:41Z", "47.6", "19.06", "20", "", "", ""},
+	}, records)
+}
+
+func TestCSVWriterColumns(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewCSVWriter(buf, ParseCSVColumns("latitude, longitude,description,source")...)
+	assert.NoError(t, err)
+	loc := fix(1, 2, 3, time.Now())
+	loc.Description = "Main St, Springfield"
+	loc.Source = "gps"
+	assert.NoError(t, w.Write(loc))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "latitude,longitude,description,source\n1,2,\"Main St, Springfield\",gps\n", buf.String())
+
+	_, err = NewCSVWriter(buf, "latitude", "bogus")
+	assert.Error(t, err)
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	w := NewJSONLinesWriter(buf)
+	assert.NoError(t, w.Write(fix(47.5, 19.05, 10, start)))
+	assert.NoError(t, w.Write(fix(47.6, 19.06, 20, start.Add(time.Second))))
+	assert.NoError(t, w.Close())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	var loc Location
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &loc))
+	assert.Equal(t, fix(47.6, 19.06, 20, start.Add(time.Second)), loc)
+}
+
+func TestNMEAWriter(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	w := NewNMEAWriter(buf)
+	loc := fix(-33.8688, 151.2093, 10, start)
+	loc.Altitude = 58
+	loc.Speed = 5
+	loc.Heading = 270
+	assert.NoError(t, w.Write(loc))
+	assert.NoError(t, w.Write(fix(-33.87, 151.21, 20, start.Add(time.Second))))
+	assert.NoError(t, w.Close())
+	assert.True(t, strings.HasPrefix(buf.String(), "$GPGGA,122640.00,3352.12800,S,15112.55800,E,1,,2.0,58.0,M,,,,*"))
+
+	// NMEAProvider reads back what NMEAWriter writes.
+	p := NewNMEAProvider(strings.NewReader(buf.String()))
+	ch := make(chan Location, 10)
+	p.broadcaster.start()
+	p.Subscribe(ch)
+	p.wg.Add(1)
+	go p.readLoop()
+	got := receive(t, ch)
+	assert.InDelta(t, loc.Latitude, got.Latitude, 1e-6)
+	assert.InDelta(t, loc.Longitude, got.Longitude, 1e-6)
+	assert.InDelta(t, loc.Accuracy, got.Accuracy, 1e-9)
+	assert.Equal(t, loc.Altitude, got.Altitude)
+	assert.InDelta(t, loc.Speed, got.Speed, 0.05)
+	assert.Equal(t, loc.Heading, got.Heading)
+	assert.Equal(t, loc.Timestamp, got.Timestamp)
+	p.Stop()
+}
+
+func TestKMLWriter(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	w := NewKMLWriter(buf, "trip <1>")
+	loc := fix(47.5, 19.05, 100, start)
+	loc.Altitude = 120.5
+	assert.NoError(t, w.Write(loc))
+	assert.NoError(t, w.Write(fix(47.6, 19.06, 0, start.Add(time.Second))))
+	assert.NoError(t, w.Close())
+	out := buf.String()
+	assert.Contains(t, out, "<name>trip &lt;1&gt;</name>")
+	assert.Contains(t, out, "<when>2020-09-13T12:26:40Z</when>")
+	assert.Contains(t, out, "<coordinates>19.05,47.5,120.5</coordinates>")
+	assert.Contains(t, out, "<coordinates>19.06,47.6</coordinates>")
+	assert.Equal(t, 2, strings.Count(out, "<Placemark>"))
+	assert.Equal(t, 1, strings.Count(out, "<Polygon>"))
+
+	var doc struct {
+		Placemarks []struct {
+			Ring string `xml:"MultiGeometry>Polygon>outerBoundaryIs>LinearRing>coordinates"`
+		} `xml:"Document>Placemark"`
+	}
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Len(t, doc.Placemarks, 2)
+	ring := strings.Fields(doc.Placemarks[0].Ring)
+	assert.Len(t, ring, kmlCircleVertices+1)
+	assert.Equal(t, ring[0], ring[len(ring)-1])
+}
+
+func TestAccuracyCircle(t *testing.T) {
+	ring := accuracyCircle(0, 0, 1000)
+	// 1 km is about 0.009 degrees at the equator.
+	assert.InDelta(t, 0.008993, ring[0][0], 1e-6)
+	assert.InDelta(t, 0, ring[0][1], 1e-9)
+	quarter := ring[kmlCircleVertices/4]
+	assert.InDelta(t, 0, quarter[0], 1e-9)
+	assert.InDelta(t, 0.008993, quarter[1], 1e-6)
+	for _, p := range accuracyCircle(10, 179.9999, 1000) {
+		assert.True(t, math.Abs(p[1]) <= 180)
+	}
+}
+
+func TestStream(t *testing.T) {
+	src := newTestProvider()
+	src.Start()
+	buf := &bytes.Buffer{}
+	w, err := NewCSVWriter(buf, CSVLatitude)
+	assert.NoError(t, err)
+	s := NewStream(src, w)
+	src.publish(fix(1, 1, 1, time.Now()))
+	src.publish(fix(2, 2, 1, time.Now()))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, s.Stop())
+	src.Stop()
+	assert.Equal(t, "latitude\n1\n2\n", buf.String())
+}
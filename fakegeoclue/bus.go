@@ -0,0 +1,93 @@
+package fakegeoclue
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	dbus "github.com/godbus/dbus/v5"
+)
+
+// Configuration of the private bus. Everybody may own any name and talk to
+// anybody, like on a session bus.
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// Bus is a private dbus-daemon.
+type Bus struct {
+	// Address of the bus, e.g. for ServeBus.
+	Address string
+	cmd     *exec.Cmd
+	dir     string
+}
+
+// StartBus starts a private dbus-daemon. It needs dbus-daemon in PATH.
+func StartBus() (*Bus, error) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "fakegeoclue")
+	if err != nil {
+		return nil, err
+	}
+	config := filepath.Join(dir, "bus.conf")
+	socket := filepath.Join(dir, "bus")
+	if err := ioutil.WriteFile(config, []byte(fmt.Sprintf(busConfig, socket)), 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	b := &Bus{
+		cmd: cmd,
+		dir: dir,
+	}
+	// The daemon prints its address once it's ready to accept connections.
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("reading address of dbus-daemon: %v", err)
+	}
+	b.Address = strings.TrimSpace(address)
+	return b, nil
+}
+
+// Connect opens a new connection to the bus.
+func (b *Bus) Connect() (*dbus.Conn, error) {
+	return dial(b.Address)
+}
+
+// Close stops the daemon and removes its socket.
+func (b *Bus) Close() error {
+	defer os.RemoveAll(b.dir)
+	if err := b.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	// Wait fails with the signal that killed the daemon.
+	b.cmd.Wait()
+	return nil
+}
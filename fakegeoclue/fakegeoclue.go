@@ -0,0 +1,576 @@
+// Package fakegeoclue serves a fake geoclue2 service over DBus, for
+// integration tests of geoclue2 clients. It exports the same object tree as
+// geoclue2: a manager that hands out clients, clients that publish location
+// objects and emit LocationUpdated signals when started. Tests script it with
+// locations, errors, deactivation of clients and restarts of the service.
+//
+// The service can be served on a peer connection, without a bus, or on a
+// private dbus-daemon started with StartBus.
+package fakegeoclue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	geoclue2 "github.com/ldx/go-geoclue2"
+)
+
+// Names and paths of the geoclue2 object tree.
+const (
+	BusName           = "org.freedesktop.GeoClue2"
+	ManagerPath       = dbus.ObjectPath("/org/freedesktop/GeoClue2/Manager")
+	ManagerInterface  = "org.freedesktop.GeoClue2.Manager"
+	ClientInterface   = "org.freedesktop.GeoClue2.Client"
+	LocationInterface = "org.freedesktop.GeoClue2.Location"
+	propertiesIface   = "org.freedesktop.DBus.Properties"
+	clientPathPrefix  = "/org/freedesktop/GeoClue2/Client/"
+	locationPrefix    = "/org/freedesktop/GeoClue2/Location/"
+	// Location objects are kept around for a while after they're replaced,
+	// so clients can still read them when they get to process the update.
+	keptLocations = 8
+)
+
+// Methods that can be made to fail with SetError.
+const (
+	MethodGetClient    = "GetClient"
+	MethodCreateClient = "CreateClient"
+	MethodDeleteClient = "DeleteClient"
+	MethodStart        = "Start"
+	MethodStop         = "Stop"
+)
+
+// Client is the state of a client of the service.
+type Client struct {
+	Path                   dbus.ObjectPath
+	DesktopID              string
+	RequestedAccuracyLevel geoclue2.AccuracyLevel
+	DistanceThreshold      uint32
+	TimeThreshold          uint32
+	Active                 bool
+}
+
+// client is a client object exported by the service.
+type client struct {
+	path  dbus.ObjectPath
+	owner string
+	props *prop.Properties
+	// The last location sent to the client, used for the thresholds.
+	last *geoclue2.Location
+}
+
+// Service is a fake geoclue2 service. Its methods are safe for concurrent
+// use.
+type Service struct {
+	lock         sync.Mutex
+	conn         *dbus.Conn
+	managerProps *prop.Properties
+	clients      map[dbus.ObjectPath]*client
+	// Clients returned by GetClient, by the bus name of their owner.
+	owned        map[string]*client
+	nextClient   int
+	nextLocation int
+	location     *geoclue2.Location
+	locationPath dbus.ObjectPath
+	accuracy     geoclue2.AccuracyLevel // AvailableAccuracyLevel of the manager.
+	locations    []dbus.ObjectPath
+	errors       map[string]*dbus.Error
+	denied       map[string]bool
+	logger       geoclue2.Logger
+	quit         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// New creates a service. It has to be served on a connection with Serve,
+// ServePeer or ServeBus.
+func New() *Service {
+	return &Service{
+		clients:      make(map[dbus.ObjectPath]*client),
+		owned:        make(map[string]*client),
+		locationPath: "/",
+		accuracy:     geoclue2.AccuracyExact,
+		errors:       make(map[string]*dbus.Error),
+		denied:       make(map[string]bool),
+		logger:       geoclue2.DefaultLogger(),
+		quit:         make(chan struct{}),
+	}
+}
+
+// SetLogger sets the Logger of the service. By default, it's the one set with
+// geoclue2.SetDefaultLogger. A nil Logger discards all messages. It has to be
+// called before serving the service.
+func (s *Service) SetLogger(l geoclue2.Logger) {
+	if l == nil {
+		l = geoclue2.DiscardLogger
+	}
+	s.logger = l
+}
+
+// Serve exports the manager on conn. It doesn't request the geoclue2 bus
+// name, see ServeName. The location and accuracy level set before are served
+// from the start.
+func (s *Service) Serve(conn *dbus.Conn) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conn = conn
+	props, err := prop.Export(conn, ManagerPath, map[string]map[string]*prop.Prop{
+		ManagerInterface: {
+			"InUse":                  {Value: false, Emit: prop.EmitTrue},
+			"AvailableAccuracyLevel": {Value: uint32(s.accuracy), Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.managerProps = props
+	if s.location != nil {
+		if err := s.setLocationLocked(*s.location); err != nil {
+			return err
+		}
+	}
+	return conn.Export(&manager{s: s}, ManagerPath, ManagerInterface)
+}
+
+// ServeBus connects to the bus at address, exports the manager and claims
+// the geoclue2 bus name.
+func (s *Service) ServeBus(address string) error {
+	conn, err := dial(address)
+	if err != nil {
+		return err
+	}
+	if err := s.ServeName(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// ServeName exports the manager on conn, a connection to a bus, and claims
+// the geoclue2 bus name. It fails if the name is already owned.
+func (s *Service) ServeName(conn *dbus.Conn) error {
+	if err := s.Serve(conn); err != nil {
+		return err
+	}
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("%s is already owned", BusName)
+	}
+	return nil
+}
+
+// ServePeer serves the service on one end of a peer connection, and returns
+// the other end for the client under test. Closing the service closes the
+// connection.
+func (s *Service) ServePeer() (*dbus.Conn, error) {
+	clientConn, serverConn, err := peerConns()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Serve(serverConn); err != nil {
+		clientConn.Close()
+		serverConn.Close()
+		return nil, err
+	}
+	return clientConn, nil
+}
+
+// Close stops playing locations and closes the connection of the service,
+// as if it crashed.
+func (s *Service) Close() error {
+	s.lock.Lock()
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+	conn := s.conn
+	s.lock.Unlock()
+	s.wg.Wait()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// SetAvailableAccuracyLevel sets the accuracy level reported by the manager.
+// AccuracyNone means location services are disabled.
+func (s *Service) SetAvailableAccuracyLevel(level geoclue2.AccuracyLevel) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accuracy = level
+	if s.managerProps != nil {
+		s.managerProps.SetMust(ManagerInterface, "AvailableAccuracyLevel", uint32(level))
+	}
+}
+
+// SetError makes all calls of method, one of the Method constants, fail with
+// err, until it's reset with a nil err.
+func (s *Service) SetError(method string, err *dbus.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil {
+		delete(s.errors, method)
+		return
+	}
+	s.errors[method] = err
+}
+
+// Deny makes starting clients with desktopID fail with an access denied
+// error, like when the user or the agent doesn't authorize the application.
+func (s *Service) Deny(desktopID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.denied[desktopID] = true
+}
+
+// Clients returns the state of the current clients.
+func (s *Service) Clients() []Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := make([]Client, 0, len(s.clients))
+	for id := 1; id <= s.nextClient; id++ {
+		c, ok := s.clients[clientPath(id)]
+		if !ok {
+			continue
+		}
+		ret = append(ret, Client{
+			Path:                   c.path,
+			DesktopID:              c.get("DesktopId").(string),
+			RequestedAccuracyLevel: geoclue2.AccuracyLevel(c.get("RequestedAccuracyLevel").(uint32)),
+			DistanceThreshold:      c.get("DistanceThreshold").(uint32),
+			TimeThreshold:          c.get("TimeThreshold").(uint32),
+			Active:                 c.get("Active").(bool),
+		})
+	}
+	return ret
+}
+
+// SetLocation sets the current location, and sends it to the active clients
+// whose thresholds it passes. Before the service is served, the location is
+// only kept to be served.
+func (s *Service) SetLocation(loc geoclue2.Location) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.setLocationLocked(loc)
+}
+
+func (s *Service) setLocationLocked(loc geoclue2.Location) error {
+	if s.conn == nil {
+		s.location = &loc
+		return nil
+	}
+	s.nextLocation++
+	path := dbus.ObjectPath(fmt.Sprintf("%s%d", locationPrefix, s.nextLocation))
+	_, err := prop.Export(s.conn, path, map[string]map[string]*prop.Prop{
+		LocationInterface: {
+			"Latitude":    {Value: loc.Latitude},
+			"Longitude":   {Value: loc.Longitude},
+			"Accuracy":    {Value: loc.Accuracy},
+			"Altitude":    {Value: loc.Altitude},
+			"Speed":       {Value: loc.Speed},
+			"Heading":     {Value: loc.Heading},
+			"Description": {Value: loc.Description},
+			"Timestamp":   {Value: loc.Timestamp},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	s.locations = append(s.locations, path)
+	if len(s.locations) > keptLocations {
+		s.conn.Export(nil, s.locations[0], propertiesIface)
+		s.locations = s.locations[1:]
+	}
+	s.location = &loc
+	s.locationPath = path
+	for _, c := range s.clients {
+		if c.get("Active").(bool) {
+			s.sendLocked(c)
+		}
+	}
+	return nil
+}
+
+// Play sets the locations one by one, interval apart, in the background. The
+// returned channel is closed when all of them have been set, or the service
+// is closed.
+func (s *Service) Play(locations []geoclue2.Location, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		for i, loc := range locations {
+			if i > 0 {
+				select {
+				case <-time.After(interval):
+				case <-s.quit:
+					return
+				}
+			}
+			if err := s.SetLocation(loc); err != nil {
+				s.logger.Warn("setting location", "service", "fakegeoclue", "error", err)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// Deactivate stops all clients, as geoclue2 does e.g. when the user revokes
+// the authorization of an application.
+func (s *Service) Deactivate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.clients {
+		c.props.SetMust(ClientInterface, "Active", false)
+	}
+	s.updateInUseLocked()
+}
+
+// Restart drops all clients and location objects, as if the service was
+// restarted. The current location is kept, and sent to clients started
+// afterwards.
+func (s *Service) Restart() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for path := range s.clients {
+		s.unexportClientLocked(path)
+	}
+	for _, path := range s.locations {
+		s.conn.Export(nil, path, propertiesIface)
+	}
+	s.locations = nil
+	s.updateInUseLocked()
+	if s.location != nil {
+		// Republish the current location under a new path.
+		if err := s.setLocationLocked(*s.location); err != nil {
+			s.logger.Warn("setting location", "service", "fakegeoclue", "error", err)
+		}
+	}
+}
+
+func clientPath(id int) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("%s%d", clientPathPrefix, id))
+}
+
+func (c *client) get(name string) interface{} {
+	return c.props.GetMust(ClientInterface, name)
+}
+
+func (s *Service) errLocked(method string) *dbus.Error {
+	return s.errors[method]
+}
+
+func (s *Service) newClientLocked(owner string) (*client, error) {
+	s.nextClient++
+	path := clientPath(s.nextClient)
+	c := &client{
+		path:  path,
+		owner: owner,
+	}
+	props, err := prop.Export(s.conn, path, map[string]map[string]*prop.Prop{
+		ClientInterface: {
+			"Location":               {Value: dbus.ObjectPath("/"), Emit: prop.EmitTrue},
+			"DistanceThreshold":      {Value: uint32(0), Writable: true, Emit: prop.EmitTrue},
+			"TimeThreshold":          {Value: uint32(0), Writable: true, Emit: prop.EmitTrue},
+			"DesktopId":              {Value: "", Writable: true},
+			"RequestedAccuracyLevel": {Value: uint32(0), Writable: true},
+			"Active":                 {Value: false, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.props = props
+	if err := s.conn.Export(&clientObject{s: s, path: path}, path, ClientInterface); err != nil {
+		return nil, err
+	}
+	s.clients[path] = c
+	return c, nil
+}
+
+func (s *Service) unexportClientLocked(path dbus.ObjectPath) {
+	c, ok := s.clients[path]
+	if !ok {
+		return
+	}
+	s.conn.Export(nil, path, ClientInterface)
+	s.conn.Export(nil, path, propertiesIface)
+	delete(s.clients, path)
+	if s.owned[c.owner] == c {
+		delete(s.owned, c.owner)
+	}
+}
+
+func (s *Service) updateInUseLocked() {
+	if s.managerProps == nil {
+		return
+	}
+	inUse := false
+	for _, c := range s.clients {
+		if c.get("Active").(bool) {
+			inUse = true
+			break
+		}
+	}
+	s.managerProps.SetMust(ManagerInterface, "InUse", inUse)
+}
+
+// sendLocked sends the current location to c, unless it's within the
+// distance or time threshold of the last one sent.
+func (s *Service) sendLocked(c *client) {
+	loc := *s.location
+	if c.last != nil {
+		distance := c.get("DistanceThreshold").(uint32)
+		if distance > 0 && c.last.DistanceTo(loc) < float64(distance) {
+			return
+		}
+		interval := time.Duration(c.get("TimeThreshold").(uint32)) * time.Second
+		if interval > 0 && loc.Timestamp.Time().Sub(c.last.Timestamp.Time()) < interval {
+			return
+		}
+	}
+	c.last = &loc
+	old := c.get("Location").(dbus.ObjectPath)
+	c.props.SetMust(ClientInterface, "Location", s.locationPath)
+	// geoclue2 sends LocationUpdated to the owner of the client only.
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:      dbus.MakeVariant(c.path),
+			dbus.FieldInterface: dbus.MakeVariant(ClientInterface),
+			dbus.FieldMember:    dbus.MakeVariant("LocationUpdated"),
+			dbus.FieldSignature: dbus.MakeVariant(dbus.SignatureOf(old, s.locationPath)),
+		},
+		Body: []interface{}{old, s.locationPath},
+	}
+	if c.owner != "" {
+		msg.Headers[dbus.FieldDestination] = dbus.MakeVariant(c.owner)
+	}
+	if call := s.conn.Send(msg, nil); call != nil && call.Err != nil {
+		s.logger.Warn("sending LocationUpdated", "service", "fakegeoclue", "client", c.path, "error", call.Err)
+	}
+}
+
+// manager implements the methods of the manager object.
+type manager struct {
+	s *Service
+}
+
+// GetClient returns the client of the caller, creating it if needed.
+func (m *manager) GetClient(sender dbus.Sender) (dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errLocked(MethodGetClient); err != nil {
+		return "", err
+	}
+	if c, ok := s.owned[string(sender)]; ok {
+		return c.path, nil
+	}
+	c, err := s.newClientLocked(string(sender))
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	s.owned[string(sender)] = c
+	return c.path, nil
+}
+
+// CreateClient creates a new client for the caller.
+func (m *manager) CreateClient(sender dbus.Sender) (dbus.ObjectPath, *dbus.Error) {
+	s := m.s
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errLocked(MethodCreateClient); err != nil {
+		return "", err
+	}
+	c, err := s.newClientLocked(string(sender))
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return c.path, nil
+}
+
+// DeleteClient deletes a client.
+func (m *manager) DeleteClient(path dbus.ObjectPath) *dbus.Error {
+	s := m.s
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errLocked(MethodDeleteClient); err != nil {
+		return err
+	}
+	s.unexportClientLocked(path)
+	s.updateInUseLocked()
+	return nil
+}
+
+// AddAgent registers an agent. Agents aren't consulted, use Deny instead.
+func (m *manager) AddAgent(id string) *dbus.Error {
+	return nil
+}
+
+// clientObject implements the methods of a client object.
+type clientObject struct {
+	s    *Service
+	path dbus.ObjectPath
+}
+
+func unknownObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.UnknownObject", []interface{}{fmt.Sprintf("no client at %s", path)})
+}
+
+func accessDenied(format string, args ...interface{}) *dbus.Error {
+	return dbus.NewError(geoclue2.AccessDeniedError, []interface{}{fmt.Sprintf(format, args...)})
+}
+
+// Start starts the client. The current location, if any, is sent right
+// away.
+func (o *clientObject) Start() *dbus.Error {
+	s := o.s
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errLocked(MethodStart); err != nil {
+		return err
+	}
+	c, ok := s.clients[o.path]
+	if !ok {
+		return unknownObject(o.path)
+	}
+	id := c.get("DesktopId").(string)
+	if id == "" {
+		return accessDenied("'DesktopId' property must be set")
+	}
+	if s.denied[id] {
+		return accessDenied("'%s' disallowed, no agent for UID", id)
+	}
+	c.props.SetMust(ClientInterface, "Active", true)
+	s.updateInUseLocked()
+	if s.location != nil {
+		c.last = nil
+		s.sendLocked(c)
+	}
+	return nil
+}
+
+// Stop stops the client.
+func (o *clientObject) Stop() *dbus.Error {
+	s := o.s
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.errLocked(MethodStop); err != nil {
+		return err
+	}
+	c, ok := s.clients[o.path]
+	if !ok {
+		return unknownObject(o.path)
+	}
+	c.props.SetMust(ClientInterface, "Active", false)
+	s.updateInUseLocked()
+	return nil
+}
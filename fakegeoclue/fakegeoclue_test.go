@@ -0,0 +1,249 @@
+package fakegeoclue
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	geoclue2 "github.com/ldx/go-geoclue2"
+	"github.com/stretchr/testify/assert"
+)
+
+const testTimeout = 5 * time.Second
+
+func testLocation(lat, lon float64, seconds uint64) geoclue2.Location {
+	return geoclue2.Location{
+		Latitude:    lat,
+		Longitude:   lon,
+		Accuracy:    10,
+		Altitude:    -1.7976931348623157e+308,
+		Speed:       -1,
+		Heading:     -1,
+		Description: "fake",
+		Timestamp:   geoclue2.Timestamp{Seconds: seconds},
+	}
+}
+
+func startPeer(t *testing.T) (*Service, *dbus.Conn) {
+	s := New()
+	conn, err := s.ServePeer()
+	if err != nil {
+		t.Fatalf("serving peer: %v", err)
+	}
+	return s, conn
+}
+
+func receive(t *testing.T, ch chan geoclue2.Location) geoclue2.Location {
+	select {
+	case loc := <-ch:
+		return loc
+	case <-time.After(testTimeout):
+		t.Fatalf("timeout waiting for location")
+	}
+	return geoclue2.Location{}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPeer(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.SetAccuracyLevel(geoclue2.AccuracyCity)
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	assert.NoError(t, gc2.Err())
+	loc := testLocation(47.5, 19.05, 1000)
+	assert.NoError(t, s.SetLocation(loc))
+	assert.Equal(t, loc, receive(t, ch))
+	clients := s.Clients()
+	assert.Len(t, clients, 1)
+	assert.Equal(t, "test-app", clients[0].DesktopID)
+	assert.Equal(t, geoclue2.AccuracyCity, clients[0].RequestedAccuracyLevel)
+	assert.True(t, clients[0].Active)
+}
+
+func TestBeforeServe(t *testing.T) {
+	s := New()
+	defer s.Close()
+	loc := testLocation(47.5, 19.05, 1000)
+	s.SetAvailableAccuracyLevel(geoclue2.AccuracyCity)
+	assert.NoError(t, s.SetLocation(loc))
+	s.Deactivate()
+	s.Restart()
+	conn, err := s.ServePeer()
+	assert.NoError(t, err)
+	v, err := conn.Object(BusName, ManagerPath).GetProperty(ManagerInterface + ".AvailableAccuracyLevel")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(geoclue2.AccuracyCity), v.Value())
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	// The location is sent as soon as the client starts.
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	gc2.Start()
+	defer gc2.Stop()
+	assert.Equal(t, loc, receive(t, ch))
+}
+
+func TestDeny(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	s.Deny("test-app")
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.Start()
+	defer gc2.Stop()
+	waitFor(t, func() bool { return geoclue2.IsAccessDenied(gc2.Err()) })
+}
+
+func TestSetError(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	s.SetError(MethodGetClient, dbus.NewError("org.freedesktop.DBus.Error.Failed", []interface{}{"broken"}))
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.Start()
+	defer gc2.Stop()
+	waitFor(t, func() bool { return gc2.Err() != nil })
+	assert.Empty(t, s.Clients())
+	s.SetError(MethodGetClient, nil)
+	// The next event makes the control loop set up the client again.
+	gc2.Subscribe(make(chan geoclue2.Location, 1))
+	waitFor(t, func() bool { return gc2.Err() == nil })
+	assert.Len(t, s.Clients(), 1)
+}
+
+func TestDeactivateAndRestart(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	first := testLocation(47.5, 19.05, 1000)
+	assert.NoError(t, s.SetLocation(first))
+	assert.Equal(t, first, receive(t, ch))
+
+	// Deactivated clients are started again, and get the current location.
+	s.Deactivate()
+	assert.False(t, s.Clients()[0].Active)
+	gc2.Subscribe(make(chan geoclue2.Location, 1))
+	assert.Equal(t, first, receive(t, ch))
+	assert.True(t, s.Clients()[0].Active)
+
+	// After a restart, a new client is created.
+	s.Restart()
+	assert.Empty(t, s.Clients())
+	gc2.Subscribe(make(chan geoclue2.Location, 1))
+	assert.Equal(t, first, receive(t, ch))
+	clients := s.Clients()
+	assert.Len(t, clients, 1)
+	assert.Equal(t, clientPath(2), clients[0].Path)
+}
+
+func TestThresholds(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	var path dbus.ObjectPath
+	err := conn.Object(BusName, ManagerPath).Call(ManagerInterface+".GetClient", 0).Store(&path)
+	assert.NoError(t, err)
+	client := conn.Object(BusName, path)
+	set := func(name string, value interface{}) {
+		err := client.Call(propertiesIface+".Set", 0, ClientInterface, name, dbus.MakeVariant(value)).Err
+		assert.NoError(t, err)
+	}
+	set("DesktopId", "test-app")
+	set("DistanceThreshold", uint32(100))
+	set("TimeThreshold", uint32(60))
+	sigs := make(chan *dbus.Signal, 16)
+	conn.Signal(sigs)
+	assert.NoError(t, client.Call(ClientInterface+".Start", 0).Err)
+	updates := 0
+	for _, loc := range []geoclue2.Location{
+		testLocation(47.5, 19.05, 1000),
+		// Too close.
+		testLocation(47.5001, 19.05, 1100),
+		// Too soon.
+		testLocation(47.6, 19.05, 1010),
+		testLocation(47.6, 19.05, 1100),
+	} {
+		assert.NoError(t, s.SetLocation(loc))
+	}
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case sig := <-sigs:
+			if sig.Name == ClientInterface+".LocationUpdated" {
+				updates++
+			}
+		case <-timeout:
+			done = true
+		}
+	}
+	assert.Equal(t, 2, updates)
+}
+
+func TestPlay(t *testing.T) {
+	s, conn := startPeer(t)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	locs := []geoclue2.Location{
+		testLocation(47.5, 19.05, 1000),
+		testLocation(47.6, 19.05, 1001),
+		testLocation(47.7, 19.05, 1002),
+	}
+	done := s.Play(locs, 10*time.Millisecond)
+	for _, loc := range locs {
+		assert.Equal(t, loc, receive(t, ch))
+	}
+	<-done
+}
+
+func TestBus(t *testing.T) {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	bus, err := StartBus()
+	if err != nil {
+		t.Fatalf("starting bus: %v", err)
+	}
+	defer bus.Close()
+	s := New()
+	assert.NoError(t, s.ServeBus(bus.Address))
+	defer s.Close()
+	assert.Error(t, New().ServeBus(bus.Address))
+	conn, err := bus.Connect()
+	assert.NoError(t, err)
+	defer conn.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "test-app")
+	gc2.Start()
+	defer gc2.Stop()
+	ch := make(chan geoclue2.Location, 4)
+	gc2.Subscribe(ch)
+	loc := testLocation(47.5, 19.05, 1000)
+	assert.NoError(t, s.SetLocation(loc))
+	assert.Equal(t, loc, receive(t, ch))
+	// Every connection gets its own client.
+	other, err := bus.Connect()
+	assert.NoError(t, err)
+	defer other.Close()
+	var path dbus.ObjectPath
+	err = other.Object(BusName, ManagerPath).Call(ManagerInterface+".GetClient", 0).Store(&path)
+	assert.NoError(t, err)
+	assert.Equal(t, clientPath(2), path)
+	assert.Len(t, s.Clients(), 2)
+}
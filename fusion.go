@@ -0,0 +1,210 @@
+package geoclue2
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFusionMaxAge is how long a fix is considered for fusion.
+	DefaultFusionMaxAge = 30 * time.Second
+	// DefaultFusionDegradation is the speed, in meters per second, at which
+	// the accuracy of a fix is assumed to degrade as it ages.
+	DefaultFusionDegradation = 2.0
+)
+
+// FusionSource is a named location source for FusionProvider.
+type FusionSource struct {
+	// Name identifies the source in the Source field of fused locations.
+	Name     string
+	Provider Provider
+}
+
+// FusionProvider is a Provider that combines several location sources, e.g.
+// geoclue2 and a GPS receiver. Whenever a source reports a location, the best
+// current fix is selected by accuracy and freshness: the accuracy of a fix is
+// degraded by Degradation meters for every second that passed since its
+// timestamp, and fixes older than MaxAge are ignored. The Source field of the
+// published locations is set to the name of the source that produced them.
+//
+// If Blend is set, the current fixes of all sources are averaged instead,
+// each weighted by the inverse square of its degraded accuracy. The Source
+// field then lists the names of the contributing sources, separated by "+".
+//
+// The fields have to be set before calling Start.
+type FusionProvider struct {
+	*broadcaster
+	// MaxAge is how long a fix is considered after its timestamp.
+	MaxAge time.Duration
+	// Degradation is the speed, in meters per second, at which the accuracy
+	// of a fix is assumed to degrade as it ages.
+	Degradation float64
+	// Blend enables weighted averaging of all current fixes.
+	Blend bool
+	// now returns the current time; overridden in tests.
+	now     func() time.Time
+	sources []FusionSource
+	wg      sync.WaitGroup
+	lock    sync.Mutex
+	fixes   map[string]fusionFix
+}
+
+type fusionFix struct {
+	loc      Location
+	received time.Time
+}
+
+// NewFusionProvider creates a provider that fuses the locations reported by
+// sources. The sources are started and stopped together with the provider.
+func NewFusionProvider(sources ...FusionSource) *FusionProvider {
+	return &FusionProvider{
+		broadcaster: newBroadcaster("fusion"),
+		MaxAge:      DefaultFusionMaxAge,
+		Degradation: DefaultFusionDegradation,
+		now:         time.Now,
+		sources:     sources,
+		fixes:       make(map[string]fusionFix),
+	}
+}
+
+// Start starts all sources and the fusion of their location updates.
+func (f *FusionProvider) Start() {
+	f.logger.Info("starting up", "provider", "fusion", "sources", len(f.sources))
+	f.broadcaster.start()
+	for _, src := range f.sources {
+		ch := make(chan Location, 1)
+		// Subscribe first, sources such as StaticProvider publish right
+		// when they start.
+		src.Provider.Subscribe(ch)
+		src.Provider.Start()
+		f.wg.Add(1)
+		go f.receive(src.Name, ch)
+	}
+}
+
+// Stop stops all sources and waits until the provider has shut down.
+func (f *FusionProvider) Stop() {
+	f.logger.Debug("stop requested", "provider", "fusion")
+	// Stopping a source closes its subscriber channel, which ends the
+	// corresponding receive loop.
+	for _, src := range f.sources {
+		src.Provider.Stop()
+	}
+	f.wg.Wait()
+	f.broadcaster.stop()
+}
+
+func (f *FusionProvider) receive(name string, ch chan Location) {
+	defer f.wg.Done()
+	for loc := range ch {
+		f.logger.Debug("location update", "provider", "fusion", "source", name, "accuracy", loc.Accuracy)
+		f.lock.Lock()
+		f.fixes[name] = fusionFix{loc: loc, received: f.now()}
+		fused, ok := f.fuse(name)
+		f.lock.Unlock()
+		if ok {
+			f.publish(fused)
+		}
+	}
+}
+
+// age returns how old a fix is, based on its timestamp or, if the source
+// didn't provide one, the time it was received.
+func (f *FusionProvider) age(fix fusionFix, now time.Time) time.Duration {
+	t := fix.received
+	if fix.loc.Timestamp.Seconds != 0 {
+		t = fix.loc.Timestamp.Time()
+	}
+	age := now.Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	return age
+}
+
+// fuse computes the location to publish after the source updated has
+// reported a new fix. It returns false if there's nothing new to publish.
+func (f *FusionProvider) fuse(updated string) (Location, bool) {
+	now := f.now()
+	type candidate struct {
+		name     string
+		loc      Location
+		accuracy float64
+	}
+	var candidates []candidate
+	for name, fix := range f.fixes {
+		age := f.age(fix, now)
+		if f.MaxAge > 0 && age > f.MaxAge {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			name:     name,
+			loc:      fix.loc,
+			accuracy: fix.loc.Accuracy + age.Seconds()*f.Degradation,
+		})
+	}
+	if len(candidates) == 0 {
+		return Location{}, false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].accuracy != candidates[j].accuracy {
+			return candidates[i].accuracy < candidates[j].accuracy
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	best := candidates[0]
+	if !f.Blend || len(candidates) == 1 {
+		if best.name != updated {
+			// The new fix lost against one that has already been published.
+			return Location{}, false
+		}
+		loc := best.loc
+		loc.Source = best.name
+		return loc, true
+	}
+	// Weighted average of the positions, using the inverse variances as
+	// weights. Averaging degrees is fine for fixes that are close to each
+	// other; longitudes are unwrapped around the best fix to handle the
+	// antimeridian.
+	var sumW, lat, lon, altW, alt float64
+	var names []string
+	newest := best.loc.Timestamp
+	for _, c := range candidates {
+		sigma := math.Max(c.accuracy, 1)
+		w := 1 / (sigma * sigma)
+		dLon := c.loc.Longitude - best.loc.Longitude
+		if dLon > 180 {
+			dLon -= 360
+		} else if dLon < -180 {
+			dLon += 360
+		}
+		sumW += w
+		lat += w * c.loc.Latitude
+		lon += w * (best.loc.Longitude + dLon)
+		if c.loc.HasAltitude() {
+			altW += w
+			alt += w * c.loc.Altitude
+		}
+		if c.loc.Timestamp.Time().After(newest.Time()) {
+			newest = c.loc.Timestamp
+		}
+		names = append(names, c.name)
+	}
+	loc := best.loc
+	loc.Latitude = lat / sumW
+	loc.Longitude = math.Remainder(lon/sumW, 360)
+	loc.Accuracy = math.Sqrt(1 / sumW)
+	if loc.Accuracy > best.accuracy {
+		loc.Accuracy = best.accuracy
+	}
+	loc.Altitude = UnknownAltitude
+	if altW > 0 {
+		loc.Altitude = alt / altW
+	}
+	loc.Timestamp = newest
+	loc.Source = strings.Join(names, "+")
+	return loc, true
+}
@@ -0,0 +1,194 @@
+package geoclue2
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testProvider is a Provider whose locations are published by the test.
+type testProvider struct {
+	*broadcaster
+}
+
+func newTestProvider() *testProvider {
+	return &testProvider{broadcaster: newBroadcaster("test")}
+}
+
+func (p *testProvider) Start() {
+	p.broadcaster.start()
+}
+
+func (p *testProvider) Stop() {
+	p.broadcaster.stop()
+}
+
+func fix(lat, lon, accuracy float64, t time.Time) Location {
+	return Location{
+		Latitude:  lat,
+		Longitude: lon,
+		Accuracy:  accuracy,
+		Altitude:  -math.MaxFloat64,
+		Speed:     -1,
+		Heading:   -1,
+		Timestamp: FromTime(t),
+	}
+}
+
+func receive(t *testing.T, ch chan Location) Location {
+	select {
+	case loc := <-ch:
+		return loc
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for location")
+	}
+	return Location{}
+}
+
+func assertNoLocation(t *testing.T, ch chan Location) {
+	select {
+	case loc := <-ch:
+		t.Fatalf("unexpected location %+v", loc)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// testClock is a manually advanced clock.
+type testClock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestFusionProviderPick(t *testing.T) {
+	clock := &testClock{t: time.Unix(1600000000, 0)}
+	now := clock.now()
+	wifi := newTestProvider()
+	gps := newTestProvider()
+	f := NewFusionProvider(
+		FusionSource{Name: "wifi", Provider: wifi},
+		FusionSource{Name: "gps", Provider: gps},
+	)
+	f.now = clock.now
+	f.Start()
+	defer f.Stop()
+	ch := make(chan Location, 10)
+	f.Subscribe(ch)
+
+	wifi.publish(fix(1, 1, 100, now))
+	loc := receive(t, ch)
+	assert.Equal(t, "wifi", loc.Source)
+	assert.Equal(t, 1.0, loc.Latitude)
+
+	gps.publish(fix(2, 2, 5, now))
+	loc = receive(t, ch)
+	assert.Equal(t, "gps", loc.Source)
+	assert.Equal(t, 2.0, loc.Latitude)
+
+	// A less accurate fix doesn't replace the GPS one.
+	wifi.publish(fix(3, 3, 100, now))
+	assertNoLocation(t, ch)
+
+	// Once the GPS fix has aged, the WiFi fix wins.
+	clock.advance(60 * time.Second)
+	now = clock.now()
+	wifi.publish(fix(4, 4, 100, now))
+	loc = receive(t, ch)
+	assert.Equal(t, "wifi", loc.Source)
+	assert.Equal(t, 4.0, loc.Latitude)
+	assert.Equal(t, "wifi", f.GetLatestLocation().Source)
+}
+
+func TestFusionProviderBlend(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	a := newTestProvider()
+	b := newTestProvider()
+	f := NewFusionProvider(
+		FusionSource{Name: "a", Provider: a},
+		FusionSource{Name: "b", Provider: b},
+	)
+	f.Blend = true
+	f.now = func() time.Time { return now }
+	f.Start()
+	defer f.Stop()
+	ch := make(chan Location, 10)
+	f.Subscribe(ch)
+
+	a.publish(fix(10, 179.9, 10, now))
+	receive(t, ch)
+	loc := fix(20, -179.9, 10, now)
+	loc.Altitude = 100
+	b.publish(loc)
+	loc = receive(t, ch)
+	assert.Equal(t, "a+b", loc.Source)
+	assert.InDelta(t, 15, loc.Latitude, 1e-9)
+	assert.InDelta(t, 180, math.Abs(loc.Longitude), 1e-9)
+	assert.InDelta(t, 10/math.Sqrt2, loc.Accuracy, 1e-9)
+	assert.Equal(t, 100.0, loc.Altitude)
+
+	// Weights favor the more accurate fix.
+	a.publish(fix(0, 0, 1, now))
+	loc = receive(t, ch)
+	assert.Equal(t, "a+b", loc.Source)
+	assert.InDelta(t, 20.0/101, loc.Latitude, 1e-9)
+}
+
+// startFixProvider is a testProvider publishing a fix as it starts.
+type startFixProvider struct {
+	*testProvider
+	loc Location
+}
+
+func (p *startFixProvider) Start() {
+	p.testProvider.Start()
+	p.publish(p.loc)
+}
+
+func TestFusionProviderFixOnStart(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	src := &startFixProvider{testProvider: newTestProvider(), loc: fix(1, 2, 10, now)}
+	f := NewFusionProvider(FusionSource{Name: "src", Provider: src})
+	f.now = func() time.Time { return now }
+	ch := make(chan Location, 10)
+	f.Subscribe(ch)
+	f.Start()
+	defer f.Stop()
+	loc := receive(t, ch)
+	assert.Equal(t, "src", loc.Source)
+	assert.Equal(t, 1.0, loc.Latitude)
+}
+
+func TestFusionProviderStaticSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoclue2")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "geolocation")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("1\n2\n3\n4\n"), 0644))
+	f := NewFusionProvider(FusionSource{Name: "static", Provider: NewStaticProvider(path)})
+	ch := make(chan Location, 10)
+	f.Subscribe(ch)
+	f.Start()
+	defer f.Stop()
+	// The static source publishes its only fix as soon as it starts.
+	loc := receive(t, ch)
+	assert.Equal(t, "static", loc.Source)
+	assert.Equal(t, 1.0, loc.Latitude)
+	assert.Equal(t, 2.0, loc.Longitude)
+}
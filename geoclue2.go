@@ -3,15 +3,25 @@ package geoclue2
 import (
 	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"sync"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
-	"k8s.io/klog"
+)
+
+// DefaultDesktopID is the desktop ID used when none is given to NewGeoClue2.
+const DefaultDesktopID = "go-geoclue2"
+
+// Default intervals between attempts to set up the client, see
+// SetRetryInterval.
+const (
+	DefaultMinRetryInterval = time.Second
+	DefaultMaxRetryInterval = time.Minute
 )
 
 const (
-	defaultDesktopID  = "go-geoclue2"
 	getProperties     = "org.freedesktop.DBus.Properties.Get"
 	setProperties     = "org.freedesktop.DBus.Properties.Set"
 	geoClue2Interface = "org.freedesktop.GeoClue2"
@@ -43,20 +53,22 @@ type Location struct {
 	// The accuracy of the location fix, in meters.
 	Accuracy float64 `dbus:"Accuracy"`
 	// The altitude of the location fix, in meters. When unknown, its set to
-	// minimum double value, -1.7976931348623157e+308.
+	// minimum double value, -1.7976931348623157e+308 (UnknownAltitude). Use
+	// HasAltitude or AltitudeMeters to check.
 	Altitude float64 `dbus:"Altitude"`
-	// The speed in meters per second. When unknown, it's set to -1.0.
+	// The speed in meters per second. When unknown, it's set to -1.0
+	// (UnknownSpeed). Use HasSpeed or SpeedMetersPerSecond to check.
 	Speed float64 `dbus:"Speed"`
 	// The heading direction in degrees with respect to North direction, in
 	// clockwise order. That means North becomes 0 degree, East: 90 degrees,
 	// South: 180 degrees, West: 270 degrees and so on. When unknown, it's set
-	// to -1.0.
+	// to -1.0 (UnknownHeading). Use HasHeading or HeadingDegrees to check.
 	Heading float64 `dbus:"Heading"`
 	// A human-readable description of the location, if available.
 	// WARNING: Applications should not rely on this property since not all
 	// sources provide a description. If you really need a description (or more
 	// details) about current location, use a reverse-geocoding API, e.g
-	// geocode-glib.
+	// geocode-glib, or the offline reverse geocoder in the geocode package.
 	Description string `dbus:"Description"`
 	// The timestamp when the location was determined, in seconds and
 	// microseconds since the Epoch. This is the time of measurement if the
@@ -66,6 +78,16 @@ type Location struct {
 	// respect that. Also note that a timestamp can be very old, e.g. because
 	// of a cached location.
 	Timestamp Timestamp `dbus:"Timestamp"`
+	// The name of the source that produced the location, if known. This is
+	// not part of the geoclue2 location object; it's set by providers that
+	// combine several sources, e.g. FusionProvider.
+	Source string
+	// SpeedDerived is set if Speed wasn't provided by the source, but
+	// computed from consecutive locations, see GeoClue2.SetDeriveMotion.
+	SpeedDerived bool
+	// HeadingDerived is set if Heading wasn't provided by the source, but
+	// computed from consecutive locations.
+	HeadingDerived bool
 }
 
 // GeoClue2 is used for receiving location information from the geoclue2
@@ -75,41 +97,155 @@ type GeoClue2 struct {
 	desktopID      string
 	wg             sync.WaitGroup
 	quit           chan interface{}
+	done           chan interface{} // Closed when the main loop has shut down.
 	dbus           chan *dbus.Signal
 	subscribe      chan chan Location
 	unsubscribe    chan chan Location
-	client         dbus.BusObject
-	latestLocation *Location
+	subscribeRaw   chan chan Location
+	unsubscribeRaw chan chan Location
+	// Subscriptions with a SubscriptionFilter.
+	subscribeFiltered chan subscriber
+	filter            LocationFilter
+	deriveMotion      bool
+	lastLocation      *Location
+	accuracyLevel     AccuracyLevel
+	logger            Logger
+	minRetryInterval  time.Duration
+	maxRetryInterval  time.Duration
+	client            dbus.BusObject
+	latestLocation    *Location
+	// Error of the last attempt to set up the client.
+	errLock   sync.Mutex
+	clientErr error
+	// Subscribers registered before Start, handed over to the main loop.
+	earlyLock sync.Mutex
+	started   bool
+	early     map[chan Location]*subscriberState
+	earlyRaw  map[chan Location]*subscriberState
 }
 
-// NewGeoClue2 is used to create a new GeoClue2 struct.
+// NewGeoClue2 is used to create a new GeoClue2 struct. The connection stays
+// with the caller, see Connect for one that belongs to the GeoClue2.
 func NewGeoClue2(conn *dbus.Conn, desktopID string) *GeoClue2 {
+	return newGeoClue2(&RealDbusConn{conn: conn}, desktopID)
+}
+
+func newGeoClue2(conn DbusConn, desktopID string) *GeoClue2 {
 	if desktopID == "" {
-		desktopID = defaultDesktopID
+		desktopID = DefaultDesktopID
 	}
 	return &GeoClue2{
-		conn:        &RealDbusConn{conn: conn},
-		desktopID:   desktopID,
-		wg:          sync.WaitGroup{},
-		quit:        make(chan interface{}),
-		dbus:        make(chan *dbus.Signal),
-		subscribe:   make(chan chan Location),
-		unsubscribe: make(chan chan Location),
+		conn:              conn,
+		desktopID:         desktopID,
+		wg:                sync.WaitGroup{},
+		quit:              make(chan interface{}),
+		done:              make(chan interface{}),
+		dbus:              make(chan *dbus.Signal),
+		subscribe:         make(chan chan Location),
+		unsubscribe:       make(chan chan Location),
+		subscribeRaw:      make(chan chan Location),
+		unsubscribeRaw:    make(chan chan Location),
+		subscribeFiltered: make(chan subscriber),
+		logger:            DefaultLogger(),
+		minRetryInterval:  DefaultMinRetryInterval,
+		maxRetryInterval:  DefaultMaxRetryInterval,
+		early:             make(map[chan Location]*subscriberState),
+		earlyRaw:          make(map[chan Location]*subscriberState),
+	}
+}
+
+// SetFilter sets a filter stage, e.g. a KalmanFilter, that's applied to
+// location updates before they're broadcast. Subscribers and
+// GetLatestLocation get the filtered locations; the unfiltered ones are
+// available via SubscribeRaw. It has to be called before Start.
+func (g *GeoClue2) SetFilter(f LocationFilter) {
+	g.filter = f
+}
+
+// SetDeriveMotion enables computing the speed and heading of locations from
+// the previous location when the source doesn't provide them, see
+// DeriveMotion. It has to be called before Start.
+func (g *GeoClue2) SetDeriveMotion(enabled bool) {
+	g.deriveMotion = enabled
+}
+
+// SetAccuracyLevel sets the level of accuracy requested from geoclue2. By
+// default the level is left to geoclue2. It has to be called before Start.
+func (g *GeoClue2) SetAccuracyLevel(level AccuracyLevel) {
+	g.accuracyLevel = level
+}
+
+// SetLogger sets the Logger of the GeoClue2, and of its connection if it was
+// created with Connect. By default, it's the one set with SetDefaultLogger. A
+// nil Logger discards all messages. It has to be called before Start.
+func (g *GeoClue2) SetLogger(l Logger) {
+	g.logger = orNop(l)
+	if c, ok := g.conn.(*busConn); ok {
+		c.setLogger(g.logger)
 	}
 }
 
+func (g *GeoClue2) log() Logger {
+	return g.logger
+}
+
+// SetRetryInterval sets how long to wait before trying again to set up the
+// client, e.g. after the connection to the bus was lost. The wait starts at
+// min, and doubles after each failed attempt, up to max. It has to be called
+// before Start.
+func (g *GeoClue2) SetRetryInterval(min, max time.Duration) {
+	if min <= 0 {
+		min = DefaultMinRetryInterval
+	}
+	if max < min {
+		max = min
+	}
+	g.minRetryInterval = min
+	g.maxRetryInterval = max
+}
+
+// Err returns the error of the last attempt to set up the geoclue2 client,
+// or nil if it succeeded. Use IsAccessDenied to check if geoclue2 refused
+// access to the location.
+func (g *GeoClue2) Err() error {
+	g.errLock.Lock()
+	defer g.errLock.Unlock()
+	return g.clientErr
+}
+
+func (g *GeoClue2) setErr(err error) {
+	g.errLock.Lock()
+	defer g.errLock.Unlock()
+	g.clientErr = err
+}
+
 // Start starts the main loop that receives and distributes location updates.
 func (g *GeoClue2) Start() {
-	klog.V(2).Infof("starting up")
+	g.log().Info("starting up", "desktopID", g.desktopID)
 	g.conn.Signal(g.dbus)
-	go g.controlLoop()
+	g.earlyLock.Lock()
+	g.started = true
+	early, earlyRaw := g.early, g.earlyRaw
+	g.early, g.earlyRaw = nil, nil
+	g.earlyLock.Unlock()
+	g.wg.Add(1)
+	go g.controlLoop(early, earlyRaw)
 }
 
-// Stop stops the main loop and waits until it has shut down.
+// Stop stops the main loop and waits until it has shut down. If the
+// GeoClue2 was created with Connect, it also closes its connection.
 func (g *GeoClue2) Stop() {
-	klog.V(5).Infof("stop requested")
-	g.quit <- struct{}{}
+	g.log().Debug("stop requested", "desktopID", g.desktopID)
+	select {
+	case g.quit <- struct{}{}:
+	case <-g.done:
+	}
 	g.wg.Wait()
+	if c, ok := g.conn.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			g.log().Warn("closing connection", "error", err)
+		}
+	}
 }
 
 func (g *GeoClue2) ensureClient() error {
@@ -134,7 +270,7 @@ func (g *GeoClue2) getClient() error {
 	call := manager.Call(getClient, 0)
 	err := call.Store(&path)
 	if err != nil {
-		klog.Warningf("getting client: %v", err)
+		g.log().Warn("getting client", "desktopID", g.desktopID, "error", err)
 		return err
 	}
 	clientPath := dbus.ObjectPath(path)
@@ -142,14 +278,23 @@ func (g *GeoClue2) getClient() error {
 	id := dbus.MakeVariant(g.desktopID)
 	err = client.Call(setProperties, 0, clientInterface, "DesktopId", id).Err
 	if err != nil {
-		klog.Warningf("setting DesktopId: %v", err)
+		g.log().Warn("setting DesktopId", "client", clientPath, "desktopID", g.desktopID, "error", err)
 		return err
 	}
+	if g.accuracyLevel != AccuracyNone {
+		level := dbus.MakeVariant(uint32(g.accuracyLevel))
+		err = client.Call(setProperties, 0, clientInterface, "RequestedAccuracyLevel", level).Err
+		if err != nil {
+			g.log().Warn("setting RequestedAccuracyLevel", "client", clientPath, "accuracyLevel", g.accuracyLevel, "error", err)
+			return err
+		}
+	}
 	err = client.Call(clientStart, 0).Err
 	if err != nil {
-		klog.Warningf("starting client: %v", err)
+		g.log().Warn("starting client", "client", clientPath, "error", err)
 		return err
 	}
+	g.log().Info("started client", "client", clientPath, "desktopID", g.desktopID, "accuracyLevel", g.accuracyLevel)
 	g.client = client
 	return nil
 }
@@ -189,7 +334,7 @@ func getObjInto(intf string, obj dbus.BusObject, into interface{}) error {
 func (g *GeoClue2) processLocationUpdate() *Location {
 	val, err := g.client.GetProperty(clientLocation)
 	if err != nil {
-		klog.Warningf("getting location path from update: %v", err)
+		g.log().Warn("getting location path from update", "client", g.client.Path(), "error", err)
 		return nil
 	}
 	path := val.Value().(dbus.ObjectPath)
@@ -197,13 +342,16 @@ func (g *GeoClue2) processLocationUpdate() *Location {
 	location := Location{}
 	err = getObjInto(locationInterface, obj, &location)
 	if err != nil {
-		klog.Warningf("getting location object from update: %v", err)
+		g.log().Warn("getting location object from update", "client", g.client.Path(), "location", path, "error", err)
 		return nil
 	}
+	g.log().Debug("got location update", "client", g.client.Path(), "location", path,
+		"latitude", location.Latitude, "longitude", location.Longitude, "accuracy", location.Accuracy)
 	return &location
 }
 
-// GetLatestLocation returns the last location received from geoclue2.
+// GetLatestLocation returns the last location received from geoclue2, after
+// filtering if a filter is set.
 func (g *GeoClue2) GetLatestLocation() *Location {
 	return g.latestLocation
 }
@@ -211,56 +359,243 @@ func (g *GeoClue2) GetLatestLocation() *Location {
 // WaitForLocation waits for the next location update.
 func (g *GeoClue2) WaitForLocation(ctx context.Context) (*Location, error) {
 	ch := make(chan Location)
-	g.subscribe <- ch
+	g.Subscribe(ch)
 	select {
 	case loc, ok := <-ch:
 		if !ok {
 			return nil, fmt.Errorf("receiver loop shutting down")
 		}
-		g.unsubscribe <- ch
+		g.Unsubscribe(ch)
 		return &loc, nil
 	case <-ctx.Done():
+		g.Unsubscribe(ch)
 		return nil, ctx.Err()
 	}
 }
 
-func (g *GeoClue2) broadcastUpdate(subscribers map[chan<- Location]interface{}, loc Location) {
-	klog.V(5).Infof("broadcasting location update")
-	for ch := range subscribers {
-		select {
-		case ch <- loc:
-		default:
-		}
+// addEarly registers ch as a subscriber, or a raw one, if the main loop
+// isn't started yet. It returns false if it is.
+func (g *GeoClue2) addEarly(raw bool, ch chan Location, state *subscriberState) bool {
+	g.earlyLock.Lock()
+	defer g.earlyLock.Unlock()
+	if g.started {
+		return false
+	}
+	if raw {
+		g.earlyRaw[ch] = state
+	} else {
+		g.early[ch] = state
 	}
+	return true
 }
 
-func (g *GeoClue2) controlLoop() {
-	g.wg.Add(1)
+// removeEarly removes a subscriber registered before Start. It returns false
+// if the main loop is started.
+func (g *GeoClue2) removeEarly(raw bool, ch chan Location) bool {
+	g.earlyLock.Lock()
+	defer g.earlyLock.Unlock()
+	if g.started {
+		return false
+	}
+	if raw {
+		delete(g.earlyRaw, ch)
+	} else {
+		delete(g.early, ch)
+	}
+	return true
+}
+
+// Subscribe registers ch to receive location updates. Updates are delivered
+// without blocking, so ch should be buffered if the receiver can't keep up.
+// Subscribing before Start is allowed, so no update is missed. The channel
+// is closed when the main loop shuts down, or right away if it has already
+// shut down.
+func (g *GeoClue2) Subscribe(ch chan Location) {
+	if g.addEarly(false, ch, nil) {
+		return
+	}
+	select {
+	case g.subscribe <- ch:
+	case <-g.done:
+		close(ch)
+	}
+}
+
+// SubscribeFiltered registers ch to receive the location updates that pass
+// filter. This allows subscribers to get updates at different rates,
+// independently of the thresholds of geoclue2. Use Unsubscribe to remove the
+// subscription.
+func (g *GeoClue2) SubscribeFiltered(ch chan Location, filter SubscriptionFilter) {
+	state := newSubscriberState(filter)
+	if g.addEarly(false, ch, state) {
+		return
+	}
+	select {
+	case g.subscribeFiltered <- subscriber{ch: ch, state: state}:
+	case <-g.done:
+		close(ch)
+	}
+}
+
+// Unsubscribe stops delivering location updates to ch.
+func (g *GeoClue2) Unsubscribe(ch chan Location) {
+	if g.removeEarly(false, ch) {
+		return
+	}
+	select {
+	case g.unsubscribe <- ch:
+	case <-g.done:
+	}
+}
+
+// SubscribeRaw registers ch to receive location updates as received from
+// geoclue2, before filtering. Without a filter, they're the same as the ones
+// delivered via Subscribe.
+func (g *GeoClue2) SubscribeRaw(ch chan Location) {
+	if g.addEarly(true, ch, nil) {
+		return
+	}
+	select {
+	case g.subscribeRaw <- ch:
+	case <-g.done:
+		close(ch)
+	}
+}
+
+// UnsubscribeRaw stops delivering unfiltered location updates to ch.
+func (g *GeoClue2) UnsubscribeRaw(ch chan Location) {
+	if g.removeEarly(true, ch) {
+		return
+	}
+	select {
+	case g.unsubscribeRaw <- ch:
+	case <-g.done:
+	}
+}
+
+func (g *GeoClue2) broadcastUpdate(subscribers map[chan<- Location]*subscriberState, loc Location) {
+	g.log().Debug("broadcasting location update", "subscribers", len(subscribers))
+	deliver(subscribers, loc)
+}
+
+// applyFilter runs loc through the filter stage. It returns nil if the
+// filter dropped it.
+func (g *GeoClue2) applyFilter(loc Location) *Location {
+	if g.filter == nil {
+		return &loc
+	}
+	filtered, ok := g.filter.Filter(loc)
+	if !ok {
+		g.log().Debug("location update dropped by filter", "accuracy", loc.Accuracy)
+		return nil
+	}
+	return &filtered
+}
+
+func (g *GeoClue2) controlLoop(early, earlyRaw map[chan Location]*subscriberState) {
 	defer g.wg.Done()
-	subscribers := make(map[chan<- Location]interface{})
+	defer close(g.done)
+	subscribers := make(map[chan<- Location]*subscriberState)
+	for ch, state := range early {
+		subscribers[ch] = state
+	}
+	rawSubscribers := make(map[chan<- Location]*subscriberState)
+	for ch, state := range earlyRaw {
+		rawSubscribers[ch] = state
+	}
+	// Set to nil if the connection closes the channel.
+	signals := g.dbus
+	retryInterval := g.minRetryInterval
+	// While an attempt to set up the client is pending, it's only made when
+	// retry fires, not on every event.
+	var retryTimer *time.Timer
+	var retry <-chan time.Time
 	for {
-		g.ensureClient()
+		if retry == nil {
+			err := g.ensureClient()
+			g.setErr(err)
+			if err != nil {
+				g.log().Info("setting up client again later", "desktopID", g.desktopID, "retryInterval", retryInterval)
+				retryTimer = time.NewTimer(retryInterval)
+				retry = retryTimer.C
+				retryInterval *= 2
+				if retryInterval > g.maxRetryInterval {
+					retryInterval = g.maxRetryInterval
+				}
+			} else {
+				retryInterval = g.minRetryInterval
+			}
+		}
 		select {
 		case subscribe := <-g.subscribe:
-			klog.V(5).Infof("new subscriber %v", subscribe)
-			subscribers[subscribe] = ""
+			g.log().Debug("new subscriber", "subscriber", subscribe)
+			subscribers[subscribe] = nil
+		case subscribe := <-g.subscribeFiltered:
+			g.log().Debug("new filtered subscriber", "subscriber", subscribe.ch)
+			subscribers[subscribe.ch] = subscribe.state
 		case unsubscribe := <-g.unsubscribe:
-			klog.V(5).Infof("subscriber %v gone", unsubscribe)
+			g.log().Debug("subscriber gone", "subscriber", unsubscribe)
 			delete(subscribers, unsubscribe)
-		case sig := <-g.dbus:
-			if sig.Name == locationUpdated {
-				klog.V(5).Infof("got location update")
+		case subscribe := <-g.subscribeRaw:
+			g.log().Debug("new raw subscriber", "subscriber", subscribe)
+			rawSubscribers[subscribe] = nil
+		case unsubscribe := <-g.unsubscribeRaw:
+			g.log().Debug("raw subscriber gone", "subscriber", unsubscribe)
+			delete(rawSubscribers, unsubscribe)
+		case <-retry:
+			retry = nil
+			g.log().Debug("retrying to set up client", "desktopID", g.desktopID)
+		case sig, ok := <-signals:
+			if !ok {
+				// The connection was closed. It's not ours, so it can't
+				// be replaced; calls will fail until Stop.
+				g.log().Warn("connection closed")
+				signals = nil
+				g.client = nil
+				continue
+			}
+			switch sig.Name {
+			case disconnected:
+				// Our connection was lost, a new one is opened when the
+				// client is set up again.
+				g.log().Info("connection lost")
+				g.client = nil
+			case locationUpdated:
+				if g.client == nil {
+					continue
+				}
 				loc := g.processLocationUpdate()
+				if loc != nil {
+					g.broadcastUpdate(rawSubscribers, *loc)
+					loc = g.applyFilter(*loc)
+				}
+				if loc != nil && g.deriveMotion {
+					prev := g.lastLocation
+					g.lastLocation = loc
+					if prev != nil {
+						derived := DeriveMotion(*prev, *loc)
+						loc = &derived
+					}
+				}
 				if loc != nil {
 					g.latestLocation = loc
 					g.broadcastUpdate(subscribers, *loc)
 				}
 			}
 		case <-g.quit:
-			klog.V(2).Infof("shutting down")
+			g.log().Info("shutting down", "desktopID", g.desktopID)
+			if retryTimer != nil {
+				retryTimer.Stop()
+			}
 			for sub := range subscribers {
 				close(sub)
 			}
+			for sub := range rawSubscribers {
+				// The same channel may be subscribed both ways.
+				if _, ok := subscribers[sub]; !ok {
+					close(sub)
+				}
+			}
 			return
 		}
 	}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -13,194 +12,112 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-const (
-	clientPathPrefix = "/org/freedesktop/GeoClue2/Client/"
-)
+// mockLocation returns the location used in the tests of the control loop.
+func mockLocation() Location {
+	return Location{
+		Latitude:  1.23,
+		Longitude: 1.23,
+		Accuracy:  1.23,
+		Altitude:  1.23,
+		Speed:     1.23,
+		Heading:   1.23,
+		Timestamp: FromTime(time.Now()),
+	}
+}
 
-func body(elements ...interface{}) []interface{} {
-	ret := make([]interface{}, len(elements))
-	for i, e := range elements {
-		ret[i] = e
+func activeProperty() MockCall {
+	return MockCall{
+		Path:   MockClientPath,
+		Method: getProperties,
+		Args:   []interface{}{clientInterface, "Active"},
 	}
-	return ret
 }
 
 // func (g *GeoClue2) getClient() error
 func TestGetClient(t *testing.T) {
-	manager := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{
-				Destination: "org.freedesktop.GeoClue2",
-				Path:        "/org/freedesktop/GeoClue2/Manager",
-				Method:      "GetClient",
-				Args:        nil,
-				Done:        nil,
-				Err:         nil,
-				Body:        body("/org/freedesktop/GeoClue2/Client/10"),
-			}
-		},
-	}
-	client := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{}
-		},
-	}
-	conn := &MockDbusConn{
-		DoObject: func(iface string, path dbus.ObjectPath) dbus.BusObject {
-			if path == managerPath {
-				return manager
-			} else if strings.HasPrefix(string(path), clientPathPrefix) {
-				return client
-			}
-			t.Error(fmt.Sprintf("invalid path %q", path))
-			return nil
-		},
-	}
-	gc2 := GeoClue2{
-		conn: conn,
-	}
+	bus := NewMockBus()
+	gc2 := newGeoClue2(bus, "test")
 	err := gc2.getClient()
 	assert.NoError(t, err)
 	assert.NotNil(t, gc2.client)
+	bus.AssertCalls(t,
+		MockCall{Path: managerPath, Method: getClient},
+		MockCall{
+			Path:   MockClientPath,
+			Method: setProperties,
+			Args:   []interface{}{clientInterface, "DesktopId", dbus.MakeVariant("test")},
+		},
+		MockCall{Path: MockClientPath, Method: clientStart},
+	)
 }
 
 func TestGetClientManagerCallErr(t *testing.T) {
-	manager := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{
-				Err: fmt.Errorf("testing manager.Call() error"),
-			}
-		},
-	}
-	client := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{}
-		},
-	}
-	conn := &MockDbusConn{
-		DoObject: func(iface string, path dbus.ObjectPath) dbus.BusObject {
-			if path == managerPath {
-				return manager
-			} else if strings.HasPrefix(string(path), clientPathPrefix) {
-				return client
-			}
-			t.Error(fmt.Sprintf("invalid path %q", path))
-			return nil
-		},
-	}
-	gc2 := GeoClue2{
-		conn: conn,
-	}
+	bus := NewMockBus()
+	bus.Fail(managerPath, getClient, fmt.Errorf("testing manager.Call() error"))
+	gc2 := newGeoClue2(bus, "")
 	err := gc2.getClient()
 	assert.Error(t, err)
 	assert.Nil(t, gc2.client)
+	bus.AssertNotCalled(t, MockCall{Method: clientStart})
 }
 
 func TestGetClientClientCallErr(t *testing.T) {
-	manager := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{
-				Destination: "org.freedesktop.GeoClue2",
-				Path:        "/org/freedesktop/GeoClue2/Manager",
-				Method:      "GetClient",
-				Args:        nil,
-				Done:        nil,
-				Err:         nil,
-				Body:        body("/org/freedesktop/GeoClue2/Client/10"),
-			}
-		},
-	}
-	client := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			return &dbus.Call{
-				Err: fmt.Errorf("testing client.Call() error"),
-			}
-		},
-	}
-	conn := &MockDbusConn{
-		DoObject: func(iface string, path dbus.ObjectPath) dbus.BusObject {
-			if path == managerPath {
-				return manager
-			} else if strings.HasPrefix(string(path), clientPathPrefix) {
-				return client
-			}
-			t.Error(fmt.Sprintf("invalid path %q", path))
-			return nil
-		},
-	}
-	gc2 := GeoClue2{
-		conn: conn,
-	}
+	bus := NewMockBus()
+	bus.Fail(MockClientPath, setProperties, fmt.Errorf("testing client.Call() error"))
+	gc2 := newGeoClue2(bus, "")
 	err := gc2.getClient()
 	assert.Error(t, err)
 	assert.Nil(t, gc2.client)
+	bus.AssertNotCalled(t, MockCall{Method: clientStart})
+}
+
+func TestGetClientAccuracyLevel(t *testing.T) {
+	bus := NewMockBus()
+	gc2 := newGeoClue2(bus, "test")
+	assert.NoError(t, gc2.getClient())
+	_, ok := bus.Property(MockClientPath, clientInterface+".RequestedAccuracyLevel")
+	assert.False(t, ok)
+	gc2.SetAccuracyLevel(AccuracyCity)
+	assert.NoError(t, gc2.getClient())
+	level, _ := bus.Property(MockClientPath, clientInterface+".RequestedAccuracyLevel")
+	assert.Equal(t, uint32(4), level)
+	id, _ := bus.Property(MockClientPath, clientInterface+".DesktopId")
+	assert.Equal(t, "test", id)
+}
+
+func TestAccessDenied(t *testing.T) {
+	bus := NewMockBus()
+	bus.Fail(MockClientPath, clientStart, dbus.NewError(AccessDeniedError, nil))
+	gc2 := newGeoClue2(bus, "")
+	gc2.Start()
+	// Subscribing makes the main loop retry setting up the client.
+	ch := make(chan Location)
+	gc2.Subscribe(ch)
+	assert.True(t, IsAccessDenied(gc2.Err()))
+	gc2.Stop()
 }
 
 //func (g *GeoClue2) ensureClient() error
 func TestEnsureClient(t *testing.T) {
-	called := false
-	accessed := false
-	manager := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			called = true
-			return &dbus.Call{
-				Destination: "org.freedesktop.GeoClue2",
-				Path:        "/org/freedesktop/GeoClue2/Manager",
-				Method:      "GetClient",
-				Args:        nil,
-				Done:        nil,
-				Err:         nil,
-				Body:        body("/org/freedesktop/GeoClue2/Client/10"),
-			}
-		},
-	}
-	client := &MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			called = true
-			return &dbus.Call{}
-		},
-		DoGetProperty: func(p string) (dbus.Variant, error) {
-			accessed = true
-			return dbus.MakeVariant(true), nil
-		},
-	}
-	conn := &MockDbusConn{
-		DoObject: func(iface string, path dbus.ObjectPath) dbus.BusObject {
-			if path == managerPath {
-				return manager
-			} else if strings.HasPrefix(string(path), clientPathPrefix) {
-				return client
-			}
-			t.Error(fmt.Sprintf("invalid path %q", path))
-			return nil
-		},
-	}
-	gc2 := GeoClue2{
-		conn: conn,
-	}
+	bus := NewMockBus()
+	gc2 := newGeoClue2(bus, "")
 	err := gc2.ensureClient()
 	assert.NoError(t, err)
 	assert.NotNil(t, gc2.client)
-	assert.True(t, called)
-	assert.False(t, accessed)
-	called = false
-	accessed = false
+	bus.AssertCalls(t, MockCall{Method: getClient})
+	bus.AssertNotCalled(t, activeProperty())
+	bus.Reset()
 	err = gc2.ensureClient()
 	assert.NoError(t, err)
 	assert.NotNil(t, gc2.client)
-	assert.False(t, called)
-	assert.True(t, accessed)
-	called = false
-	accessed = false
-	client.DoGetProperty = func(p string) (dbus.Variant, error) {
-		accessed = true
-		return dbus.MakeVariant(false), nil
-	}
+	bus.AssertCalls(t, activeProperty())
+	bus.AssertNotCalled(t, MockCall{Method: getClient})
+	bus.Reset()
+	bus.SetProperty(MockClientPath, clientActive, false)
 	err = gc2.ensureClient()
 	assert.NoError(t, err)
 	assert.NotNil(t, gc2.client)
-	assert.True(t, called)
-	assert.True(t, accessed)
+	bus.AssertCalls(t, activeProperty(), MockCall{Method: getClient}, MockCall{Method: clientStart})
 }
 
 //func getObjInto(intf string, obj dbus.BusObject, into interface{}) error
@@ -222,180 +139,46 @@ func TestGetObjInto(t *testing.T) {
 		F: math.Pi,
 		E: Embed{1, 2},
 	}
-	obj := MockBusObject{
-		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-			switch args[1] {
-			case "I":
-				return &dbus.Call{
-					Body: body(dbus.MakeVariant(strct1.I)),
-				}
-			case "S":
-				return &dbus.Call{
-					Body: body(dbus.MakeVariant(strct1.S)),
-				}
-			case "F":
-				return &dbus.Call{
-					Body: body(dbus.MakeVariant(strct1.F)),
-				}
-			case "E":
-				return &dbus.Call{
-					Body: body(dbus.MakeVariant(strct1.E)),
-				}
-			}
-			return nil
-		},
-	}
+	bus := NewMockBus()
+	bus.SetProperty("/obj", "test.I", strct1.I)
+	bus.SetProperty("/obj", "test.S", strct1.S)
+	bus.SetProperty("/obj", "test.F", strct1.F)
+	bus.SetProperty("/obj", "test.E", strct1.E)
 	strct2 := Strct{}
-	err := getObjInto("", &obj, &strct2)
+	err := getObjInto("test", bus.Object(geoClue2Interface, "/obj"), &strct2)
 	assert.NoError(t, err)
 	assert.Equal(t, strct1, strct2)
 }
 
-func dbusCall(x interface{}) *dbus.Call {
-	body := make([]interface{}, 1)
-	body[0] = x
-	return &dbus.Call{
-		Body: body,
-	}
-}
-
-func mockDbusConn(t *testing.T, manager *MockBusObject, client *MockBusObject, location *MockBusObject) *MockDbusConn {
-	if manager == nil {
-		manager = &MockBusObject{
-			DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-				return &dbus.Call{
-					Destination: "org.freedesktop.GeoClue2",
-					Path:        "/org/freedesktop/GeoClue2/Manager",
-					Method:      "GetClient",
-					Body:        body("/org/freedesktop/GeoClue2/Client/10"),
-				}
-			},
-		}
-	}
-	if client == nil {
-		client = &MockBusObject{
-			DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-				return &dbus.Call{}
-			},
-			DoGetProperty: func(p string) (dbus.Variant, error) {
-				if p == clientLocation {
-					return dbus.MakeVariant(dbus.ObjectPath("location-path")), nil
-				}
-				return dbus.MakeVariant(true), nil
-			},
-		}
-	}
-	if location == nil {
-		location = &MockBusObject{
-			DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
-				if len(args) < 2 {
-					return &dbus.Call{}
-				}
-				switch args[1].(string) {
-				case "Latitude":
-					return dbusCall(1.23)
-				case "Longitude":
-					return dbusCall(1.23)
-				case "Accuracy":
-					return dbusCall(1.23)
-				case "Altitude":
-					return dbusCall(1.23)
-				case "Speed":
-					return dbusCall(1.23)
-				case "Heading":
-					return dbusCall(1.23)
-				case "Description":
-					return dbusCall("")
-				case "Timestamp":
-					now := time.Now().UnixNano()
-					seconds := now / int64(time.Second)
-					microseconds := (now - seconds*int64(time.Second)) / int64(time.Microsecond)
-					return dbusCall(Timestamp{
-						Seconds:      uint64(seconds),
-						Microseconds: uint64(microseconds),
-					})
-				}
-				return &dbus.Call{}
-			},
-			DoGetProperty: func(p string) (dbus.Variant, error) {
-				return dbus.MakeVariant(true), nil
-			},
-		}
-	}
-	conn := &MockDbusConn{
-		DoObject: func(iface string, path dbus.ObjectPath) dbus.BusObject {
-			if path == managerPath {
-				return manager
-			} else if strings.HasPrefix(string(path), clientPathPrefix) {
-				return client
-			} else if path == "location-path" {
-				return location
-			}
-			t.Error(fmt.Sprintf("invalid path %q", path))
-			return nil
-		},
-		DoSignal: func(ch chan<- *dbus.Signal) {
-		},
-	}
-	return conn
-}
-
 func TestStartStop(t *testing.T) {
-	gc := GeoClue2{
-		conn:        mockDbusConn(t, nil, nil, nil),
-		wg:          sync.WaitGroup{},
-		quit:        make(chan interface{}),
-		dbus:        make(chan *dbus.Signal),
-		subscribe:   make(chan chan Location),
-		unsubscribe: make(chan chan Location),
-	}
+	gc := newGeoClue2(NewMockBus(), "")
 	gc.Start()
 	gc.Stop()
 }
 
 func TestLocationUpdated(t *testing.T) {
-	gc := GeoClue2{
-		conn:        mockDbusConn(t, nil, nil, nil),
-		wg:          sync.WaitGroup{},
-		quit:        make(chan interface{}),
-		dbus:        make(chan *dbus.Signal),
-		subscribe:   make(chan chan Location),
-		unsubscribe: make(chan chan Location),
-	}
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
 	gc.Start()
-	gc.dbus <- &dbus.Signal{
-		Name: locationUpdated,
-	}
+	bus.UpdateLocation(mockLocation())
 	gc.Stop()
 }
 
 func TestGetLocation(t *testing.T) {
-	gc := GeoClue2{
-		conn:        mockDbusConn(t, nil, nil, nil),
-		wg:          sync.WaitGroup{},
-		quit:        make(chan interface{}),
-		dbus:        make(chan *dbus.Signal),
-		subscribe:   make(chan chan Location),
-		unsubscribe: make(chan chan Location),
-	}
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
 	gc.Start()
-	gc.dbus <- &dbus.Signal{
-		Name: locationUpdated,
-	}
+	bus.UpdateLocation(mockLocation())
+	// The second update is received after the first one is processed.
+	bus.UpdateLocation(mockLocation())
 	loc := gc.GetLatestLocation()
 	assert.NotNil(t, loc)
 	gc.Stop()
 }
 
 func TestWaitForLocation(t *testing.T) {
-	gc := GeoClue2{
-		conn:        mockDbusConn(t, nil, nil, nil),
-		wg:          sync.WaitGroup{},
-		quit:        make(chan interface{}),
-		dbus:        make(chan *dbus.Signal),
-		subscribe:   make(chan chan Location),
-		unsubscribe: make(chan chan Location),
-	}
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
 	gc.Start()
 	quit := make(chan interface{})
 	go func() {
@@ -406,9 +189,7 @@ func TestWaitForLocation(t *testing.T) {
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				gc.dbus <- &dbus.Signal{
-					Name: locationUpdated,
-				}
+				bus.UpdateLocation(mockLocation())
 			}
 		}
 	}()
@@ -418,3 +199,220 @@ func TestWaitForLocation(t *testing.T) {
 	assert.NoError(t, err)
 	gc.Stop()
 }
+
+type offsetFilter struct{}
+
+func (offsetFilter) Filter(loc Location) (Location, bool) {
+	loc.Latitude += 1
+	return loc, true
+}
+
+func TestFilter(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.SetFilter(offsetFilter{})
+	gc.Start()
+	ch := make(chan Location, 1)
+	raw := make(chan Location, 1)
+	gc.Subscribe(ch)
+	gc.SubscribeRaw(raw)
+	bus.UpdateLocation(mockLocation())
+	assert.Equal(t, 1.23, (<-raw).Latitude)
+	assert.Equal(t, 2.23, (<-ch).Latitude)
+	assert.Equal(t, 2.23, gc.GetLatestLocation().Latitude)
+	gc.Stop()
+	_, ok := <-raw
+	assert.False(t, ok)
+}
+
+func TestDeriveMotionUpdates(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.SetDeriveMotion(true)
+	gc.Start()
+	ch := make(chan Location, 2)
+	gc.Subscribe(ch)
+	loc := mockLocation()
+	loc.Speed = UnknownSpeed
+	loc.Heading = UnknownHeading
+	bus.UpdateLocation(loc)
+	first := <-ch
+	assert.False(t, first.HasSpeed())
+	// The client's location is read when the update is processed, so the
+	// next one can only be set afterwards.
+	loc.Timestamp = FromTime(loc.Timestamp.Time().Add(time.Second))
+	bus.UpdateLocation(loc)
+	second := <-ch
+	assert.True(t, second.SpeedDerived)
+	assert.Equal(t, 0.0, second.Speed)
+	// Not moving, so the heading is unknown.
+	assert.False(t, second.HasHeading())
+	gc.Stop()
+}
+
+func TestGeoClue2SubscribeFiltered(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.Start()
+	ch := make(chan Location, 2)
+	gc.SubscribeFiltered(ch, SubscriptionFilter{MinInterval: time.Hour})
+	bus.UpdateLocation(mockLocation())
+	bus.UpdateLocation(mockLocation())
+	<-ch
+	gc.Unsubscribe(ch)
+	assert.Len(t, ch, 0)
+	gc.Stop()
+}
+
+// countCalls returns the number of calls of method made on bus.
+func countCalls(bus *MockBus, method string) int {
+	n := 0
+	for _, call := range bus.Calls() {
+		if call.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRetry(t *testing.T) {
+	bus := NewMockBus()
+	var lock sync.Mutex
+	failures := 3
+	bus.Handle(managerPath, getClient, func(args ...interface{}) ([]interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failures > 0 {
+			failures--
+			return nil, fmt.Errorf("testing retries")
+		}
+		return []interface{}{MockClientPath}, nil
+	})
+	gc := newGeoClue2(bus, "")
+	gc.SetRetryInterval(10*time.Millisecond, 20*time.Millisecond)
+	gc.Start()
+	defer gc.Stop()
+	ch := make(chan Location, 1)
+	gc.Subscribe(ch)
+	// The client is set up without any events, after the failures.
+	deadline := time.Now().Add(5 * time.Second)
+	for countCalls(bus, clientStart) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 4, countCalls(bus, getClient))
+	bus.UpdateLocation(mockLocation())
+	assert.Equal(t, 1.23, (<-ch).Latitude)
+	assert.NoError(t, gc.Err())
+}
+
+func TestRetryOnlyWhenDue(t *testing.T) {
+	bus := NewMockBus()
+	bus.Fail(managerPath, getClient, fmt.Errorf("testing retries"))
+	gc := newGeoClue2(bus, "")
+	gc.SetRetryInterval(time.Hour, time.Hour)
+	gc.Start()
+	defer gc.Stop()
+	// Events while the client is down don't trigger attempts, only the
+	// retry timer does.
+	for i := 0; i < 10; i++ {
+		ch := make(chan Location, 1)
+		gc.Subscribe(ch)
+		gc.Unsubscribe(ch)
+		bus.UpdateLocation(mockLocation())
+	}
+	assert.Equal(t, 1, countCalls(bus, getClient))
+	assert.Error(t, gc.Err())
+}
+
+func TestSetRetryInterval(t *testing.T) {
+	gc := newGeoClue2(NewMockBus(), "")
+	gc.SetRetryInterval(0, time.Millisecond)
+	assert.Equal(t, DefaultMinRetryInterval, gc.minRetryInterval)
+	assert.Equal(t, DefaultMinRetryInterval, gc.maxRetryInterval)
+}
+
+func TestDisconnected(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.Start()
+	defer gc.Stop()
+	ch := make(chan Location, 1)
+	gc.Subscribe(ch)
+	bus.Emit("/org/freedesktop/DBus/Local", disconnected)
+	// Subscribing again is handled after the client is set up again.
+	gc.Subscribe(ch)
+	assert.Equal(t, 2, countCalls(bus, getClient))
+	bus.UpdateLocation(mockLocation())
+	assert.Equal(t, 1.23, (<-ch).Latitude)
+}
+
+func TestConnectionClosed(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.Start()
+	ch := make(chan Location, 1)
+	gc.Subscribe(ch)
+	bus.Close()
+	gc.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscribeAfterStop(t *testing.T) {
+	gc := newGeoClue2(NewMockBus(), "")
+	gc.Start()
+	ch := make(chan Location, 1)
+	gc.Subscribe(ch)
+	gc.Stop()
+	// None of these block once the main loop is gone.
+	gc.Unsubscribe(ch)
+	gc.UnsubscribeRaw(ch)
+	for _, subscribe := range []func(chan Location){
+		gc.Subscribe,
+		gc.SubscribeRaw,
+		func(ch chan Location) { gc.SubscribeFiltered(ch, SubscriptionFilter{}) },
+	} {
+		late := make(chan Location)
+		subscribe(late)
+		_, ok := <-late
+		assert.False(t, ok)
+	}
+	_, err := gc.WaitForLocation(context.Background())
+	assert.Error(t, err)
+	gc.Stop()
+}
+
+func TestSubscribeRawSameChannel(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	gc.Start()
+	ch := make(chan Location, 2)
+	gc.Subscribe(ch)
+	gc.SubscribeRaw(ch)
+	bus.UpdateLocation(mockLocation())
+	<-ch
+	<-ch
+	gc.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestSubscribeBeforeStart(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "")
+	ch := make(chan Location, 1)
+	raw := make(chan Location, 1)
+	gone := make(chan Location, 1)
+	gc.Subscribe(ch)
+	gc.SubscribeRaw(raw)
+	gc.Subscribe(gone)
+	gc.Unsubscribe(gone)
+	gc.Start()
+	bus.UpdateLocation(mockLocation())
+	<-ch
+	<-raw
+	gc.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Len(t, gone, 0)
+}
@@ -0,0 +1,310 @@
+// Package geocode is an offline reverse geocoder. It finds the place nearest
+// to a location in a dataset loaded from GeoNames dumps, e.g. cities1000.txt
+// and admin1CodesASCII.txt from https://download.geonames.org/export/dump/,
+// or from a CSV file.
+package geocode
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+)
+
+// Place is a populated place.
+type Place struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+	// CountryCode is the ISO 3166-1 alpha-2 country code.
+	CountryCode string
+	// Admin1Code is the code of the first-level administrative division,
+	// e.g. a state or province, within the country.
+	Admin1Code string
+	// Admin1 is the name of the first-level administrative division, if
+	// known.
+	Admin1     string
+	Population int64
+	// Timezone is the IANA time zone of the place, if known.
+	Timezone string
+}
+
+// Result is the place nearest to a location.
+type Result struct {
+	Place
+	// Distance from the location to the place, in meters.
+	Distance float64
+}
+
+// Description returns a human-readable description of the place, e.g.
+// "Budapest, Budapest, HU".
+func (r Result) Description() string {
+	parts := []string{r.Name}
+	if r.Admin1 != "" {
+		parts = append(parts, r.Admin1)
+	}
+	if r.CountryCode != "" {
+		parts = append(parts, r.CountryCode)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Geocoder finds the nearest place to locations.
+type Geocoder struct {
+	places []Place
+	tree   *kdTree
+}
+
+// New creates a geocoder for places.
+func New(places []Place) *Geocoder {
+	return &Geocoder{
+		places: places,
+		tree:   newKDTree(places),
+	}
+}
+
+// Len returns the number of places in the dataset.
+func (g *Geocoder) Len() int {
+	return len(g.places)
+}
+
+// Nearest returns the place nearest to lat, lon. It returns false if the
+// dataset is empty.
+func (g *Geocoder) Nearest(lat, lon float64) (Result, bool) {
+	i := g.tree.nearest(lat, lon)
+	if i < 0 {
+		return Result{}, false
+	}
+	p := g.places[i]
+	from := geoclue2.Location{Latitude: lat, Longitude: lon}
+	to := geoclue2.Location{Latitude: p.Latitude, Longitude: p.Longitude}
+	return Result{Place: p, Distance: from.DistanceTo(to)}, true
+}
+
+// Annotation is a location annotated with the nearest place.
+type Annotation struct {
+	geoclue2.Location
+	// Nearest is the place nearest to the location.
+	Nearest Result
+}
+
+// Annotate looks up the place nearest to loc. If loc has no description, it's
+// set to the description of the place. It returns false if the dataset is
+// empty.
+func (g *Geocoder) Annotate(loc geoclue2.Location) (Annotation, bool) {
+	r, ok := g.Nearest(loc.Latitude, loc.Longitude)
+	if !ok {
+		return Annotation{Location: loc}, false
+	}
+	if loc.Description == "" {
+		loc.Description = r.Description()
+	}
+	return Annotation{Location: loc, Nearest: r}, true
+}
+
+// Load creates a geocoder from a GeoNames dump of places and, optionally, the
+// admin1CodesASCII.txt file with the names of administrative divisions.
+func Load(placesPath, admin1Path string) (*Geocoder, error) {
+	places, err := readFile(placesPath, ReadGeoNames)
+	if err != nil {
+		return nil, err
+	}
+	if admin1Path != "" {
+		f, err := os.Open(admin1Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		names, err := ReadAdmin1Codes(f)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", admin1Path, err)
+		}
+		SetAdmin1Names(places, names)
+	}
+	return New(places), nil
+}
+
+// LoadCSV creates a geocoder from a CSV file, see ReadCSV.
+func LoadCSV(path string) (*Geocoder, error) {
+	places, err := readFile(path, ReadCSV)
+	if err != nil {
+		return nil, err
+	}
+	return New(places), nil
+}
+
+func readFile(path string, read func(io.Reader) ([]Place, error)) ([]Place, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	places, err := read(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return places, nil
+}
+
+// Columns of the GeoNames "geoname" table.
+const (
+	geoNamesName        = 1
+	geoNamesLatitude    = 4
+	geoNamesLongitude   = 5
+	geoNamesCountryCode = 8
+	geoNamesAdmin1Code  = 10
+	geoNamesPopulation  = 14
+	geoNamesTimezone    = 17
+	geoNamesColumns     = 19
+)
+
+func newTSVReader(r io.Reader, fields int) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = '\t'
+	cr.Comment = '#'
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = fields
+	cr.ReuseRecord = true
+	return cr
+}
+
+// ReadGeoNames reads places from a GeoNames dump, e.g. cities1000.txt. The
+// Admin1 names are not part of it; see SetAdmin1Names.
+func ReadGeoNames(r io.Reader) ([]Place, error) {
+	cr := newTSVReader(r, geoNamesColumns)
+	var places []Place
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(record[geoNamesLatitude], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latitude %q", record[geoNamesLatitude])
+		}
+		lon, err := strconv.ParseFloat(record[geoNamesLongitude], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid longitude %q", record[geoNamesLongitude])
+		}
+		pop, _ := strconv.ParseInt(record[geoNamesPopulation], 10, 64)
+		places = append(places, Place{
+			Name:        record[geoNamesName],
+			Latitude:    lat,
+			Longitude:   lon,
+			CountryCode: record[geoNamesCountryCode],
+			Admin1Code:  record[geoNamesAdmin1Code],
+			Population:  pop,
+			Timezone:    record[geoNamesTimezone],
+		})
+	}
+	return places, nil
+}
+
+// ReadAdmin1Codes reads the GeoNames admin1CodesASCII.txt file. The keys of
+// the returned map are of the form "<country code>.<admin1 code>", e.g.
+// "HU.05".
+func ReadAdmin1Codes(r io.Reader) (map[string]string, error) {
+	cr := newTSVReader(r, 4)
+	names := make(map[string]string)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names[record[0]] = record[1]
+	}
+	return names, nil
+}
+
+// SetAdmin1Names sets the Admin1 field of places from names, as returned by
+// ReadAdmin1Codes.
+func SetAdmin1Names(places []Place, names map[string]string) {
+	for i := range places {
+		p := &places[i]
+		if name, ok := names[p.CountryCode+"."+p.Admin1Code]; ok {
+			p.Admin1 = name
+		}
+	}
+}
+
+// CSV column names, see ReadCSV.
+const (
+	CSVName        = "name"
+	CSVLatitude    = "latitude"
+	CSVLongitude   = "longitude"
+	CSVCountryCode = "country_code"
+	CSVAdmin1      = "admin1"
+	CSVPopulation  = "population"
+	CSVTimezone    = "timezone"
+)
+
+// ReadCSV reads places from a CSV file with a header row. The name, latitude
+// and longitude columns are required; country_code, admin1, population and
+// timezone are optional. Other columns are ignored.
+func ReadCSV(r io.Reader) ([]Place, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{CSVName, CSVLatitude, CSVLongitude} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q", required)
+		}
+	}
+	get := func(record []string, column string) string {
+		if i, ok := columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var places []Place
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(get(record, CSVLatitude), 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return nil, fmt.Errorf("invalid latitude %q", get(record, CSVLatitude))
+		}
+		lon, err := strconv.ParseFloat(get(record, CSVLongitude), 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return nil, fmt.Errorf("invalid longitude %q", get(record, CSVLongitude))
+		}
+		p := Place{
+			Name:        get(record, CSVName),
+			Latitude:    lat,
+			Longitude:   lon,
+			CountryCode: get(record, CSVCountryCode),
+			Admin1:      get(record, CSVAdmin1),
+			Timezone:    get(record, CSVTimezone),
+		}
+		if pop := get(record, CSVPopulation); pop != "" {
+			p.Population, err = strconv.ParseInt(pop, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid population %q", pop)
+			}
+		}
+		places = append(places, p)
+	}
+	return places, nil
+}
@@ -0,0 +1,144 @@
+package geocode
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+	"github.com/stretchr/testify/assert"
+)
+
+// geoNamesLine builds a line of a GeoNames dump.
+func geoNamesLine(id, name, lat, lon, cc, admin1, pop, tz string) string {
+	return strings.Join([]string{
+		id, name, name, "", lat, lon, "P", "PPLC", cc, "", admin1, "", "", "",
+		pop, "", "100", tz, "2020-01-01",
+	}, "\t")
+}
+
+var geoNamesDump = strings.Join([]string{
+	geoNamesLine("3054643", "Budapest", "47.49835", "19.04045", "HU", "05", "1741041", "Europe/Budapest"),
+	geoNamesLine("2761369", "Vienna", "48.20849", "16.37208", "AT", "09", "1691468", "Europe/Vienna"),
+	geoNamesLine("2193733", "Auckland", "-36.84853", "174.76349", "NZ", "E7", "417910", "Pacific/Auckland"),
+	geoNamesLine("4032243", "Nuku'alofa", "-21.13938", "-175.2018", "TO", "02", "22400", "Pacific/Tongatapu"),
+}, "\n") + "\n"
+
+const admin1Codes = "HU.05\tBudapest\tBudapest\t3054638\n" +
+	"AT.09\tVienna\tVienna\t2761367\n"
+
+func TestReadGeoNames(t *testing.T) {
+	places, err := ReadGeoNames(strings.NewReader(geoNamesDump))
+	assert.NoError(t, err)
+	assert.Len(t, places, 4)
+	assert.Equal(t, Place{
+		Name:        "Budapest",
+		Latitude:    47.49835,
+		Longitude:   19.04045,
+		CountryCode: "HU",
+		Admin1Code:  "05",
+		Population:  1741041,
+		Timezone:    "Europe/Budapest",
+	}, places[0])
+
+	names, err := ReadAdmin1Codes(strings.NewReader(admin1Codes))
+	assert.NoError(t, err)
+	SetAdmin1Names(places, names)
+	assert.Equal(t, "Budapest", places[0].Admin1)
+	assert.Equal(t, "Vienna", places[1].Admin1)
+	assert.Equal(t, "", places[2].Admin1)
+
+	_, err = ReadGeoNames(strings.NewReader("1\tfoo\n"))
+	assert.Error(t, err)
+	_, err = ReadGeoNames(strings.NewReader(
+		geoNamesLine("1", "x", "north", "0", "", "", "", "") + "\n"))
+	assert.Error(t, err)
+}
+
+func TestReadCSV(t *testing.T) {
+	places, err := ReadCSV(strings.NewReader(`# Custom places.
+Name,Latitude,Longitude,country_code,admin1,extra
+Office,47.5,19.05,HU,Budapest,ignored
+Cabin,46.9,17.9,,,
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Place{
+		{Name: "Office", Latitude: 47.5, Longitude: 19.05, CountryCode: "HU", Admin1: "Budapest"},
+		{Name: "Cabin", Latitude: 46.9, Longitude: 17.9},
+	}, places)
+
+	for _, doc := range []string{
+		"",
+		"name,latitude\nfoo,1\n",
+		"name,latitude,longitude\nfoo,91,0\n",
+		"name,latitude,longitude\nfoo,1,x\n",
+		"name,latitude,longitude,population\nfoo,1,1,many\n",
+	} {
+		_, err := ReadCSV(strings.NewReader(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	places, err := ReadGeoNames(strings.NewReader(geoNamesDump))
+	assert.NoError(t, err)
+	g := New(places)
+	assert.Equal(t, 4, g.Len())
+	r, ok := g.Nearest(47.4979, 19.0402)
+	assert.True(t, ok)
+	assert.Equal(t, "Budapest", r.Name)
+	assert.InDelta(t, 50, r.Distance, 10)
+	// Closer to Vienna.
+	r, _ = g.Nearest(48, 17)
+	assert.Equal(t, "Vienna", r.Name)
+	// Across the antimeridian, Nuku'alofa is closer than Auckland.
+	r, _ = g.Nearest(-21, 179.9)
+	assert.Equal(t, "Nuku'alofa", r.Name)
+
+	_, ok = New(nil).Nearest(0, 0)
+	assert.False(t, ok)
+}
+
+func TestNearestMatchesBruteForce(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomPlace := func() (float64, float64) {
+		return math.Asin(2*rnd.Float64()-1) * 180 / math.Pi, rnd.Float64()*360 - 180
+	}
+	var places []Place
+	for i := 0; i < 2000; i++ {
+		lat, lon := randomPlace()
+		places = append(places, Place{Latitude: lat, Longitude: lon})
+	}
+	g := New(places)
+	for i := 0; i < 200; i++ {
+		lat, lon := randomPlace()
+		r, ok := g.Nearest(lat, lon)
+		assert.True(t, ok)
+		target := toUnitVector(lat, lon)
+		best := math.Inf(1)
+		for _, p := range places {
+			best = math.Min(best, squaredDistance(target, toUnitVector(p.Latitude, p.Longitude)))
+		}
+		assert.Equal(t, best, squaredDistance(target, toUnitVector(r.Latitude, r.Longitude)))
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	places, _ := ReadGeoNames(strings.NewReader(geoNamesDump))
+	names, _ := ReadAdmin1Codes(strings.NewReader(admin1Codes))
+	SetAdmin1Names(places, names)
+	g := New(places)
+	loc := geoclue2.Location{Latitude: 48.2, Longitude: 16.4, Accuracy: 100}
+	a, ok := g.Annotate(loc)
+	assert.True(t, ok)
+	assert.Equal(t, "Vienna", a.Nearest.Name)
+	assert.Equal(t, "AT", a.Nearest.CountryCode)
+	assert.Equal(t, "Vienna", a.Nearest.Admin1)
+	assert.Equal(t, "Vienna, Vienna, AT", a.Description)
+	assert.Equal(t, 100.0, a.Accuracy)
+
+	loc.Description = "Stephansplatz"
+	a, _ = g.Annotate(loc)
+	assert.Equal(t, "Stephansplatz", a.Description)
+}
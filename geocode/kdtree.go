@@ -0,0 +1,96 @@
+package geocode
+
+import (
+	"math"
+	"sort"
+)
+
+// kdTree is a 3-d tree of places, on points of the unit sphere. The
+// Euclidean distance between such points grows monotonically with the
+// great-circle distance, so the nearest point is the nearest place too, and
+// there are no special cases at the poles or the antimeridian.
+type kdTree struct {
+	nodes []kdNode
+	root  int
+}
+
+type kdNode struct {
+	point       [3]float64
+	index       int
+	axis        int
+	left, right int
+}
+
+func toUnitVector(lat, lon float64) [3]float64 {
+	phi := lat * math.Pi / 180
+	lambda := lon * math.Pi / 180
+	return [3]float64{
+		math.Cos(phi) * math.Cos(lambda),
+		math.Cos(phi) * math.Sin(lambda),
+		math.Sin(phi),
+	}
+}
+
+func newKDTree(places []Place) *kdTree {
+	t := &kdTree{nodes: make([]kdNode, len(places))}
+	indexes := make([]int, len(places))
+	for i, p := range places {
+		t.nodes[i] = kdNode{
+			point: toUnitVector(p.Latitude, p.Longitude),
+			index: i,
+		}
+		indexes[i] = i
+	}
+	t.root = t.build(indexes, 0)
+	return t
+}
+
+// build arranges the nodes in indexes into a subtree, and returns its root.
+func (t *kdTree) build(indexes []int, depth int) int {
+	if len(indexes) == 0 {
+		return -1
+	}
+	axis := depth % 3
+	sort.Slice(indexes, func(i, j int) bool {
+		return t.nodes[indexes[i]].point[axis] < t.nodes[indexes[j]].point[axis]
+	})
+	mid := len(indexes) / 2
+	n := indexes[mid]
+	t.nodes[n].axis = axis
+	t.nodes[n].left = t.build(indexes[:mid], depth+1)
+	t.nodes[n].right = t.build(indexes[mid+1:], depth+1)
+	return n
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// nearest returns the index of the place nearest to lat, lon, or -1 if the
+// tree is empty.
+func (t *kdTree) nearest(lat, lon float64) int {
+	target := toUnitVector(lat, lon)
+	best, bestDist := -1, math.Inf(1)
+	var search func(n int)
+	search = func(n int) {
+		if n < 0 {
+			return
+		}
+		node := &t.nodes[n]
+		if d := squaredDistance(node.point, target); d < bestDist {
+			best, bestDist = node.index, d
+		}
+		diff := target[node.axis] - node.point[node.axis]
+		near, far := node.left, node.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		if diff*diff < bestDist {
+			search(far)
+		}
+	}
+	search(t.root)
+	return best
+}
@@ -0,0 +1,257 @@
+package geoclue2
+
+import (
+	"math"
+	"time"
+)
+
+// Parameters of the WGS84 ellipsoid.
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+	// Mean earth radius, in meters, used for spherical approximations.
+	earthRadius = 6371008.8
+	// Maximum number of iterations for Vincenty's formulae. Near-antipodal
+	// points may not converge, in which case spherical formulae are used.
+	vincentyIterations = 200
+	vincentyEpsilon    = 1e-12
+)
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// normalizeBearing maps a bearing in degrees into [0, 360).
+func normalizeBearing(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+// normalizeLongitude maps a longitude in degrees into [-180, 180].
+func normalizeLongitude(deg float64) float64 {
+	return math.Remainder(deg, 360)
+}
+
+// locationAt returns a location with only the coordinates set.
+func locationAt(lat, lon float64) Location {
+	return Location{
+		Latitude:  lat,
+		Longitude: normalizeLongitude(lon),
+		Altitude:  UnknownAltitude,
+		Speed:     UnknownSpeed,
+		Heading:   UnknownHeading,
+	}
+}
+
+// vincentyInverse solves the inverse geodesic problem on the WGS84
+// ellipsoid. It returns the distance in meters and the initial bearing in
+// degrees, or false if the iteration didn't converge.
+func vincentyInverse(lat1, lon1, lat2, lon2 float64) (float64, float64, bool) {
+	L := radians(normalizeLongitude(lon2 - lon1))
+	U1 := math.Atan((1 - wgs84F) * math.Tan(radians(lat1)))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(radians(lat2)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+	lambda := L
+	var sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	var sinLambda, cosLambda float64
+	converged := false
+	for i := 0; i < vincentyIterations; i++ {
+		sinLambda, cosLambda = math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			// Coincident points.
+			return 0, 0, true
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		} else {
+			// Equatorial line.
+			cos2SigmaM = 0
+		}
+		C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < vincentyEpsilon {
+			converged = true
+			break
+		}
+	}
+	if !converged || math.Abs(lambda) > math.Pi {
+		return 0, 0, false
+	}
+	uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+	distance := wgs84B * A * (sigma - deltaSigma)
+	bearing := math.Atan2(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+	return distance, normalizeBearing(degrees(bearing)), true
+}
+
+// vincentyDirect solves the direct geodesic problem on the WGS84 ellipsoid:
+// the point reached from lat, lon after distance meters on the given initial
+// bearing. It returns false if the iteration didn't converge.
+func vincentyDirect(lat, lon, bearing, distance float64) (float64, float64, bool) {
+	alpha1 := radians(bearing)
+	sinAlpha1, cosAlpha1 := math.Sincos(alpha1)
+	tanU1 := (1 - wgs84F) * math.Tan(radians(lat))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cos2Alpha := 1 - sinAlpha*sinAlpha
+	uSq := cos2Alpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	sigma := distance / (wgs84B * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+	converged := false
+	for i := 0; i < vincentyIterations; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sincos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		prev := sigma
+		sigma = distance/(wgs84B*A) + deltaSigma
+		if math.Abs(sigma-prev) < vincentyEpsilon {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return 0, 0, false
+	}
+	cos2SigmaM = math.Cos(2*sigma1 + sigma)
+	sinSigma, cosSigma = math.Sincos(sigma)
+	x := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	lat2 := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1, (1-wgs84F)*math.Hypot(sinAlpha, x))
+	lambda := math.Atan2(sinSigma*sinAlpha1, cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	C := wgs84F / 16 * cos2Alpha * (4 + wgs84F*(4-3*cos2Alpha))
+	L := lambda - (1-C)*wgs84F*sinAlpha*
+		(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+	return degrees(lat2), normalizeLongitude(lon + degrees(L)), true
+}
+
+// haversine returns the great-circle distance in meters between two points
+// on a sphere with the mean earth radius.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dPhi := phi2 - phi1
+	dLambda := radians(lon2 - lon1)
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// sphericalBearing returns the initial great-circle bearing in degrees.
+func sphericalBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := radians(lat1), radians(lat2)
+	dLambda := radians(lon2 - lon1)
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	return normalizeBearing(degrees(math.Atan2(y, x)))
+}
+
+// sphericalDestination returns the point reached on a great circle.
+func sphericalDestination(lat, lon, bearing, distance float64) (float64, float64) {
+	phi1, lambda1 := radians(lat), radians(lon)
+	theta := radians(bearing)
+	delta := distance / earthRadius
+	phi2 := math.Asin(math.Sin(phi1)*math.Cos(delta) +
+		math.Cos(phi1)*math.Sin(delta)*math.Cos(theta))
+	lambda2 := lambda1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(phi1),
+		math.Cos(delta)-math.Sin(phi1)*math.Sin(phi2))
+	return degrees(phi2), normalizeLongitude(degrees(lambda2))
+}
+
+// DistanceTo returns the distance in meters between the location and other,
+// along the WGS84 ellipsoid, using Vincenty's formulae. For nearly antipodal
+// points, where those don't converge, the haversine formula is used instead.
+func (l Location) DistanceTo(other Location) float64 {
+	d, _, ok := vincentyInverse(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+	if !ok {
+		return haversine(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+	}
+	return d
+}
+
+// InitialBearingTo returns the initial bearing, in degrees clockwise from
+// North, of the shortest path from the location to other.
+func (l Location) InitialBearingTo(other Location) float64 {
+	_, b, ok := vincentyInverse(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+	if !ok {
+		return sphericalBearing(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+	}
+	return b
+}
+
+// DestinationPoint returns the location reached after travelling distance
+// meters from the location, on the given initial bearing in degrees. Only
+// the coordinates of the result are set; altitude, speed and heading are
+// unknown.
+func (l Location) DestinationPoint(bearing, distance float64) Location {
+	lat, lon, ok := vincentyDirect(l.Latitude, l.Longitude, bearing, distance)
+	if !ok {
+		lat, lon = sphericalDestination(l.Latitude, l.Longitude, bearing, distance)
+	}
+	return locationAt(lat, lon)
+}
+
+// MidpointTo returns the point halfway along the great circle between the
+// location and other. Only the coordinates of the result are set.
+func (l Location) MidpointTo(other Location) Location {
+	phi1, lambda1 := radians(l.Latitude), radians(l.Longitude)
+	phi2 := radians(other.Latitude)
+	dLambda := radians(other.Longitude - l.Longitude)
+	bx := math.Cos(phi2) * math.Cos(dLambda)
+	by := math.Cos(phi2) * math.Sin(dLambda)
+	phi3 := math.Atan2(math.Sin(phi1)+math.Sin(phi2),
+		math.Sqrt((math.Cos(phi1)+bx)*(math.Cos(phi1)+bx)+by*by))
+	lambda3 := lambda1 + math.Atan2(by, math.Cos(phi1)+bx)
+	return locationAt(degrees(phi3), degrees(lambda3))
+}
+
+// Longest interval between two locations DeriveMotion computes speed and
+// heading for. Over longer intervals the average speed is meaningless.
+const maxMotionInterval = 2 * time.Minute
+
+// DeriveMotion fills in the speed and heading of cur from the displacement
+// since prev, if they're unknown. Derived values are flagged with
+// SpeedDerived and HeadingDerived. Nothing is derived if either location
+// lacks a timestamp, or if they're not in order or too far apart in time.
+// The heading is only derived if the displacement exceeds the accuracy of
+// both locations, as it would be mostly noise otherwise.
+func DeriveMotion(prev, cur Location) Location {
+	if prev.Timestamp.IsZero() || cur.Timestamp.IsZero() {
+		return cur
+	}
+	dt := cur.Timestamp.Time().Sub(prev.Timestamp.Time())
+	if dt <= 0 || dt > maxMotionInterval {
+		return cur
+	}
+	distance := prev.DistanceTo(cur)
+	if !cur.HasSpeed() {
+		cur.Speed = distance / dt.Seconds()
+		cur.SpeedDerived = true
+	}
+	if !cur.HasHeading() && distance > 0 && distance >= math.Max(prev.Accuracy, cur.Accuracy) {
+		cur.Heading = prev.InitialBearingTo(cur)
+		cur.HeadingDerived = true
+	}
+	return cur
+}
@@ -0,0 +1,141 @@
+package geoclue2
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dms converts degrees, minutes and seconds to decimal degrees.
+func dms(d, m, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+// Vincenty's own worked example, from "Direct and inverse solutions of
+// geodesics on the ellipsoid with application of nested equations" (1975),
+// as republished by Geoscience Australia: Flinders Peak to Buninyong.
+var (
+	flindersPeak = locationAt(dms(-37, 57, 3.72030), dms(144, 25, 29.52440))
+	buninyong    = locationAt(dms(-37, 39, 10.15610), dms(143, 55, 35.38390))
+)
+
+func TestDistanceTo(t *testing.T) {
+	assert.InDelta(t, 54972.271, flindersPeak.DistanceTo(buninyong), 0.001)
+	assert.InDelta(t, 54972.271, buninyong.DistanceTo(flindersPeak), 0.001)
+	assert.Equal(t, 0.0, flindersPeak.DistanceTo(flindersPeak))
+
+	// One degree of longitude along the equator is a/180*pi.
+	a := locationAt(0, 0)
+	b := locationAt(0, 1)
+	assert.InDelta(t, 111319.491, a.DistanceTo(b), 0.001)
+	// One degree of latitude from the equator, from GeographicLib.
+	assert.InDelta(t, 110574.389, a.DistanceTo(locationAt(1, 0)), 0.001)
+	// Across the antimeridian.
+	assert.InDelta(t, 111319.491, locationAt(0, 179.5).DistanceTo(locationAt(0, -179.5)), 0.001)
+}
+
+func TestDistanceToAntipodal(t *testing.T) {
+	// Vincenty's inverse formula doesn't converge for nearly antipodal
+	// points; the haversine distance is returned instead.
+	a := locationAt(0, 0)
+	b := locationAt(0.5, 179.7)
+	_, _, ok := vincentyInverse(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	assert.False(t, ok)
+	d := a.DistanceTo(b)
+	assert.InDelta(t, haversine(0, 0, 0.5, 179.7), d, 1e-6)
+	assert.InDelta(t, 20000000, d, 50000)
+}
+
+func TestInitialBearingTo(t *testing.T) {
+	assert.InDelta(t, dms(306, 52, 5.37), flindersPeak.InitialBearingTo(buninyong), 1e-5)
+	// The reverse azimuth, from Buninyong back to Flinders Peak.
+	assert.InDelta(t, dms(127, 10, 25.07), buninyong.InitialBearingTo(flindersPeak), 1e-5)
+	a := locationAt(0, 0)
+	assert.InDelta(t, 0, a.InitialBearingTo(locationAt(1, 0)), 1e-9)
+	assert.InDelta(t, 90, a.InitialBearingTo(locationAt(0, 1)), 1e-9)
+	assert.InDelta(t, 180, a.InitialBearingTo(locationAt(-1, 0)), 1e-9)
+	assert.InDelta(t, 270, a.InitialBearingTo(locationAt(0, -1)), 1e-9)
+}
+
+func TestDestinationPoint(t *testing.T) {
+	p := flindersPeak.DestinationPoint(dms(306, 52, 5.37), 54972.271)
+	assert.InDelta(t, buninyong.Latitude, p.Latitude, 1e-8)
+	assert.InDelta(t, buninyong.Longitude, p.Longitude, 1e-8)
+	assert.False(t, p.HasAltitude())
+	assert.False(t, p.HasSpeed())
+	assert.False(t, p.HasHeading())
+
+	p = locationAt(0, 179.5).DestinationPoint(90, 111319.491)
+	assert.InDelta(t, 0, p.Latitude, 1e-9)
+	assert.InDelta(t, -179.5, p.Longitude, 1e-8)
+
+	// Round trip.
+	start := locationAt(47.4979, 19.0402)
+	dest := start.DestinationPoint(42, 12345)
+	assert.InDelta(t, 12345, start.DistanceTo(dest), 1e-6)
+	assert.InDelta(t, 42, start.InitialBearingTo(dest), 1e-8)
+}
+
+func TestMidpointTo(t *testing.T) {
+	m := locationAt(0, 0).MidpointTo(locationAt(0, 90))
+	assert.InDelta(t, 0, m.Latitude, 1e-9)
+	assert.InDelta(t, 45, m.Longitude, 1e-9)
+	m = locationAt(0, 170).MidpointTo(locationAt(0, -170))
+	assert.InDelta(t, 180, math.Abs(m.Longitude), 1e-9)
+	// London to Paris, from the Movable Type Scripts examples.
+	m = locationAt(51.5074, -0.1278).MidpointTo(locationAt(48.8566, 2.3522))
+	assert.InDelta(t, 50.1886, m.Latitude, 1e-4)
+	assert.InDelta(t, 1.1461, m.Longitude, 1e-3)
+	a := locationAt(47.4979, 19.0402)
+	b := locationAt(48.2082, 16.3738)
+	m = a.MidpointTo(b)
+	assert.InDelta(t, a.DistanceTo(m), m.DistanceTo(b), 50)
+}
+
+func TestDeriveMotion(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	prev := locationAt(0, 0)
+	prev.Accuracy = 10
+	prev.Timestamp = FromTime(start)
+	cur := prev.DestinationPoint(90, 100)
+	cur.Accuracy = 10
+	cur.Timestamp = FromTime(start.Add(10 * time.Second))
+	loc := DeriveMotion(prev, cur)
+	assert.InDelta(t, 10, loc.Speed, 1e-6)
+	assert.InDelta(t, 90, loc.Heading, 1e-6)
+	assert.True(t, loc.SpeedDerived)
+	assert.True(t, loc.HeadingDerived)
+
+	// Measured values are kept.
+	measured := cur
+	measured.Speed = 9
+	measured.Heading = 91
+	loc = DeriveMotion(prev, measured)
+	assert.Equal(t, 9.0, loc.Speed)
+	assert.Equal(t, 91.0, loc.Heading)
+	assert.False(t, loc.SpeedDerived)
+	assert.False(t, loc.HeadingDerived)
+
+	// The displacement is within the accuracy: no heading.
+	cur.Accuracy = 200
+	loc = DeriveMotion(prev, cur)
+	assert.True(t, loc.SpeedDerived)
+	assert.False(t, loc.HasHeading())
+
+	// Out of order, too far apart, or without timestamps.
+	for _, ts := range []Timestamp{
+		FromTime(start.Add(-time.Second)),
+		FromTime(start.Add(time.Hour)),
+		{},
+	} {
+		cur.Timestamp = ts
+		loc = DeriveMotion(prev, cur)
+		assert.False(t, loc.HasSpeed())
+		assert.False(t, loc.SpeedDerived)
+	}
+}
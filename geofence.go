@@ -0,0 +1,508 @@
+package geoclue2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGeofenceHysteresis is the distance, in meters, a location has
+	// to be beyond the boundary of a fence to change its state.
+	DefaultGeofenceHysteresis = 10.0
+	// DefaultGeofenceDwellTime is how long a location has to stay inside a
+	// fence before a GeofenceDwell event is emitted.
+	DefaultGeofenceDwellTime = 5 * time.Minute
+)
+
+// Fence is a geographic area monitored by Geofencer.
+type Fence interface {
+	// ID identifies the fence in events.
+	ID() string
+	// Distance returns the signed distance in meters from loc to the
+	// boundary of the fence. It's negative if loc is inside the fence.
+	Distance(loc Location) float64
+}
+
+// CircleFence is a circular fence.
+type CircleFence struct {
+	Name   string
+	Center Location
+	// Radius in meters.
+	Radius float64
+}
+
+// ID returns the name of the fence.
+func (c *CircleFence) ID() string {
+	return c.Name
+}
+
+// Distance returns the signed distance from loc to the circle.
+func (c *CircleFence) Distance(loc Location) float64 {
+	return c.Center.DistanceTo(loc) - c.Radius
+}
+
+// PolygonFence is a polygonal fence, optionally with holes. Edges are
+// treated as straight lines in latitude and longitude, which is accurate
+// enough for fences up to a few kilometers in size. Polygons crossing the
+// antimeridian are not supported.
+type PolygonFence struct {
+	Name string
+	// Exterior is the outer boundary of the polygon.
+	Exterior []Location
+	// Holes are areas excluded from the polygon.
+	Holes [][]Location
+}
+
+// ID returns the name of the fence.
+func (p *PolygonFence) ID() string {
+	return p.Name
+}
+
+// Distance returns the signed distance from loc to the closest edge of the
+// polygon.
+func (p *PolygonFence) Distance(loc Location) float64 {
+	inside := ringContains(p.Exterior, loc)
+	d := ringDistance(p.Exterior, loc)
+	for _, hole := range p.Holes {
+		if ringContains(hole, loc) {
+			inside = false
+		}
+		d = math.Min(d, ringDistance(hole, loc))
+	}
+	if inside {
+		return -d
+	}
+	return d
+}
+
+// ringContains reports whether loc is inside ring, using the even-odd rule.
+func ringContains(ring []Location, loc Location) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Latitude > loc.Latitude) != (b.Latitude > loc.Latitude) {
+			lon := a.Longitude + (loc.Latitude-a.Latitude)/(b.Latitude-a.Latitude)*
+				(b.Longitude-a.Longitude)
+			if loc.Longitude < lon {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// ringDistance returns the distance in meters from loc to the closest edge
+// of ring. The edges are projected onto a plane tangent at loc.
+func ringDistance(ring []Location, loc Location) float64 {
+	cosLat := math.Cos(radians(loc.Latitude))
+	project := func(p Location) (float64, float64) {
+		x := radians(normalizeLongitude(p.Longitude-loc.Longitude)) * cosLat * earthRadius
+		y := radians(p.Latitude-loc.Latitude) * earthRadius
+		return x, y
+	}
+	d := math.Inf(1)
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		ax, ay := project(ring[j])
+		bx, by := project(ring[i])
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+		}
+		d = math.Min(d, math.Hypot(ax+t*dx, ay+t*dy))
+	}
+	return d
+}
+
+// GeofenceState is the position of a location relative to a fence.
+type GeofenceState int
+
+const (
+	// GeofenceUnknown means no location has been classified yet.
+	GeofenceUnknown GeofenceState = iota
+	// GeofenceOutside means the location is certainly outside the fence.
+	GeofenceOutside
+	// GeofenceUncertain means the accuracy circle of the location overlaps
+	// the boundary of the fence.
+	GeofenceUncertain
+	// GeofenceInside means the location is certainly inside the fence.
+	GeofenceInside
+)
+
+func (s GeofenceState) String() string {
+	switch s {
+	case GeofenceOutside:
+		return "outside"
+	case GeofenceUncertain:
+		return "uncertain"
+	case GeofenceInside:
+		return "inside"
+	}
+	return "unknown"
+}
+
+// GeofenceEventType is the type of a GeofenceEvent.
+type GeofenceEventType int
+
+const (
+	// GeofenceEnter is emitted when a location enters a fence.
+	GeofenceEnter GeofenceEventType = iota
+	// GeofenceExit is emitted when a location leaves a fence.
+	GeofenceExit
+	// GeofenceDwell is emitted once per entry, when a location has been
+	// inside a fence for DwellTime.
+	GeofenceDwell
+)
+
+func (t GeofenceEventType) String() string {
+	switch t {
+	case GeofenceEnter:
+		return "enter"
+	case GeofenceExit:
+		return "exit"
+	case GeofenceDwell:
+		return "dwell"
+	}
+	return fmt.Sprintf("GeofenceEventType(%d)", int(t))
+}
+
+// GeofenceEvent is a transition of a location relative to a fence.
+type GeofenceEvent struct {
+	Type GeofenceEventType
+	// Fence is the ID of the fence.
+	Fence string
+	// Location is the location update that triggered the event.
+	Location Location
+	// Time is the timestamp of the location, or the time it was processed
+	// if it has none.
+	Time time.Time
+}
+
+type fenceState struct {
+	fence   Fence
+	state   GeofenceState
+	current GeofenceState
+	entered time.Time
+	dwelled bool
+}
+
+// Geofencer monitors the location updates of a provider and emits events to
+// its subscribers when they enter, leave or dwell in fences.
+//
+// The accuracy of the location is taken into account: a location is only
+// considered inside a fence if its whole accuracy circle is, and outside if
+// none of it is. Otherwise it's uncertain, and the state of the fence doesn't
+// change. To avoid flapping at the boundary, the accuracy circle has to be
+// at least Hysteresis meters clear of the boundary as well.
+//
+// The first location certainly inside a fence triggers a GeofenceEnter
+// event; the first location outside only sets its state.
+type Geofencer struct {
+	// Hysteresis is the distance in meters the accuracy circle of a location
+	// has to be beyond the boundary of a fence to change its state.
+	Hysteresis float64
+	// DwellTime is how long a location has to stay in a fence before a
+	// GeofenceDwell event is emitted. Dwell events are disabled if it's zero.
+	// Dwelling is checked on location updates.
+	DwellTime   time.Duration
+	now         func() time.Time
+	lock        sync.Mutex
+	fences      []*fenceState
+	subscribers map[chan<- GeofenceEvent]interface{}
+	logger      Logger
+	sub         subscription
+}
+
+// NewGeofencer creates a geofencer monitoring fences.
+func NewGeofencer(fences ...Fence) *Geofencer {
+	g := &Geofencer{
+		Hysteresis:  DefaultGeofenceHysteresis,
+		DwellTime:   DefaultGeofenceDwellTime,
+		now:         time.Now,
+		subscribers: make(map[chan<- GeofenceEvent]interface{}),
+		logger:      DefaultLogger(),
+	}
+	for _, f := range fences {
+		g.AddFence(f)
+	}
+	return g
+}
+
+// SetLogger sets the Logger of the geofencer. By default, it's the one set
+// with SetDefaultLogger. A nil Logger discards all messages.
+func (g *Geofencer) SetLogger(l Logger) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.logger = orNop(l)
+}
+
+// AddFence starts monitoring f. A fence with the same ID is replaced.
+func (g *Geofencer) AddFence(f Fence) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i, fs := range g.fences {
+		if fs.fence.ID() == f.ID() {
+			g.fences[i] = &fenceState{fence: f}
+			return
+		}
+	}
+	g.fences = append(g.fences, &fenceState{fence: f})
+}
+
+// RemoveFence stops monitoring the fence with the given ID.
+func (g *Geofencer) RemoveFence(id string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for i, fs := range g.fences {
+		if fs.fence.ID() == id {
+			g.fences = append(g.fences[:i], g.fences[i+1:]...)
+			return
+		}
+	}
+}
+
+// State returns the state of the fence with the given ID, based on the last
+// location update. Unlike the events, it reports uncertain locations.
+func (g *Geofencer) State(id string) GeofenceState {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, fs := range g.fences {
+		if fs.fence.ID() == id {
+			return fs.current
+		}
+	}
+	return GeofenceUnknown
+}
+
+// Watch subscribes to p and feeds its location updates to Update, until
+// Stop is called or p shuts down.
+func (g *Geofencer) Watch(p Provider) {
+	g.sub.start(p, func(loc Location) {
+		g.Update(loc)
+	})
+}
+
+// Stop stops watching the provider, and closes the channels of all
+// subscribers.
+func (g *Geofencer) Stop() {
+	g.sub.stop()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for ch := range g.subscribers {
+		close(ch)
+	}
+	g.subscribers = make(map[chan<- GeofenceEvent]interface{})
+}
+
+// Subscribe registers ch to receive geofence events. Events are delivered
+// without blocking, so ch should be buffered if the receiver can't keep up.
+// The channel is closed by Stop.
+func (g *Geofencer) Subscribe(ch chan GeofenceEvent) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.subscribers[ch] = ""
+}
+
+// Unsubscribe stops delivering events to ch.
+func (g *Geofencer) Unsubscribe(ch chan GeofenceEvent) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.subscribers, ch)
+}
+
+// classify returns the state of loc relative to a fence, and whether it's
+// far enough from the boundary to change the state of the fence.
+func (g *Geofencer) classify(f Fence, loc Location) (GeofenceState, bool) {
+	d := f.Distance(loc)
+	acc := math.Max(loc.Accuracy, 0)
+	switch {
+	case d+acc < 0:
+		return GeofenceInside, d+acc+g.Hysteresis <= 0
+	case d-acc > 0:
+		return GeofenceOutside, d-acc-g.Hysteresis >= 0
+	}
+	return GeofenceUncertain, false
+}
+
+// Update processes a location update, and returns the events it triggered.
+// The events are sent to the subscribers too.
+func (g *Geofencer) Update(loc Location) []GeofenceEvent {
+	t := loc.Timestamp.Time()
+	if loc.Timestamp.IsZero() {
+		t = g.now()
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var events []GeofenceEvent
+	emit := func(typ GeofenceEventType, fs *fenceState) {
+		g.logger.Debug("geofence event", "event", typ, "fence", fs.fence.ID())
+		events = append(events, GeofenceEvent{
+			Type:     typ,
+			Fence:    fs.fence.ID(),
+			Location: loc,
+			Time:     t,
+		})
+	}
+	for _, fs := range g.fences {
+		state, clear := g.classify(fs.fence, loc)
+		fs.current = state
+		// Without a previous state there's nothing to debounce.
+		if clear || (fs.state == GeofenceUnknown && state != GeofenceUncertain) {
+			switch {
+			case state == GeofenceInside && fs.state != GeofenceInside:
+				fs.entered = t
+				fs.dwelled = false
+				emit(GeofenceEnter, fs)
+			case state == GeofenceOutside && fs.state == GeofenceInside:
+				emit(GeofenceExit, fs)
+			}
+			fs.state = state
+		}
+		if fs.state == GeofenceInside && !fs.dwelled && g.DwellTime > 0 &&
+			t.Sub(fs.entered) >= g.DwellTime {
+			fs.dwelled = true
+			emit(GeofenceDwell, fs)
+		}
+	}
+	for _, ev := range events {
+		for ch := range g.subscribers {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+	return events
+}
+
+type geoJSONFenceFeature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id"`
+	Geometry   *geoJSONFenceGeometry  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFenceGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// ReadGeofenceFile reads fences from a GeoJSON file, see ParseGeofences.
+func ReadGeofenceFile(path string) ([]Fence, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fences, err := ParseGeofences(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return fences, nil
+}
+
+// ParseGeofences reads fences from a GeoJSON FeatureCollection, or a single
+// Feature. Polygon features become PolygonFences, and Point features with a
+// "radius" property, in meters, become CircleFences. The fences are named
+// after the id of the feature, or its "name" property.
+func ParseGeofences(r io.Reader) ([]Fence, error) {
+	var doc struct {
+		Type     string                `json:"type"`
+		Features []geoJSONFenceFeature `json:"features"`
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	switch doc.Type {
+	case "FeatureCollection":
+	case "Feature":
+		var f geoJSONFenceFeature
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, err
+		}
+		doc.Features = []geoJSONFenceFeature{f}
+	default:
+		return nil, fmt.Errorf("unexpected GeoJSON type %q", doc.Type)
+	}
+	var fences []Fence
+	for i, f := range doc.Features {
+		fence, err := parseGeoJSONFence(f)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %v", i, err)
+		}
+		fences = append(fences, fence)
+	}
+	return fences, nil
+}
+
+func parseGeoJSONFence(f geoJSONFenceFeature) (Fence, error) {
+	name := ""
+	if f.ID != nil {
+		name = fmt.Sprint(f.ID)
+	} else if n, ok := f.Properties["name"].(string); ok {
+		name = n
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing id or name")
+	}
+	if f.Geometry == nil {
+		return nil, fmt.Errorf("missing geometry")
+	}
+	switch f.Geometry.Type {
+	case "Point":
+		var pos []float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &pos); err != nil {
+			return nil, err
+		}
+		if len(pos) < 2 {
+			return nil, fmt.Errorf("invalid position %v", pos)
+		}
+		radius, ok := f.Properties["radius"].(float64)
+		if !ok || radius <= 0 {
+			return nil, fmt.Errorf("point without a positive radius")
+		}
+		return &CircleFence{
+			Name:   name,
+			Center: locationAt(pos[1], pos[0]),
+			Radius: radius,
+		}, nil
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil {
+			return nil, err
+		}
+		if len(rings) == 0 {
+			return nil, fmt.Errorf("polygon without rings")
+		}
+		p := &PolygonFence{Name: name}
+		for i, ring := range rings {
+			if len(ring) < 3 {
+				return nil, fmt.Errorf("ring with %d positions", len(ring))
+			}
+			var locs []Location
+			for _, pos := range ring {
+				if len(pos) < 2 {
+					return nil, fmt.Errorf("invalid position %v", pos)
+				}
+				locs = append(locs, locationAt(pos[1], pos[0]))
+			}
+			if i == 0 {
+				p.Exterior = locs
+			} else {
+				p.Holes = append(p.Holes, locs)
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry %q", f.Geometry.Type)
+}
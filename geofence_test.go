@@ -0,0 +1,182 @@
+package geoclue2
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// north returns a location distance meters north of the origin.
+func north(distance, accuracy float64, t time.Time) Location {
+	loc := locationAt(0, 0).DestinationPoint(0, distance)
+	loc.Accuracy = accuracy
+	loc.Timestamp = FromTime(t)
+	return loc
+}
+
+func eventTypes(events []GeofenceEvent) []GeofenceEventType {
+	types := []GeofenceEventType{}
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func receiveEvent(t *testing.T, ch chan GeofenceEvent) GeofenceEvent {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for geofence event")
+	}
+	return GeofenceEvent{}
+}
+
+func TestCircleFence(t *testing.T) {
+	f := &CircleFence{Name: "home", Center: locationAt(0, 0), Radius: 100}
+	assert.InDelta(t, -100, f.Distance(locationAt(0, 0)), 1e-9)
+	assert.InDelta(t, 50, f.Distance(north(150, 0, time.Time{})), 1e-6)
+}
+
+func TestPolygonFence(t *testing.T) {
+	square := func(d float64) []Location {
+		return []Location{
+			locationAt(-d, -d), locationAt(-d, d), locationAt(d, d), locationAt(d, -d),
+		}
+	}
+	f := &PolygonFence{Name: "square", Exterior: square(0.01)}
+	// 0.01 degrees of latitude on the sphere.
+	edge := radians(0.01) * earthRadius
+	assert.InDelta(t, -edge, f.Distance(locationAt(0, 0)), 1e-6)
+	assert.InDelta(t, edge, f.Distance(locationAt(0.02, 0)), 1e-6)
+	// Closest to a vertex.
+	assert.InDelta(t, edge*1.414, f.Distance(locationAt(0.02, 0.02)), 1)
+
+	f.Holes = [][]Location{square(0.005)}
+	assert.InDelta(t, edge/2, f.Distance(locationAt(0, 0)), 1e-6)
+	assert.True(t, f.Distance(locationAt(0.0075, 0)) < 0)
+}
+
+func TestGeofencer(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	g := NewGeofencer(&CircleFence{Name: "home", Center: locationAt(0, 0), Radius: 100})
+	g.DwellTime = time.Minute
+	ch := make(chan GeofenceEvent, 10)
+	g.Subscribe(ch)
+
+	// The first location outside only sets the state.
+	assert.Empty(t, g.Update(north(500, 10, start)))
+	assert.Equal(t, GeofenceOutside, g.State("home"))
+	// The accuracy circle overlaps the fence.
+	assert.Empty(t, g.Update(north(50, 100, start.Add(time.Second))))
+	assert.Equal(t, GeofenceUncertain, g.State("home"))
+	// Inside, but within the hysteresis margin.
+	assert.Empty(t, g.Update(north(85, 10, start.Add(2*time.Second))))
+	assert.Equal(t, GeofenceInside, g.State("home"))
+
+	events := g.Update(north(0, 10, start.Add(3*time.Second)))
+	assert.Equal(t, []GeofenceEventType{GeofenceEnter}, eventTypes(events))
+	assert.Equal(t, "home", events[0].Fence)
+	assert.Equal(t, start.Add(3*time.Second), events[0].Time)
+	ev := receiveEvent(t, ch)
+	assert.Equal(t, GeofenceEnter, ev.Type)
+
+	// Jitter around the boundary doesn't trigger an exit.
+	assert.Empty(t, g.Update(north(105, 0, start.Add(4*time.Second))))
+	assert.Empty(t, g.Update(north(85, 0, start.Add(5*time.Second))))
+
+	events = g.Update(north(0, 10, start.Add(time.Minute+3*time.Second)))
+	assert.Equal(t, []GeofenceEventType{GeofenceDwell}, eventTypes(events))
+	assert.Empty(t, g.Update(north(0, 10, start.Add(2*time.Minute))))
+
+	events = g.Update(north(200, 10, start.Add(3*time.Minute)))
+	assert.Equal(t, []GeofenceEventType{GeofenceExit}, eventTypes(events))
+	assert.Equal(t, GeofenceDwell, receiveEvent(t, ch).Type)
+	assert.Equal(t, GeofenceExit, receiveEvent(t, ch).Type)
+
+	g.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestGeofencerInitiallyInside(t *testing.T) {
+	g := NewGeofencer(&CircleFence{Name: "home", Center: locationAt(0, 0), Radius: 100})
+	g.DwellTime = 0
+	events := g.Update(north(0, 10, time.Now()))
+	assert.Equal(t, []GeofenceEventType{GeofenceEnter}, eventTypes(events))
+	g.RemoveFence("home")
+	assert.Empty(t, g.Update(north(1000, 10, time.Now())))
+	assert.Equal(t, GeofenceUnknown, g.State("home"))
+}
+
+func TestGeofencerWatch(t *testing.T) {
+	src := newTestProvider()
+	src.Start()
+	g := NewGeofencer(&CircleFence{Name: "home", Center: locationAt(0, 0), Radius: 100})
+	ch := make(chan GeofenceEvent, 1)
+	g.Subscribe(ch)
+	g.Watch(src)
+	src.publish(north(0, 10, time.Now()))
+	ev := receiveEvent(t, ch)
+	assert.Equal(t, GeofenceEnter, ev.Type)
+	g.Stop()
+	src.Stop()
+}
+
+func TestParseGeofences(t *testing.T) {
+	fences, err := ParseGeofences(strings.NewReader(`{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "id": "home",
+      "geometry": {"type": "Point", "coordinates": [19.04, 47.49]},
+      "properties": {"radius": 150}
+    },
+    {
+      "type": "Feature",
+      "geometry": {
+        "type": "Polygon",
+        "coordinates": [
+          [[19, 47], [19.1, 47], [19.1, 47.1], [19, 47.1], [19, 47]],
+          [[19.04, 47.04], [19.06, 47.04], [19.06, 47.06], [19.04, 47.04]]
+        ]
+      },
+      "properties": {"name": "park"}
+    }
+  ]
+}`))
+	assert.NoError(t, err)
+	assert.Len(t, fences, 2)
+	c, ok := fences[0].(*CircleFence)
+	assert.True(t, ok)
+	assert.Equal(t, "home", c.ID())
+	assert.Equal(t, 47.49, c.Center.Latitude)
+	assert.Equal(t, 19.04, c.Center.Longitude)
+	assert.Equal(t, 150.0, c.Radius)
+	p, ok := fences[1].(*PolygonFence)
+	assert.True(t, ok)
+	assert.Equal(t, "park", p.ID())
+	assert.Len(t, p.Exterior, 5)
+	assert.Len(t, p.Holes, 1)
+	assert.True(t, p.Distance(locationAt(47.02, 19.02)) < 0)
+
+	fences, err = ParseGeofences(strings.NewReader(`{"type": "Feature", "id": 7,
+	  "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"radius": 5}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "7", fences[0].ID())
+
+	for _, doc := range []string{
+		`{"type": "Point"}`,
+		`{"type": "Feature", "id": "a", "geometry": {"type": "Point", "coordinates": [1, 2]}}`,
+		`{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"radius": 5}}`,
+		`{"type": "Feature", "id": "a", "geometry": {"type": "LineString", "coordinates": [[1, 2], [3, 4]]}}`,
+		`{"type": "Feature", "id": "a", "geometry": {"type": "Polygon", "coordinates": [[[1, 2], [3, 4]]]}}`,
+		`not json`,
+	} {
+		_, err := ParseGeofences(strings.NewReader(doc))
+		assert.Error(t, err, doc)
+	}
+}
@@ -5,5 +5,5 @@ go 1.13
 require (
 	github.com/godbus/dbus/v5 v5.0.3
 	github.com/stretchr/testify v1.5.1
-	k8s.io/klog v1.0.0
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f
 )
@@ -0,0 +1,216 @@
+package geoclue2
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultKalmanMaxSpeed is the speed, in meters per second, above which
+	// KalmanFilter rejects a location as an outlier. It's about 250 km/h.
+	DefaultKalmanMaxSpeed = 70.0
+	// DefaultKalmanAcceleration is the standard deviation of the
+	// acceleration, in m/s², assumed by KalmanFilter.
+	DefaultKalmanAcceleration = 1.0
+	// DefaultKalmanMaxRejections is the number of consecutive outliers after
+	// which KalmanFilter gives up its estimate and restarts from the latest
+	// location.
+	DefaultKalmanMaxRejections = 3
+)
+
+// LocationFilter is a processing stage for location updates, see
+// GeoClue2.SetFilter.
+type LocationFilter interface {
+	// Filter processes a location update. It returns false if the update
+	// should be dropped.
+	Filter(loc Location) (Location, bool)
+}
+
+// kalmanAxis is the state of a constant velocity model along one axis: the
+// position in meters relative to the origin of the filter, the velocity, and
+// their covariance.
+type kalmanAxis struct {
+	pos, vel      float64
+	pp, pv, vv    float64
+	initialized   bool
+	accelVariance float64
+}
+
+func (k *kalmanAxis) reset(pos, variance float64) {
+	k.pos = pos
+	k.vel = 0
+	k.pp = variance
+	k.pv = 0
+	// The velocity is unknown; start with a large variance.
+	k.vv = 1e4
+	k.initialized = true
+}
+
+func (k *kalmanAxis) predict(dt float64) {
+	if dt <= 0 {
+		return
+	}
+	k.pos += k.vel * dt
+	// P = F P F' + Q, with F = [1 dt; 0 1] and Q the covariance of a
+	// random acceleration.
+	pp := k.pp + 2*dt*k.pv + dt*dt*k.vv
+	pv := k.pv + dt*k.vv
+	q := k.accelVariance
+	k.pp = pp + q*dt*dt*dt*dt/4
+	k.pv = pv + q*dt*dt*dt/2
+	k.vv += q * dt * dt
+}
+
+func (k *kalmanAxis) update(pos, variance float64) {
+	s := k.pp + variance
+	kp := k.pp / s
+	kv := k.pv / s
+	y := pos - k.pos
+	k.pos += kp * y
+	k.vel += kv * y
+	pp := (1 - kp) * k.pp
+	pv := (1 - kp) * k.pv
+	k.vv -= kv * k.pv
+	k.pp = pp
+	k.pv = pv
+}
+
+// KalmanFilter is a LocationFilter smoothing jumpy location updates, e.g.
+// from WiFi or IP based sources, with a constant velocity Kalman filter. The
+// accuracy of each location is used as its measurement noise, so accurate
+// fixes pull the estimate harder than inaccurate ones.
+//
+// Locations implying a speed above MaxSpeed, after accounting for their
+// accuracy, are rejected as outliers; locations without a timestamp are never
+// rejected. After MaxRejections consecutive outliers, the filter assumes the
+// estimate is wrong and restarts from the latest location.
+//
+// The filtered location is a copy of the latest update, with the position
+// and accuracy replaced by the estimate. The fields have to be set before
+// the filter is used.
+type KalmanFilter struct {
+	// MaxSpeed is the speed in m/s above which locations are rejected.
+	// Outlier rejection is disabled if it's zero.
+	MaxSpeed float64
+	// Acceleration is the standard deviation of the acceleration in m/s².
+	Acceleration float64
+	// MaxRejections is the number of consecutive outliers after which the
+	// filter restarts.
+	MaxRejections int
+	lock          sync.Mutex
+	logger        Logger
+	origin        Location
+	x, y          kalmanAxis
+	last          Location
+	rejections    int
+}
+
+var _ LocationFilter = &KalmanFilter{}
+
+// NewKalmanFilter creates a Kalman filter with the default parameters.
+func NewKalmanFilter() *KalmanFilter {
+	return &KalmanFilter{
+		MaxSpeed:      DefaultKalmanMaxSpeed,
+		Acceleration:  DefaultKalmanAcceleration,
+		MaxRejections: DefaultKalmanMaxRejections,
+		logger:        DefaultLogger(),
+	}
+}
+
+// SetLogger sets the Logger of the filter. By default, it's the one set with
+// SetDefaultLogger. A nil Logger discards all messages.
+func (k *KalmanFilter) SetLogger(l Logger) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.logger = orNop(l)
+}
+
+// Reset discards the current estimate.
+func (k *KalmanFilter) Reset() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.x = kalmanAxis{}
+	k.y = kalmanAxis{}
+	k.rejections = 0
+}
+
+// project returns the position of loc in meters east and north of the
+// origin of the filter.
+func (k *KalmanFilter) project(loc Location) (float64, float64) {
+	x := radians(normalizeLongitude(loc.Longitude-k.origin.Longitude)) *
+		math.Cos(radians(k.origin.Latitude)) * earthRadius
+	y := radians(loc.Latitude-k.origin.Latitude) * earthRadius
+	return x, y
+}
+
+func (k *KalmanFilter) unproject(x, y float64) (float64, float64) {
+	lat := k.origin.Latitude + degrees(y/earthRadius)
+	lon := k.origin.Longitude + degrees(x/(earthRadius*math.Cos(radians(k.origin.Latitude))))
+	return lat, normalizeLongitude(lon)
+}
+
+// kalmanAccuracy returns the measurement standard deviation of loc. A location
+// without accuracy is trusted to a meter.
+func kalmanAccuracy(loc Location) float64 {
+	return math.Max(loc.Accuracy, 1)
+}
+
+func (k *KalmanFilter) restart(loc Location) {
+	k.origin = loc
+	variance := kalmanAccuracy(loc) * kalmanAccuracy(loc)
+	q := k.Acceleration * k.Acceleration
+	k.x = kalmanAxis{accelVariance: q}
+	k.y = kalmanAxis{accelVariance: q}
+	k.x.reset(0, variance)
+	k.y.reset(0, variance)
+	k.rejections = 0
+}
+
+// estimate returns the current estimate as a location based on loc.
+func (k *KalmanFilter) estimate(loc Location) Location {
+	loc.Latitude, loc.Longitude = k.unproject(k.x.pos, k.y.pos)
+	loc.Accuracy = math.Sqrt(math.Max(k.x.pp, k.y.pp))
+	return loc
+}
+
+// Filter feeds loc to the filter, and returns the new estimate. It returns
+// false if loc has been rejected as an outlier.
+func (k *KalmanFilter) Filter(loc Location) (Location, bool) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if !k.x.initialized {
+		k.restart(loc)
+		k.last = loc
+		return k.estimate(loc), true
+	}
+	dt := loc.Timestamp.Time().Sub(k.last.Timestamp.Time()).Seconds()
+	if loc.Timestamp.IsZero() || k.last.Timestamp.IsZero() || dt < 0 {
+		dt = 0
+	}
+	prev := k.estimate(k.last)
+	if k.MaxSpeed > 0 {
+		// The distance that can't be explained by the uncertainty of the
+		// estimate and the new location.
+		gap := prev.DistanceTo(loc) - prev.Accuracy - kalmanAccuracy(loc)
+		if gap > 0 && dt > 0 && gap/dt > k.MaxSpeed {
+			k.rejections++
+			if k.rejections <= k.MaxRejections {
+				orNop(k.logger).Debug("rejecting outlier", "filter", "kalman", "location", loc, "distance", gap)
+				return Location{}, false
+			}
+			orNop(k.logger).Debug("consecutive outliers, restarting", "filter", "kalman", "outliers", k.rejections)
+			k.restart(loc)
+			k.last = loc
+			return k.estimate(loc), true
+		}
+	}
+	k.rejections = 0
+	k.x.predict(dt)
+	k.y.predict(dt)
+	x, y := k.project(loc)
+	variance := kalmanAccuracy(loc) * kalmanAccuracy(loc)
+	k.x.update(x, variance)
+	k.y.update(y, variance)
+	k.last = loc
+	return k.estimate(loc), true
+}
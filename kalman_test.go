@@ -0,0 +1,86 @@
+package geoclue2
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKalmanFilterSmoothing(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	k := NewKalmanFilter()
+	truth := locationAt(47.4979, 19.0402)
+	rnd := rand.New(rand.NewSource(1))
+	var rawErr, filteredErr float64
+	for i := 0; i < 60; i++ {
+		// Stationary, with fixes scattered within their 50m accuracy.
+		loc := truth.DestinationPoint(rnd.Float64()*360, rnd.Float64()*50)
+		loc.Accuracy = 50
+		loc.Timestamp = FromTime(start.Add(time.Duration(i) * time.Second))
+		filtered, ok := k.Filter(loc)
+		assert.True(t, ok)
+		if i >= 30 {
+			rawErr += truth.DistanceTo(loc)
+			filteredErr += truth.DistanceTo(filtered)
+		}
+	}
+	assert.True(t, filteredErr < rawErr/2, "filtered %v raw %v", filteredErr, rawErr)
+}
+
+func TestKalmanFilterTracking(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	k := NewKalmanFilter()
+	origin := locationAt(0, 0)
+	var filtered Location
+	for i := 0; i < 30; i++ {
+		// Moving north at 10 m/s.
+		loc := origin.DestinationPoint(0, float64(i)*10)
+		loc.Accuracy = 5
+		loc.Timestamp = FromTime(start.Add(time.Duration(i) * time.Second))
+		var ok bool
+		filtered, ok = k.Filter(loc)
+		assert.True(t, ok)
+	}
+	assert.InDelta(t, 290, origin.DistanceTo(filtered), 5)
+	assert.True(t, filtered.Accuracy < 5)
+}
+
+func TestKalmanFilterOutliers(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	k := NewKalmanFilter()
+	k.MaxRejections = 2
+	home := locationAt(47.4979, 19.0402)
+	at := func(loc Location, acc float64, sec int) Location {
+		loc.Accuracy = acc
+		loc.Timestamp = FromTime(start.Add(time.Duration(sec) * time.Second))
+		return loc
+	}
+	_, ok := k.Filter(at(home, 20, 0))
+	assert.True(t, ok)
+	_, ok = k.Filter(at(home, 20, 10))
+	assert.True(t, ok)
+	// An IP based fix 30km away, 10s later.
+	far := home.DestinationPoint(90, 30000)
+	_, ok = k.Filter(at(far, 1000, 20))
+	assert.False(t, ok)
+	filtered, ok := k.Filter(at(home, 20, 30))
+	assert.True(t, ok)
+	assert.True(t, home.DistanceTo(filtered) < 20)
+	// Consistent fixes far away eventually restart the filter.
+	_, ok = k.Filter(at(far, 20, 40))
+	assert.False(t, ok)
+	_, ok = k.Filter(at(far, 20, 41))
+	assert.False(t, ok)
+	filtered, ok = k.Filter(at(far, 20, 42))
+	assert.True(t, ok)
+	assert.InDelta(t, 0, far.DistanceTo(filtered), 1e-6)
+	assert.Equal(t, 20.0, filtered.Accuracy)
+	// Locations without a timestamp are never rejected.
+	k.Reset()
+	_, ok = k.Filter(home)
+	assert.True(t, ok)
+	_, ok = k.Filter(far)
+	assert.True(t, ok)
+}
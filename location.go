@@ -0,0 +1,270 @@
+package geoclue2
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel values geoclue2 uses for unknown location properties.
+const (
+	// UnknownAltitude is the Altitude of a location without altitude.
+	UnknownAltitude = -math.MaxFloat64
+	// UnknownSpeed is the Speed of a location without speed.
+	UnknownSpeed = -1.0
+	// UnknownHeading is the Heading of a location without heading.
+	UnknownHeading = -1.0
+)
+
+// FromTime converts t to a Timestamp, truncating it to microseconds. Times
+// before 1970, which the unsigned seconds of a Timestamp can't represent, are
+// converted to the zero Timestamp, like the zero time.Time.
+func FromTime(t time.Time) Timestamp {
+	if t.IsZero() || t.Unix() < 0 {
+		return Timestamp{}
+	}
+	return Timestamp{
+		Seconds:      uint64(t.Unix()),
+		Microseconds: uint64(t.Nanosecond() / int(time.Microsecond)),
+	}
+}
+
+// Time converts the timestamp to a time.Time in the local time zone. The
+// zero Timestamp is converted to the zero time.Time.
+func (ts Timestamp) Time() time.Time {
+	if ts.IsZero() {
+		return time.Time{}
+	}
+	return time.Unix(int64(ts.Seconds), int64(ts.Microseconds)*int64(time.Microsecond))
+}
+
+// IsZero reports whether ts is unset.
+func (ts Timestamp) IsZero() bool {
+	return ts.Seconds == 0 && ts.Microseconds == 0
+}
+
+// String formats the timestamp in RFC 3339 format, in UTC.
+func (ts Timestamp) String() string {
+	if ts.IsZero() {
+		return ""
+	}
+	return ts.Time().UTC().Format(time.RFC3339Nano)
+}
+
+// MarshalJSON encodes the timestamp as an RFC 3339 string, or null if it's
+// unset.
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	if ts.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(ts.String())
+}
+
+// UnmarshalJSON decodes an RFC 3339 string or null. For compatibility, the
+// {"Seconds": ..., "Microseconds": ...} object form is accepted too.
+func (ts *Timestamp) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*ts = Timestamp{}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return err
+		}
+		*ts = FromTime(t)
+	case map[string]interface{}:
+		var raw struct {
+			Seconds      uint64
+			Microseconds uint64
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		*ts = Timestamp(raw)
+	default:
+		return fmt.Errorf("invalid timestamp %s", data)
+	}
+	return nil
+}
+
+// HasAltitude reports whether the altitude of the location is known.
+func (l Location) HasAltitude() bool {
+	return l.Altitude != UnknownAltitude && !math.IsNaN(l.Altitude)
+}
+
+// HasSpeed reports whether the speed of the location is known.
+func (l Location) HasSpeed() bool {
+	return l.Speed >= 0
+}
+
+// HasHeading reports whether the heading of the location is known.
+func (l Location) HasHeading() bool {
+	return l.Heading >= 0
+}
+
+// AltitudeMeters returns the altitude in meters, and whether it's known.
+func (l Location) AltitudeMeters() (float64, bool) {
+	return l.Altitude, l.HasAltitude()
+}
+
+// SpeedMetersPerSecond returns the speed in meters per second, and whether
+// it's known.
+func (l Location) SpeedMetersPerSecond() (float64, bool) {
+	return l.Speed, l.HasSpeed()
+}
+
+// HeadingDegrees returns the heading in degrees clockwise from North, and
+// whether it's known.
+func (l Location) HeadingDegrees() (float64, bool) {
+	return l.Heading, l.HasHeading()
+}
+
+// locationJSON is the JSON representation of Location, with null for unknown
+// values and an RFC 3339 timestamp.
+type locationJSON struct {
+	Latitude    float64   `json:"latitude"`
+	Longitude   float64   `json:"longitude"`
+	Accuracy    float64   `json:"accuracy"`
+	Altitude    *float64  `json:"altitude"`
+	Speed       *float64  `json:"speed"`
+	Heading     *float64  `json:"heading"`
+	Description string    `json:"description,omitempty"`
+	Timestamp   Timestamp `json:"timestamp"`
+	Source      string    `json:"source,omitempty"`
+	// Set for derived speed and heading.
+	SpeedDerived   bool `json:"speedDerived,omitempty"`
+	HeadingDerived bool `json:"headingDerived,omitempty"`
+}
+
+func optional(v float64, known bool) *float64 {
+	if !known {
+		return nil
+	}
+	return &v
+}
+
+// MarshalJSON encodes the location as a JSON object. Unknown altitude, speed
+// and heading are encoded as null, and the timestamp as an RFC 3339 string.
+func (l Location) MarshalJSON() ([]byte, error) {
+	return json.Marshal(locationJSON{
+		Latitude:       l.Latitude,
+		Longitude:      l.Longitude,
+		Accuracy:       l.Accuracy,
+		Altitude:       optional(l.AltitudeMeters()),
+		Speed:          optional(l.SpeedMetersPerSecond()),
+		Heading:        optional(l.HeadingDegrees()),
+		Description:    l.Description,
+		Timestamp:      l.Timestamp,
+		Source:         l.Source,
+		SpeedDerived:   l.SpeedDerived,
+		HeadingDerived: l.HeadingDerived,
+	})
+}
+
+// UnmarshalJSON decodes a location encoded by MarshalJSON. Missing or null
+// altitude, speed and heading are set to their unknown sentinel values.
+func (l *Location) UnmarshalJSON(data []byte) error {
+	var v locationJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*l = Location{
+		Latitude:       v.Latitude,
+		Longitude:      v.Longitude,
+		Accuracy:       v.Accuracy,
+		Altitude:       UnknownAltitude,
+		Speed:          UnknownSpeed,
+		Heading:        UnknownHeading,
+		Description:    v.Description,
+		Timestamp:      v.Timestamp,
+		Source:         v.Source,
+		SpeedDerived:   v.SpeedDerived,
+		HeadingDerived: v.HeadingDerived,
+	}
+	if v.Altitude != nil {
+		l.Altitude = *v.Altitude
+	}
+	if v.Speed != nil {
+		l.Speed = *v.Speed
+	}
+	if v.Heading != nil {
+		l.Heading = *v.Heading
+	}
+	return nil
+}
+
+// String formats the location for humans, e.g.
+// "47.497900,19.040200 ±25m alt 110.0m 1.2m/s 270°". Unknown values are
+// omitted.
+func (l Location) String() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%.6f,%.6f ±%gm", l.Latitude, l.Longitude, l.Accuracy)
+	if alt, ok := l.AltitudeMeters(); ok {
+		fmt.Fprintf(b, " alt %.1fm", alt)
+	}
+	if speed, ok := l.SpeedMetersPerSecond(); ok {
+		fmt.Fprintf(b, " %.1fm/s", speed)
+	}
+	if heading, ok := l.HeadingDegrees(); ok {
+		fmt.Fprintf(b, " %.0f°", heading)
+	}
+	if l.Description != "" {
+		fmt.Fprintf(b, " %q", l.Description)
+	}
+	return b.String()
+}
+
+// MarshalText encodes the location in the compact form "lat,lon ±accuracy",
+// e.g. "47.4979,19.0402 ±25", with the accuracy in meters.
+func (l Location) MarshalText() ([]byte, error) {
+	return []byte(formatFloat(l.Latitude) + "," + formatFloat(l.Longitude) +
+		" ±" + formatFloat(l.Accuracy)), nil
+}
+
+// UnmarshalText decodes the compact form produced by MarshalText. The
+// accuracy is optional, and may be followed by "m". Altitude, speed and
+// heading are set to unknown.
+func (l *Location) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	acc := 0.0
+	if i := strings.Index(s, "±"); i >= 0 {
+		var err error
+		accStr := strings.TrimSuffix(strings.TrimSpace(s[i+len("±"):]), "m")
+		acc, err = strconv.ParseFloat(accStr, 64)
+		if err != nil {
+			return fmt.Errorf("invalid accuracy in %q: %v", s, err)
+		}
+		s = strings.TrimSpace(s[:i])
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid location %q: expected lat,lon", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return fmt.Errorf("invalid latitude in %q: %v", s, err)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return fmt.Errorf("invalid longitude in %q: %v", s, err)
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return fmt.Errorf("location %q out of range", s)
+	}
+	*l = Location{
+		Latitude:  lat,
+		Longitude: lon,
+		Accuracy:  acc,
+		Altitude:  UnknownAltitude,
+		Speed:     UnknownSpeed,
+		Heading:   UnknownHeading,
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package geoclue2
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationOptionalFields(t *testing.T) {
+	loc := fix(1, 2, 3, time.Now())
+	assert.False(t, loc.HasAltitude())
+	assert.False(t, loc.HasSpeed())
+	assert.False(t, loc.HasHeading())
+	_, ok := loc.AltitudeMeters()
+	assert.False(t, ok)
+
+	loc.Altitude = 0
+	loc.Speed = 0
+	loc.Heading = 0
+	alt, ok := loc.AltitudeMeters()
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, alt)
+	speed, ok := loc.SpeedMetersPerSecond()
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, speed)
+	heading, ok := loc.HeadingDegrees()
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, heading)
+}
+
+func TestLocationJSON(t *testing.T) {
+	loc := Location{
+		Latitude:  47.5,
+		Longitude: 19.05,
+		Accuracy:  10,
+		Altitude:  UnknownAltitude,
+		Speed:     1.5,
+		Heading:   UnknownHeading,
+		// Flags are round-tripped too.
+		SpeedDerived: true,
+	}
+	data, err := json.Marshal(loc)
+	assert.NoError(t, err)
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, 47.5, m["latitude"])
+	assert.Nil(t, m["altitude"])
+	assert.Contains(t, m, "altitude")
+	assert.Equal(t, 1.5, m["speed"])
+	assert.Nil(t, m["heading"])
+	assert.NotContains(t, m, "description")
+	assert.Equal(t, true, m["speedDerived"])
+	assert.NotContains(t, m, "headingDerived")
+
+	var decoded Location
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, loc, decoded)
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"latitude":1,"longitude":2}`), &decoded))
+	assert.Equal(t, 1.0, decoded.Latitude)
+	assert.Equal(t, UnknownAltitude, decoded.Altitude)
+	assert.Equal(t, UnknownSpeed, decoded.Speed)
+	assert.Equal(t, UnknownHeading, decoded.Heading)
+}
+
+func TestLocationString(t *testing.T) {
+	loc := fix(47.4979, 19.0402, 25, time.Now())
+	assert.Equal(t, "47.497900,19.040200 ±25m", loc.String())
+	loc.Altitude = 110
+	loc.Speed = 1.23
+	loc.Heading = 270
+	loc.Description = "Budapest"
+	assert.Equal(t, `47.497900,19.040200 ±25m alt 110.0m 1.2m/s 270° "Budapest"`, loc.String())
+}
+
+func TestTimestampTime(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 26, 40, 123456789, time.UTC)
+	ts := FromTime(now)
+	assert.Equal(t, Timestamp{Seconds: 1600000000, Microseconds: 123456}, ts)
+	assert.True(t, ts.Time().Equal(now.Truncate(time.Microsecond)))
+	assert.Equal(t, "2020-09-13T12:26:40.123456Z", ts.String())
+	assert.True(t, Timestamp{}.IsZero())
+	assert.True(t, Timestamp{}.Time().IsZero())
+	assert.Equal(t, Timestamp{}, FromTime(time.Time{}))
+	assert.Equal(t, Timestamp{}, FromTime(time.Date(1969, 12, 31, 23, 59, 59, 500000000, time.UTC)))
+	assert.Equal(t, Timestamp{Microseconds: 1}, FromTime(time.Unix(0, 1000)))
+}
+
+func TestTimestampJSON(t *testing.T) {
+	loc := fix(1, 2, 3, time.Date(2020, 9, 13, 12, 26, 40, 500000000, time.UTC))
+	data, err := json.Marshal(loc)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"timestamp":"2020-09-13T12:26:40.5Z"`)
+	var decoded Location
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, loc.Timestamp, decoded.Timestamp)
+
+	data, err = json.Marshal(fix(1, 2, 3, time.Time{}))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"timestamp":null`)
+
+	var ts Timestamp
+	assert.NoError(t, json.Unmarshal([]byte(`{"Seconds":1600000000,"Microseconds":5}`), &ts))
+	assert.Equal(t, Timestamp{Seconds: 1600000000, Microseconds: 5}, ts)
+	assert.NoError(t, json.Unmarshal([]byte(`"2020-09-13T14:26:40+02:00"`), &ts))
+	assert.Equal(t, Timestamp{Seconds: 1600000000}, ts)
+	assert.Error(t, json.Unmarshal([]byte(`"yesterday"`), &ts))
+	assert.Error(t, json.Unmarshal([]byte(`42`), &ts))
+}
+
+func TestLocationText(t *testing.T) {
+	loc := fix(47.4979, 19.0402, 25, time.Now())
+	text, err := loc.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "47.4979,19.0402 ±25", string(text))
+
+	var decoded Location
+	assert.NoError(t, decoded.UnmarshalText(text))
+	assert.Equal(t, 47.4979, decoded.Latitude)
+	assert.Equal(t, 19.0402, decoded.Longitude)
+	assert.Equal(t, 25.0, decoded.Accuracy)
+	assert.False(t, decoded.HasAltitude())
+
+	assert.NoError(t, decoded.UnmarshalText([]byte(" -33.9, 151.2 ± 10m ")))
+	assert.Equal(t, -33.9, decoded.Latitude)
+	assert.Equal(t, 10.0, decoded.Accuracy)
+	assert.NoError(t, decoded.UnmarshalText([]byte("1,2")))
+	assert.Equal(t, 0.0, decoded.Accuracy)
+
+	for _, s := range []string{"", "1", "1,2,3", "a,2", "1,b", "1,2 ±x", "91,0", "0,181"} {
+		assert.Error(t, decoded.UnmarshalText([]byte(s)), "input %q", s)
+	}
+}
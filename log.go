@@ -0,0 +1,154 @@
+package geoclue2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message. The values are the ones of the
+// levels of log/slog.
+type Level int
+
+// Log levels.
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Logger receives the log messages of the library. keysAndValues are
+// alternating keys and values, structured fields of the message such as
+// "client", "/org/freedesktop/GeoClue2/Client/1". The methods are the ones of
+// a *slog.Logger, which can be used as is. LoggerFunc adapts other loggers.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// LoggerFunc adapts a function to the Logger interface. For example, to log
+// with a logr.Logger l:
+//
+//	geoclue2.LoggerFunc(func(level geoclue2.Level, msg string, kv ...interface{}) {
+//		switch {
+//		case level >= geoclue2.LevelError:
+//			l.Error(nil, msg, kv...)
+//		case level >= geoclue2.LevelInfo:
+//			l.Info(msg, kv...)
+//		default:
+//			l.V(1).Info(msg, kv...)
+//		}
+//	})
+type LoggerFunc func(level Level, msg string, keysAndValues ...interface{})
+
+func (f LoggerFunc) Debug(msg string, keysAndValues ...interface{}) {
+	f(LevelDebug, msg, keysAndValues...)
+}
+
+func (f LoggerFunc) Info(msg string, keysAndValues ...interface{}) {
+	f(LevelInfo, msg, keysAndValues...)
+}
+
+func (f LoggerFunc) Warn(msg string, keysAndValues ...interface{}) {
+	f(LevelWarn, msg, keysAndValues...)
+}
+
+func (f LoggerFunc) Error(msg string, keysAndValues ...interface{}) {
+	f(LevelError, msg, keysAndValues...)
+}
+
+// NewTextLogger returns a Logger writing the messages at level or above to w,
+// one per line, with the fields as key=value pairs:
+//
+//	2020/09/13 12:26:40 WARN getting client desktopID=org.example.App error="..."
+func NewTextLogger(w io.Writer, level Level) Logger {
+	var lock sync.Mutex
+	return LoggerFunc(func(l Level, msg string, keysAndValues ...interface{}) {
+		if l < level {
+			return
+		}
+		b := strings.Builder{}
+		b.WriteString(time.Now().Format("2006/01/02 15:04:05 "))
+		b.WriteString(l.String())
+		b.WriteByte(' ')
+		b.WriteString(msg)
+		for i := 0; i < len(keysAndValues); i += 2 {
+			// Like log/slog, a value without a key gets a placeholder.
+			key, value := "!BADKEY", keysAndValues[i]
+			if i+1 < len(keysAndValues) {
+				key, value = fmt.Sprint(keysAndValues[i]), keysAndValues[i+1]
+			}
+			b.WriteByte(' ')
+			b.WriteString(key)
+			b.WriteByte('=')
+			b.WriteString(quoteValue(fmt.Sprint(value)))
+		}
+		b.WriteByte('\n')
+		lock.Lock()
+		defer lock.Unlock()
+		io.WriteString(w, b.String())
+	})
+}
+
+// quoteValue quotes s if it would be ambiguous as the value of a field.
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var (
+	loggerLock    sync.RWMutex
+	defaultLogger = NewTextLogger(os.Stderr, LevelWarn)
+)
+
+// DiscardLogger is a Logger discarding all messages.
+var DiscardLogger Logger = LoggerFunc(func(Level, string, ...interface{}) {})
+
+// orNop returns l, or a Logger discarding all messages if l is nil.
+func orNop(l Logger) Logger {
+	if l == nil {
+		return DiscardLogger
+	}
+	return l
+}
+
+// SetDefaultLogger sets the Logger of everything in the library created
+// afterwards. Their SetLogger methods override it. The default writes
+// warnings and errors to stderr. A nil Logger discards all messages.
+func SetDefaultLogger(l Logger) {
+	l = orNop(l)
+	loggerLock.Lock()
+	defer loggerLock.Unlock()
+	defaultLogger = l
+}
+
+// DefaultLogger returns the Logger set with SetDefaultLogger, for packages
+// built on this one to use as the default of their own Loggers.
+func DefaultLogger() Logger {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+	return defaultLogger
+}
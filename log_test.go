@@ -0,0 +1,111 @@
+package geoclue2
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logEntry is a message received by a recordingLogger.
+type logEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger returns a Logger keeping the messages it receives.
+func recordingLogger() (Logger, func() []logEntry) {
+	var lock sync.Mutex
+	var entries []logEntry
+	l := LoggerFunc(func(level Level, msg string, keysAndValues ...interface{}) {
+		fields := make(map[string]interface{})
+		for i := 0; i+1 < len(keysAndValues); i += 2 {
+			fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		entries = append(entries, logEntry{level, msg, fields})
+	})
+	return l, func() []logEntry {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]logEntry(nil), entries...)
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	b := &bytes.Buffer{}
+	l := NewTextLogger(b, LevelInfo)
+	l.Debug("hidden")
+	l.Info("started client", "client", MockClientPath, "accuracy", 1.5)
+	l.Error("getting client", "error", fmt.Errorf("access denied"), "odd")
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], " INFO started client client=/org/freedesktop/GeoClue2/Client/1 accuracy=1.5"), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], ` ERROR getting client error="access denied" !BADKEY=odd`), lines[1])
+}
+
+func TestLevelString(t *testing.T) {
+	assert.Equal(t, "WARN", LevelWarn.String())
+	assert.Equal(t, "LEVEL(2)", Level(2).String())
+}
+
+func TestSetDefaultLogger(t *testing.T) {
+	old := DefaultLogger()
+	defer SetDefaultLogger(old)
+	l, entries := recordingLogger()
+	SetDefaultLogger(l)
+	DefaultLogger().Warn("test", "key", "value")
+	assert.Equal(t, []logEntry{{LevelWarn, "test", map[string]interface{}{"key": "value"}}}, entries())
+	SetDefaultLogger(nil)
+	DefaultLogger().Warn("discarded")
+	assert.Len(t, entries(), 1)
+}
+
+func TestGeoClue2Logger(t *testing.T) {
+	bus := NewMockBus()
+	gc := newGeoClue2(bus, "test")
+	l, entries := recordingLogger()
+	gc.SetLogger(l)
+	bus.Fail(managerPath, getClient, fmt.Errorf("testing logging"))
+	assert.Error(t, gc.getClient())
+	bus.Handle(managerPath, getClient, func(args ...interface{}) ([]interface{}, error) {
+		return []interface{}{MockClientPath}, nil
+	})
+	assert.NoError(t, gc.getClient())
+	got := entries()
+	assert.Len(t, got, 2)
+	assert.Equal(t, LevelWarn, got[0].level)
+	assert.Equal(t, "test", got[0].fields["desktopID"])
+	assert.EqualError(t, got[0].fields["error"].(error), "testing logging")
+	assert.Equal(t, LevelInfo, got[1].level)
+	assert.Equal(t, MockClientPath, got[1].fields["client"])
+	assert.Equal(t, "test", got[1].fields["desktopID"])
+}
+
+func TestProviderLogger(t *testing.T) {
+	p := NewStaticProvider("/nonexistent/geolocation")
+	l, entries := recordingLogger()
+	p.SetLogger(l)
+	p.Start()
+	defer p.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := entries()
+		if n := len(got); n > 0 && got[n-1].msg == "reading location" {
+			assert.Equal(t, LevelWarn, got[n-1].level)
+			assert.Equal(t, "starting up", got[0].msg)
+			assert.Equal(t, "static", got[0].fields["provider"])
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for log message, got %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
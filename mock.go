@@ -2,10 +2,18 @@ package geoclue2
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 
 	dbus "github.com/godbus/dbus/v5"
 )
 
+// MockBusObject is a dbus.BusObject whose methods are implemented by the Do
+// functions. Methods whose function is nil fall back to a default: calls
+// succeed with an empty body, and properties are read and written via
+// DoCall.
 type MockBusObject struct {
 	DoCall              func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call
 	DoCallWithContext   func(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call
@@ -20,54 +28,412 @@ type MockBusObject struct {
 }
 
 func (o *MockBusObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	if o.DoCall == nil {
+		if o.DoCallWithContext != nil {
+			return o.DoCallWithContext(context.Background(), method, flags, args...)
+		}
+		return &dbus.Call{Method: method, Args: args}
+	}
 	return o.DoCall(method, flags, args...)
 }
 
 func (o *MockBusObject) CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	if o.DoCallWithContext == nil {
+		return o.Call(method, flags, args...)
+	}
 	return o.DoCallWithContext(ctx, method, flags, args...)
 }
 
 func (o *MockBusObject) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	if o.DoGo == nil {
+		return o.GoWithContext(context.Background(), method, flags, ch, args...)
+	}
 	return o.DoGo(method, flags, ch, args...)
 }
 
 func (o *MockBusObject) GoWithContext(ctx context.Context, method string, flags dbus.Flags, ch chan *dbus.Call, args ...interface{}) *dbus.Call {
+	if o.DoGoWithContext == nil {
+		call := o.CallWithContext(ctx, method, flags, args...)
+		if ch == nil {
+			ch = make(chan *dbus.Call, 1)
+		}
+		call.Done = ch
+		ch <- call
+		return call
+	}
 	return o.DoGoWithContext(ctx, method, flags, ch, args...)
 }
 
 func (o *MockBusObject) AddMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	if o.DoAddMatchSignal == nil {
+		return &dbus.Call{}
+	}
 	return o.DoAddMatchSignal(iface, member, options...)
 }
 
 func (o *MockBusObject) RemoveMatchSignal(iface, member string, options ...dbus.MatchOption) *dbus.Call {
+	if o.DoRemoveMatchSignal == nil {
+		return &dbus.Call{}
+	}
 	return o.DoRemoveMatchSignal(iface, member, options...)
 }
 
 func (o *MockBusObject) GetProperty(p string) (dbus.Variant, error) {
+	if o.DoGetProperty == nil {
+		iface, name := splitProperty(p)
+		var v dbus.Variant
+		err := o.Call(getProperties, 0, iface, name).Store(&v)
+		return v, err
+	}
 	return o.DoGetProperty(p)
 }
 
 func (o *MockBusObject) SetProperty(p string, v interface{}) error {
+	if o.DoSetProperty == nil {
+		iface, name := splitProperty(p)
+		return o.Call(setProperties, 0, iface, name, dbus.MakeVariant(v)).Err
+	}
 	return o.DoSetProperty(p, v)
 }
 
 func (o *MockBusObject) Destination() string {
+	if o.DoDestination == nil {
+		return ""
+	}
 	return o.DoDestination()
 }
 
 func (o *MockBusObject) Path() dbus.ObjectPath {
+	if o.DoPath == nil {
+		return ""
+	}
 	return o.DoPath()
 }
 
+// splitProperty splits a property name of the form interface.name.
+func splitProperty(p string) (string, string) {
+	i := strings.LastIndex(p, ".")
+	if i < 0 {
+		return "", p
+	}
+	return p[:i], p[i+1:]
+}
+
+// MockDbusConn is a DbusConn whose methods are implemented by the Do
+// functions. If DoSignal is nil, signals are never delivered; if DoObject is
+// nil, objects are MockBusObjects with the default behavior.
 type MockDbusConn struct {
 	DoSignal func(ch chan<- *dbus.Signal)
 	DoObject func(iface string, path dbus.ObjectPath) dbus.BusObject
 }
 
 func (d *MockDbusConn) Signal(ch chan<- *dbus.Signal) {
+	if d.DoSignal == nil {
+		return
+	}
 	d.DoSignal(ch)
 }
 
 func (d *MockDbusConn) Object(iface string, path dbus.ObjectPath) dbus.BusObject {
+	if d.DoObject == nil {
+		return &MockBusObject{}
+	}
 	return d.DoObject(iface, path)
 }
+
+// Paths used by MockBus.
+const (
+	MockClientPath     = dbus.ObjectPath("/org/freedesktop/GeoClue2/Client/1")
+	mockLocationPrefix = "/org/freedesktop/GeoClue2/Location/"
+)
+
+// MockCall is a method call made on a MockBus.
+type MockCall struct {
+	// Path of the object. In expectations, an empty path matches any object.
+	Path dbus.ObjectPath
+	// Method with its interface, e.g. org.freedesktop.GeoClue2.Client.Start.
+	Method string
+	// Arguments of the call. In expectations, nil matches any arguments.
+	Args []interface{}
+}
+
+func (c MockCall) String() string {
+	return fmt.Sprintf("%s %s%v", c.Path, c.Method, c.Args)
+}
+
+func (c MockCall) matches(call MockCall) bool {
+	if c.Path != "" && c.Path != call.Path {
+		return false
+	}
+	if c.Method != call.Method {
+		return false
+	}
+	return c.Args == nil || reflect.DeepEqual(c.Args, call.Args)
+}
+
+type mockMethod struct {
+	path   dbus.ObjectPath
+	method string
+}
+
+// MockHandler implements a method of a MockBus. It returns the body of the
+// reply, or an error.
+type MockHandler func(args ...interface{}) ([]interface{}, error)
+
+// TestingT is the part of testing.T used by the assertions of MockBus.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// MockBus is a scriptable DbusConn for tests. It behaves like a minimal
+// geoclue2 service by default: the manager hands out MockClientPath, the
+// client becomes active when started, and properties are kept in a store,
+// read and written via org.freedesktop.DBus.Properties. Methods can be
+// overridden with Handle, calls are recorded for assertions, and signals
+// are emitted into the channels registered with Signal. It's safe for
+// concurrent use.
+type MockBus struct {
+	lock         sync.Mutex
+	handlers     map[mockMethod]MockHandler
+	props        map[dbus.ObjectPath]map[string]dbus.Variant
+	calls        []MockCall
+	signals      []chan<- *dbus.Signal
+	nextLocation int
+}
+
+// NewMockBus creates a MockBus with the default behavior.
+func NewMockBus() *MockBus {
+	b := &MockBus{
+		handlers: make(map[mockMethod]MockHandler),
+		props:    make(map[dbus.ObjectPath]map[string]dbus.Variant),
+	}
+	b.Handle(managerPath, getClient, func(args ...interface{}) ([]interface{}, error) {
+		return []interface{}{MockClientPath}, nil
+	})
+	b.Handle(MockClientPath, clientStart, func(args ...interface{}) ([]interface{}, error) {
+		b.SetProperty(MockClientPath, clientActive, true)
+		return nil, nil
+	})
+	b.Handle(MockClientPath, clientInterface+".Stop", func(args ...interface{}) ([]interface{}, error) {
+		b.SetProperty(MockClientPath, clientActive, false)
+		return nil, nil
+	})
+	b.SetProperty(MockClientPath, clientActive, false)
+	b.SetProperty(MockClientPath, clientLocation, dbus.ObjectPath("/"))
+	return b
+}
+
+// Handle sets the handler of method, given with its interface, on the object
+// at path. An empty path sets the handler for all objects without their own.
+func (b *MockBus) Handle(path dbus.ObjectPath, method string, h MockHandler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers[mockMethod{path, method}] = h
+}
+
+// Fail makes calls of method on the object at path fail with err.
+func (b *MockBus) Fail(path dbus.ObjectPath, method string, err error) {
+	b.Handle(path, method, func(args ...interface{}) ([]interface{}, error) {
+		return nil, err
+	})
+}
+
+// SetProperty sets a property, given with its interface, of the object at
+// path.
+func (b *MockBus) SetProperty(path dbus.ObjectPath, property string, value interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.setPropertyLocked(path, property, value)
+}
+
+func (b *MockBus) setPropertyLocked(path dbus.ObjectPath, property string, value interface{}) {
+	props, ok := b.props[path]
+	if !ok {
+		props = make(map[string]dbus.Variant)
+		b.props[path] = props
+	}
+	v, ok := value.(dbus.Variant)
+	if !ok {
+		v = dbus.MakeVariant(value)
+	}
+	props[property] = v
+}
+
+// Property returns a property, given with its interface, of the object at
+// path.
+func (b *MockBus) Property(path dbus.ObjectPath, property string) (interface{}, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	v, ok := b.props[path][property]
+	if !ok {
+		return nil, false
+	}
+	return v.Value(), true
+}
+
+// SetLocation stores loc in a new location object, and makes it the location
+// of the client. It returns the paths of the previous and the new location,
+// the arguments of the LocationUpdated signal.
+func (b *MockBus) SetLocation(loc Location) (dbus.ObjectPath, dbus.ObjectPath) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.nextLocation++
+	path := dbus.ObjectPath(fmt.Sprintf("%s%d", mockLocationPrefix, b.nextLocation))
+	v := reflect.ValueOf(loc)
+	for i := 0; i < v.NumField(); i++ {
+		name, ok := v.Type().Field(i).Tag.Lookup("dbus")
+		if !ok {
+			continue
+		}
+		b.setPropertyLocked(path, locationInterface+"."+name, v.Field(i).Interface())
+	}
+	old, _ := b.props[MockClientPath][clientLocation].Value().(dbus.ObjectPath)
+	b.setPropertyLocked(MockClientPath, clientLocation, path)
+	return old, path
+}
+
+// UpdateLocation sets loc with SetLocation, and emits LocationUpdated.
+func (b *MockBus) UpdateLocation(loc Location) {
+	old, path := b.SetLocation(loc)
+	b.Emit(MockClientPath, locationUpdated, old, path)
+}
+
+// Signal registers ch to receive the signals emitted with Emit.
+func (b *MockBus) Signal(ch chan<- *dbus.Signal) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.signals = append(b.signals, ch)
+}
+
+// Emit sends a signal, name given with its interface, from the object at
+// path to the registered channels. It blocks until all of them received it.
+func (b *MockBus) Emit(path dbus.ObjectPath, name string, body ...interface{}) {
+	b.lock.Lock()
+	signals := make([]chan<- *dbus.Signal, len(b.signals))
+	copy(signals, b.signals)
+	b.lock.Unlock()
+	for _, ch := range signals {
+		ch <- &dbus.Signal{
+			Path: path,
+			Name: name,
+			Body: body,
+		}
+	}
+}
+
+// Close closes the channels registered with Signal, like dbus.Conn.Close
+// does.
+func (b *MockBus) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, ch := range b.signals {
+		close(ch)
+	}
+	b.signals = nil
+}
+
+// Object returns the object at path.
+func (b *MockBus) Object(iface string, path dbus.ObjectPath) dbus.BusObject {
+	return &MockBusObject{
+		DoCall: func(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+			return b.call(iface, path, method, args)
+		},
+		DoDestination: func() string {
+			return iface
+		},
+		DoPath: func() dbus.ObjectPath {
+			return path
+		},
+	}
+}
+
+func (b *MockBus) call(dest string, path dbus.ObjectPath, method string, args []interface{}) *dbus.Call {
+	call := &dbus.Call{
+		Destination: dest,
+		Path:        path,
+		Method:      method,
+		Args:        args,
+	}
+	b.lock.Lock()
+	b.calls = append(b.calls, MockCall{Path: path, Method: method, Args: args})
+	h, ok := b.handlers[mockMethod{path, method}]
+	if !ok {
+		h, ok = b.handlers[mockMethod{"", method}]
+	}
+	if !ok {
+		call.Body, call.Err = b.defaultLocked(path, method, args)
+		b.lock.Unlock()
+		return call
+	}
+	b.lock.Unlock()
+	call.Body, call.Err = h(args...)
+	return call
+}
+
+// defaultLocked implements the properties interface with the property store.
+// Other methods succeed without doing anything.
+func (b *MockBus) defaultLocked(path dbus.ObjectPath, method string, args []interface{}) ([]interface{}, error) {
+	switch method {
+	case getProperties:
+		if len(args) != 2 {
+			return nil, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", nil)
+		}
+		property := fmt.Sprintf("%v.%v", args[0], args[1])
+		v, ok := b.props[path][property]
+		if !ok {
+			return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{property})
+		}
+		return []interface{}{v}, nil
+	case setProperties:
+		if len(args) != 3 {
+			return nil, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", nil)
+		}
+		b.setPropertyLocked(path, fmt.Sprintf("%v.%v", args[0], args[1]), args[2])
+		return nil, nil
+	}
+	return nil, nil
+}
+
+// Calls returns the calls made so far, in order.
+func (b *MockBus) Calls() []MockCall {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ret := make([]MockCall, len(b.calls))
+	copy(ret, b.calls)
+	return ret
+}
+
+// Reset forgets the calls made so far.
+func (b *MockBus) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls = nil
+}
+
+// AssertCalls checks that the expected calls were made in this order, with
+// any other calls in between.
+func (b *MockBus) AssertCalls(t TestingT, expected ...MockCall) bool {
+	calls := b.Calls()
+	i := 0
+	for _, call := range calls {
+		if i < len(expected) && expected[i].matches(call) {
+			i++
+		}
+	}
+	if i < len(expected) {
+		t.Errorf("expected call %v not made, calls: %v", expected[i], calls)
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled checks that no call matching c was made.
+func (b *MockBus) AssertNotCalled(t TestingT, c MockCall) bool {
+	for _, call := range b.Calls() {
+		if c.matches(call) {
+			t.Errorf("unexpected call %v", call)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package geoclue2
+
+import (
+	"fmt"
+	"testing"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMockBusObjectDefaults(t *testing.T) {
+	obj := &MockBusObject{}
+	assert.NoError(t, obj.Call("a.b.C", 0).Err)
+	call := <-obj.Go("a.b.C", 0, nil).Done
+	assert.NoError(t, call.Err)
+	conn := &MockDbusConn{}
+	conn.Signal(make(chan *dbus.Signal))
+	assert.NotNil(t, conn.Object("a.b", "/"))
+}
+
+func TestMockBus(t *testing.T) {
+	bus := NewMockBus()
+	client := bus.Object(geoClue2Interface, MockClientPath)
+	v, err := client.GetProperty(clientActive)
+	assert.NoError(t, err)
+	assert.Equal(t, false, v.Value())
+	assert.NoError(t, client.Call(clientStart, 0).Err)
+	v, err = client.GetProperty(clientActive)
+	assert.NoError(t, err)
+	assert.Equal(t, true, v.Value())
+	_, err = client.GetProperty(clientInterface + ".Missing")
+	assert.Error(t, err)
+
+	// Handlers without a path apply to all objects.
+	bus.Handle("", "a.b.C", func(args ...interface{}) ([]interface{}, error) {
+		return []interface{}{args[0]}, nil
+	})
+	var s string
+	assert.NoError(t, bus.Object("", "/x").Call("a.b.C", 0, "x").Store(&s))
+	assert.Equal(t, "x", s)
+
+	rt := &recordingT{}
+	assert.True(t, bus.AssertCalls(rt,
+		MockCall{Method: clientStart},
+		MockCall{Path: "/x", Method: "a.b.C", Args: []interface{}{"x"}},
+	))
+	assert.False(t, bus.AssertCalls(rt,
+		MockCall{Path: "/x", Method: "a.b.C"},
+		MockCall{Method: clientStart},
+	))
+	assert.False(t, bus.AssertNotCalled(rt, MockCall{Method: "a.b.C"}))
+	assert.Len(t, rt.errors, 2)
+	bus.Reset()
+	assert.Empty(t, bus.Calls())
+
+	ch := make(chan *dbus.Signal, 1)
+	bus.Signal(ch)
+	old, path := bus.SetLocation(Location{Latitude: 1})
+	assert.Equal(t, dbus.ObjectPath("/"), old)
+	bus.UpdateLocation(Location{Latitude: 2})
+	sig := <-ch
+	assert.Equal(t, locationUpdated, sig.Name)
+	assert.Equal(t, path, sig.Body[0])
+	lat, _ := bus.Property(sig.Body[1].(dbus.ObjectPath), locationInterface+".Latitude")
+	assert.Equal(t, 2.0, lat)
+}
@@ -0,0 +1,445 @@
+package geoclue2
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ldx/go-geoclue2/nmea"
+)
+
+const (
+	// User equivalent range error of a standalone GPS fix, in meters. The
+	// accuracy of a fix is estimated as HDOP * nmeaUERE.
+	nmeaUERE = 5.0
+	// Accuracy assumed when the receiver doesn't report HDOP, in meters.
+	nmeaDefaultAccuracy    = 50.0
+	knotsToMetersPerSecond = 1852.0 / 3600.0
+	kmhToMetersPerSecond   = 1000.0 / 3600.0
+	// How long to wait before reopening a serial device or TCP stream after
+	// it failed.
+	nmeaRetryInterval = 5 * time.Second
+)
+
+// Satellite is a satellite in view of an NMEA receiver.
+type Satellite struct {
+	nmea.Satellite
+	// Talker ID of the GSV sentence the satellite was reported in, e.g. "GP"
+	// for GPS or "GL" for GLONASS.
+	Talker string
+	// Used is true if the satellite was used for the current fix.
+	Used bool
+}
+
+// NMEAProvider is a Provider that reads NMEA 0183 sentences from a GPS
+// receiver or a recording, and turns them into location updates.
+type NMEAProvider struct {
+	*broadcaster
+	open       func() (io.ReadCloser, error)
+	reopen     bool
+	wg         sync.WaitGroup
+	quit       chan interface{}
+	lock       sync.Mutex
+	source     io.ReadCloser
+	fix        nmeaFix
+	date       nmea.Date
+	inView     map[string][]nmea.Satellite
+	pending    map[string][]nmea.Satellite
+	used       map[int]bool
+	satellites []Satellite
+	// Number of sentences of the current measurement merged so far, and of a
+	// full measurement of the receiver, once one has been seen. Fixes are
+	// flushed as soon as all the sentences of their measurement have arrived,
+	// so that VTG and GSA coming after GGA and RMC are included.
+	sentences int
+	cycle     int
+}
+
+// nmeaFix collects the data of a single measurement, which is spread across
+// several sentences.
+type nmeaFix struct {
+	time    nmea.Time
+	talker  string
+	haveGGA bool
+	haveRMC bool
+	// Set once the fix has been flushed, while the rest of the sentences
+	// of its measurement may still arrive.
+	flushed   bool
+	latitude  float64
+	longitude float64
+	altitude  float64
+	hdop      float64
+	speed     float64
+	course    float64
+}
+
+func newNMEAProvider(open func() (io.ReadCloser, error), reopen bool) *NMEAProvider {
+	return &NMEAProvider{
+		broadcaster: newBroadcaster("nmea"),
+		open:        open,
+		reopen:      reopen,
+		quit:        make(chan interface{}),
+		inView:      make(map[string][]nmea.Satellite),
+		pending:     make(map[string][]nmea.Satellite),
+		used:        make(map[int]bool),
+		fix:         newNMEAFix(nmea.Time{}, ""),
+	}
+}
+
+// NewNMEAProvider creates a provider that reads NMEA sentences from r until
+// it returns an error or io.EOF. If r is an io.Closer, it's closed when the
+// provider is stopped.
+func NewNMEAProvider(r io.Reader) *NMEAProvider {
+	return newNMEAProvider(func() (io.ReadCloser, error) {
+		if rc, ok := r.(io.ReadCloser); ok {
+			return rc, nil
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, nopCloser{}}, nil
+	}, false)
+}
+
+// NewNMEAFileProvider creates a provider that reads NMEA sentences from the
+// file at path, e.g. a recording of a receiver's output.
+func NewNMEAFileProvider(path string) *NMEAProvider {
+	return newNMEAProvider(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}, false)
+}
+
+// NewNMEASerialProvider creates a provider that reads NMEA sentences from a
+// serial device, e.g. /dev/ttyUSB0. If baud is not zero, the device is
+// switched to raw mode with the given baud rate. The device is reopened if
+// reading from it fails, e.g. because the receiver was unplugged.
+func NewNMEASerialProvider(path string, baud int) *NMEAProvider {
+	return newNMEAProvider(func() (io.ReadCloser, error) {
+		return openSerial(path, baud)
+	}, true)
+}
+
+// NewNMEATCPProvider creates a provider that reads NMEA sentences from a TCP
+// stream, e.g. gpsd in NMEA mode or a GPS app on a phone. The connection is
+// reestablished if it fails.
+func NewNMEATCPProvider(addr string) *NMEAProvider {
+	return newNMEAProvider(func() (io.ReadCloser, error) {
+		return net.Dial("tcp", addr)
+	}, true)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Start starts reading from the NMEA source.
+func (p *NMEAProvider) Start() {
+	p.logger.Info("starting up", "provider", "nmea")
+	p.broadcaster.start()
+	p.wg.Add(1)
+	go p.readLoop()
+}
+
+// Stop stops reading from the NMEA source and waits until the provider has
+// shut down.
+func (p *NMEAProvider) Stop() {
+	p.logger.Debug("stop requested", "provider", "nmea")
+	p.lock.Lock()
+	if p.stopping() {
+		p.lock.Unlock()
+		return
+	}
+	close(p.quit)
+	if p.source != nil {
+		p.source.Close()
+	}
+	p.lock.Unlock()
+	p.broadcaster.stop()
+	p.wg.Wait()
+}
+
+// Satellites returns the satellites currently in view of the receiver.
+func (p *NMEAProvider) Satellites() []Satellite {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ret := make([]Satellite, len(p.satellites))
+	copy(ret, p.satellites)
+	return ret
+}
+
+func (p *NMEAProvider) stopping() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *NMEAProvider) readLoop() {
+	defer p.wg.Done()
+	for !p.stopping() {
+		err := p.readSource()
+		if p.stopping() {
+			return
+		}
+		if err != nil {
+			p.logger.Warn("reading source", "provider", "nmea", "error", err)
+		}
+		if !p.reopen {
+			p.logger.Info("end of input", "provider", "nmea")
+			return
+		}
+		select {
+		case <-p.quit:
+			return
+		case <-time.After(nmeaRetryInterval):
+		}
+	}
+}
+
+func (p *NMEAProvider) readSource() error {
+	source, err := p.open()
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	if p.stopping() {
+		p.lock.Unlock()
+		source.Close()
+		return nil
+	}
+	p.source = source
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		p.source = nil
+		p.lock.Unlock()
+		source.Close()
+	}()
+	scanner := bufio.NewScanner(source)
+	for scanner.Scan() {
+		s, err := nmea.Parse(scanner.Text())
+		if err != nil {
+			if err != nmea.ErrUnsupported {
+				p.logger.Debug("skipping sentence", "provider", "nmea", "error", err)
+			}
+			continue
+		}
+		for _, loc := range p.processSentence(s) {
+			if !p.publish(loc) {
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, loc := range p.flush() {
+		p.publish(loc)
+	}
+	return nil
+}
+
+// processSentence merges s into the current fix. It returns the locations of
+// the fixes that have been completed by s.
+func (p *NMEAProvider) processSentence(s nmea.Sentence) []Location {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var locs []Location
+	switch s := s.(type) {
+	case *nmea.GGA:
+		if s.FixQuality <= 0 || math.IsNaN(s.Latitude) || math.IsNaN(s.Longitude) {
+			p.clearUsed()
+			p.fix = newNMEAFix(nmea.Time{}, "")
+			p.sentences = 0
+			return nil
+		}
+		locs = p.startFix(s.Time, s.Talker())
+		p.fix.haveGGA = true
+		p.fix.latitude = s.Latitude
+		p.fix.longitude = s.Longitude
+		p.fix.altitude = s.Altitude
+		if !math.IsNaN(s.HDOP) {
+			p.fix.hdop = s.HDOP
+		}
+	case *nmea.RMC:
+		if s.Date.Valid {
+			p.date = s.Date
+		}
+		if !s.Valid || math.IsNaN(s.Latitude) || math.IsNaN(s.Longitude) {
+			return nil
+		}
+		locs = p.startFix(s.Time, s.Talker())
+		p.fix.haveRMC = true
+		p.fix.latitude = s.Latitude
+		p.fix.longitude = s.Longitude
+		if !math.IsNaN(s.SpeedKnots) {
+			p.fix.speed = s.SpeedKnots * knotsToMetersPerSecond
+		}
+		if !math.IsNaN(s.Course) {
+			p.fix.course = s.Course
+		}
+	case *nmea.VTG:
+		if !math.IsNaN(s.SpeedKmh) {
+			p.fix.speed = s.SpeedKmh * kmhToMetersPerSecond
+		} else if !math.IsNaN(s.SpeedKnots) {
+			p.fix.speed = s.SpeedKnots * knotsToMetersPerSecond
+		}
+		if !math.IsNaN(s.TrueCourse) {
+			p.fix.course = s.TrueCourse
+		}
+	case *nmea.GSA:
+		if math.IsNaN(p.fix.hdop) && !math.IsNaN(s.HDOP) {
+			p.fix.hdop = s.HDOP
+		}
+		for _, id := range s.SatelliteIDs {
+			p.used[id] = true
+		}
+		p.updateSatellites()
+	case *nmea.GSV:
+		talker := s.Talker()
+		if s.MessageNumber <= 1 {
+			p.pending[talker] = nil
+		}
+		p.pending[talker] = append(p.pending[talker], s.Satellites...)
+		if s.MessageNumber >= s.TotalMessages {
+			p.inView[talker] = p.pending[talker]
+			delete(p.pending, talker)
+			p.updateSatellites()
+		}
+		return nil
+	}
+	// Once the number of sentences making up a measurement is known, don't
+	// wait for the next one to flush a complete fix.
+	p.sentences++
+	if p.cycle > 0 && p.sentences >= p.cycle {
+		locs = append(locs, p.flushLocked()...)
+	}
+	return locs
+}
+
+// startFix begins a new fix if t belongs to a different measurement than the
+// one being collected. The previous fix, if not flushed yet, is returned as a
+// location.
+func (p *NMEAProvider) startFix(t nmea.Time, talker string) []Location {
+	started := p.fix.haveGGA || p.fix.haveRMC || p.fix.flushed
+	if started && p.fix.time == t {
+		return nil
+	}
+	// A measurement may be missing sentences, e.g. if the stream started in
+	// the middle of it, so the longest one is taken as the full one.
+	if started && p.sentences > p.cycle {
+		p.cycle = p.sentences
+	}
+	locs := p.flushLocked()
+	p.fix = newNMEAFix(t, talker)
+	p.sentences = 0
+	p.clearUsed()
+	return locs
+}
+
+// clearUsed forgets the satellites used for the previous measurement.
+func (p *NMEAProvider) clearUsed() {
+	for id := range p.used {
+		delete(p.used, id)
+	}
+}
+
+// newNMEAFix returns an empty fix, with the optional fields unknown.
+func newNMEAFix(t nmea.Time, talker string) nmeaFix {
+	return nmeaFix{
+		time:     t,
+		talker:   talker,
+		altitude: math.NaN(),
+		hdop:     math.NaN(),
+		speed:    math.NaN(),
+		course:   math.NaN(),
+	}
+}
+
+func (p *NMEAProvider) flush() []Location {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.flushLocked()
+}
+
+func (p *NMEAProvider) flushLocked() []Location {
+	fix := p.fix
+	if !fix.haveGGA && !fix.haveRMC {
+		return nil
+	}
+	p.fix = newNMEAFix(fix.time, fix.talker)
+	p.fix.flushed = true
+	loc := Location{
+		Latitude:  fix.latitude,
+		Longitude: fix.longitude,
+		Accuracy:  nmeaDefaultAccuracy,
+		Altitude:  UnknownAltitude,
+		Speed:     UnknownSpeed,
+		Heading:   UnknownHeading,
+	}
+	if !math.IsNaN(fix.hdop) {
+		loc.Accuracy = fix.hdop * nmeaUERE
+	}
+	if !math.IsNaN(fix.altitude) {
+		loc.Altitude = fix.altitude
+	}
+	if !math.IsNaN(fix.speed) {
+		loc.Speed = fix.speed
+	}
+	if !math.IsNaN(fix.course) {
+		loc.Heading = fix.course
+	}
+	var t time.Time
+	if fix.time.Valid {
+		if p.date.Valid {
+			t = nmea.DateTime(p.date, fix.time)
+		} else {
+			t = nearestDateTime(fix.time, time.Now())
+		}
+	} else {
+		t = time.Now()
+	}
+	loc.Timestamp = FromTime(t)
+	return []Location{loc}
+}
+
+// nearestDateTime returns the time t of the day, among the day of now and the
+// ones before and after, that is closest to now. It's used for fixes without
+// a date, which may be from the day before or after around midnight.
+func nearestDateTime(t nmea.Time, now time.Time) time.Time {
+	now = now.UTC()
+	ret := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(t.Duration())
+	if d := ret.Sub(now); d > 12*time.Hour {
+		ret = ret.AddDate(0, 0, -1)
+	} else if d < -12*time.Hour {
+		ret = ret.AddDate(0, 0, 1)
+	}
+	return ret
+}
+
+func (p *NMEAProvider) updateSatellites() {
+	talkers := make([]string, 0, len(p.inView))
+	for talker := range p.inView {
+		talkers = append(talkers, talker)
+	}
+	sort.Strings(talkers)
+	p.satellites = p.satellites[:0]
+	for _, talker := range talkers {
+		for _, sat := range p.inView[talker] {
+			p.satellites = append(p.satellites, Satellite{
+				Satellite: sat,
+				Talker:    talker,
+				Used:      p.used[sat.PRN],
+			})
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package nmea
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format formats a GGA, RMC or VTG sentence, including the start delimiter
+// and the checksum, but not the line terminator. The talker ID defaults to
+// "GP" if it's not set in the header. Unknown values, i.e. NaN floats,
+// negative integers and invalid times or dates, are left empty. It returns
+// ErrUnsupported for other sentence types.
+func Format(s Sentence) (string, error) {
+	var typ string
+	var fields []string
+	switch v := s.(type) {
+	case *GGA:
+		typ, fields = "GGA", formatGGA(v)
+	case *RMC:
+		typ, fields = "RMC", formatRMC(v)
+	case *VTG:
+		typ, fields = "VTG", formatVTG(v)
+	default:
+		return "", ErrUnsupported
+	}
+	talker := s.Talker()
+	if talker == "" {
+		talker = "GP"
+	}
+	data := talker + typ + "," + strings.Join(fields, ",")
+	return fmt.Sprintf("$%s*%02X", data, Checksum(data)), nil
+}
+
+func formatFloat(v float64, decimals int) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+func formatInt(v, width int) string {
+	if v < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%0*d", width, v)
+}
+
+func formatTime(t Time) string {
+	if !t.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%02d%02d%02d.%02d", t.Hour, t.Minute, t.Second, t.Millisecond/10)
+}
+
+func formatDate(d Date) string {
+	if !d.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%02d%02d%02d", d.Day, d.Month, d.Year%100)
+}
+
+// formatCoordinate formats decimal degrees as (d)ddmm.mmmmm and a hemisphere,
+// the inverse of parser.coordinate. degWidth is 2 for latitudes and 3 for
+// longitudes.
+func formatCoordinate(v float64, degWidth int, pos, neg string) (string, string) {
+	if math.IsNaN(v) {
+		return "", ""
+	}
+	hemisphere := pos
+	if v < 0 {
+		hemisphere = neg
+		v = -v
+	}
+	// Round to 1e-5 minutes first, so the minutes never round up to 60.
+	total := math.Round(v * 60 * 1e5)
+	deg := math.Floor(total / (60 * 1e5))
+	min := (total - deg*60*1e5) / 1e5
+	return fmt.Sprintf("%0*d%08.5f", degWidth, int(deg), min), hemisphere
+}
+
+func formatGGA(g *GGA) []string {
+	lat, ns := formatCoordinate(g.Latitude, 2, "N", "S")
+	lon, ew := formatCoordinate(g.Longitude, 3, "E", "W")
+	altUnit, sepUnit := "M", "M"
+	if math.IsNaN(g.Altitude) {
+		altUnit = ""
+	}
+	if math.IsNaN(g.Separation) {
+		sepUnit = ""
+	}
+	return []string{
+		formatTime(g.Time),
+		lat, ns, lon, ew,
+		formatInt(g.FixQuality, 1),
+		formatInt(g.NumSatellites, 2),
+		formatFloat(g.HDOP, 1),
+		formatFloat(g.Altitude, 1), altUnit,
+		formatFloat(g.Separation, 1), sepUnit,
+		// Age of differential data and reference station ID.
+		"", "",
+	}
+}
+
+func formatRMC(r *RMC) []string {
+	lat, ns := formatCoordinate(r.Latitude, 2, "N", "S")
+	lon, ew := formatCoordinate(r.Longitude, 3, "E", "W")
+	status := "V"
+	if r.Valid {
+		status = "A"
+	}
+	variation, variationDir := formatFloat(math.Abs(r.MagneticVariation), 1), ""
+	if variation != "" {
+		variationDir = "E"
+		if r.MagneticVariation < 0 {
+			variationDir = "W"
+		}
+	}
+	fields := []string{
+		formatTime(r.Time),
+		status,
+		lat, ns, lon, ew,
+		formatFloat(r.SpeedKnots, 1),
+		formatFloat(r.Course, 1),
+		formatDate(r.Date),
+		variation, variationDir,
+	}
+	if r.Mode != "" {
+		fields = append(fields, r.Mode)
+	}
+	return fields
+}
+
+func formatVTG(v *VTG) []string {
+	fields := []string{
+		formatFloat(v.TrueCourse, 1), "T",
+		formatFloat(v.MagneticCourse, 1), "M",
+		formatFloat(v.SpeedKnots, 1), "N",
+		formatFloat(v.SpeedKmh, 1), "K",
+	}
+	if v.Mode != "" {
+		fields = append(fields, v.Mode)
+	}
+	return fields
+}
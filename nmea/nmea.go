@@ -0,0 +1,424 @@
+// Package nmea parses the NMEA 0183 sentences GPS receivers emit. Only the
+// sentences relevant for positioning are supported: GGA, RMC, GSA, VTG and
+// GSV.
+//
+// Numeric fields that are empty in the sentence are reported as NaN (for
+// floating point values) or -1 (for integers), so callers can tell "unknown"
+// apart from a measured zero.
+package nmea
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by Parse for well-formed sentences of a type
+// this package does not handle.
+var ErrUnsupported = errors.New("unsupported NMEA sentence")
+
+// Sentence is a parsed NMEA sentence.
+type Sentence interface {
+	// Talker returns the talker ID, e.g. "GP" for GPS or "GN" for
+	// multi-constellation receivers.
+	Talker() string
+	// Type returns the sentence type, e.g. "GGA".
+	Type() string
+}
+
+// Header holds the address field common to all sentences.
+type Header struct {
+	TalkerID     string
+	SentenceType string
+}
+
+// Talker returns the talker ID.
+func (h Header) Talker() string {
+	return h.TalkerID
+}
+
+// Type returns the sentence type.
+func (h Header) Type() string {
+	return h.SentenceType
+}
+
+// Time is a UTC time of day as reported by the receiver.
+type Time struct {
+	Valid       bool
+	Hour        int
+	Minute      int
+	Second      int
+	Millisecond int
+}
+
+// Duration returns t as an offset from midnight.
+func (t Time) Duration() time.Duration {
+	return time.Duration(t.Hour)*time.Hour +
+		time.Duration(t.Minute)*time.Minute +
+		time.Duration(t.Second)*time.Second +
+		time.Duration(t.Millisecond)*time.Millisecond
+}
+
+// Date is a UTC date as reported by the receiver.
+type Date struct {
+	Valid bool
+	Day   int
+	Month int
+	Year  int
+}
+
+// DateTime combines a date and a time of day into a UTC time.Time. The
+// result is the zero time if either is invalid.
+func DateTime(d Date, t Time) time.Time {
+	if !d.Valid || !t.Valid {
+		return time.Time{}
+	}
+	return time.Date(d.Year, time.Month(d.Month), d.Day,
+		t.Hour, t.Minute, t.Second, t.Millisecond*int(time.Millisecond), time.UTC)
+}
+
+// GGA is the "Global Positioning System Fix Data" sentence.
+type GGA struct {
+	Header
+	Time      Time
+	Latitude  float64
+	Longitude float64
+	// FixQuality is 0 when there is no fix, 1 for GPS, 2 for DGPS, etc.
+	FixQuality    int
+	NumSatellites int
+	HDOP          float64
+	// Altitude above mean sea level, in meters.
+	Altitude float64
+	// Separation between the geoid and the WGS84 ellipsoid, in meters.
+	Separation float64
+}
+
+// RMC is the "Recommended Minimum Specific GNSS Data" sentence.
+type RMC struct {
+	Header
+	Time Time
+	// Valid is true when the receiver flags the data as valid (status A).
+	Valid      bool
+	Latitude   float64
+	Longitude  float64
+	SpeedKnots float64
+	// Course over ground, in degrees from true North.
+	Course            float64
+	Date              Date
+	MagneticVariation float64
+	Mode              string
+}
+
+// GSA is the "GNSS DOP and Active Satellites" sentence.
+type GSA struct {
+	Header
+	// Mode is "A" for automatic or "M" for manual 2D/3D selection.
+	Mode string
+	// FixType is 1 when there is no fix, 2 for a 2D and 3 for a 3D fix.
+	FixType      int
+	SatelliteIDs []int
+	PDOP         float64
+	HDOP         float64
+	VDOP         float64
+}
+
+// VTG is the "Course Over Ground and Ground Speed" sentence.
+type VTG struct {
+	Header
+	TrueCourse     float64
+	MagneticCourse float64
+	SpeedKnots     float64
+	SpeedKmh       float64
+	Mode           string
+}
+
+// Satellite describes a satellite in view, as reported in GSV sentences.
+type Satellite struct {
+	// PRN is the satellite ID.
+	PRN int
+	// Elevation in degrees, or -1 if unknown.
+	Elevation int
+	// Azimuth in degrees from true North, or -1 if unknown.
+	Azimuth int
+	// SNR in dB-Hz, or -1 if the satellite is not being tracked.
+	SNR int
+}
+
+// GSV is the "GNSS Satellites in View" sentence. Receivers split the list of
+// satellites across several GSV sentences.
+type GSV struct {
+	Header
+	TotalMessages    int
+	MessageNumber    int
+	SatellitesInView int
+	Satellites       []Satellite
+}
+
+// Parse parses a single NMEA sentence, such as
+// "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47".
+// The checksum is verified if present.
+func Parse(line string) (Sentence, error) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || (line[0] != '$' && line[0] != '!') {
+		return nil, fmt.Errorf("invalid NMEA sentence %q: missing start delimiter", line)
+	}
+	data := line[1:]
+	if i := strings.IndexByte(data, '*'); i >= 0 {
+		sum := data[i+1:]
+		data = data[:i]
+		expected, err := strconv.ParseUint(sum, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NMEA checksum %q: %v", sum, err)
+		}
+		if actual := Checksum(data); uint8(expected) != actual {
+			return nil, fmt.Errorf("NMEA checksum mismatch: got %02X, expected %02X", actual, expected)
+		}
+	}
+	fields := strings.Split(data, ",")
+	if len(fields[0]) < 5 {
+		return nil, fmt.Errorf("invalid NMEA address field %q", fields[0])
+	}
+	addr := fields[0]
+	h := Header{
+		TalkerID:     addr[:len(addr)-3],
+		SentenceType: addr[len(addr)-3:],
+	}
+	p := &parser{fields: fields}
+	var s Sentence
+	switch h.SentenceType {
+	case "GGA":
+		s = p.gga(h)
+	case "RMC":
+		s = p.rmc(h)
+	case "GSA":
+		s = p.gsa(h)
+	case "VTG":
+		s = p.vtg(h)
+	case "GSV":
+		s = p.gsv(h)
+	default:
+		return nil, ErrUnsupported
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", addr, p.err)
+	}
+	return s, nil
+}
+
+// Checksum returns the NMEA checksum of data, which must not include the
+// leading '$' or the trailing '*'.
+func Checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum ^= data[i]
+	}
+	return sum
+}
+
+// parser extracts typed fields, remembering the first error encountered.
+type parser struct {
+	fields []string
+	err    error
+}
+
+func (p *parser) field(i int) string {
+	if i >= len(p.fields) {
+		return ""
+	}
+	return strings.TrimSpace(p.fields[i])
+}
+
+func (p *parser) fail(i int, err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("field %d (%q): %v", i, p.field(i), err)
+	}
+}
+
+func (p *parser) float(i int) float64 {
+	s := p.field(i)
+	if s == "" {
+		return math.NaN()
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.fail(i, err)
+		return math.NaN()
+	}
+	return v
+}
+
+func (p *parser) int(i int) int {
+	s := p.field(i)
+	if s == "" {
+		return -1
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		p.fail(i, err)
+		return -1
+	}
+	return v
+}
+
+// coordinate parses a (d)ddmm.mmmm value and its hemisphere in fields i and
+// i+1 into signed decimal degrees.
+func (p *parser) coordinate(i int) float64 {
+	v := p.float(i)
+	if math.IsNaN(v) {
+		return v
+	}
+	deg := math.Trunc(v / 100)
+	v = deg + (v-deg*100)/60
+	switch p.field(i + 1) {
+	case "N", "E":
+	case "S", "W":
+		v = -v
+	default:
+		p.fail(i+1, errors.New("invalid hemisphere"))
+	}
+	return v
+}
+
+func (p *parser) time(i int) Time {
+	s := p.field(i)
+	if s == "" {
+		return Time{}
+	}
+	if len(s) < 6 {
+		p.fail(i, errors.New("invalid time"))
+		return Time{}
+	}
+	t := Time{Valid: true}
+	var err error
+	if t.Hour, err = strconv.Atoi(s[0:2]); err != nil {
+		p.fail(i, err)
+	}
+	if t.Minute, err = strconv.Atoi(s[2:4]); err != nil {
+		p.fail(i, err)
+	}
+	sec, err := strconv.ParseFloat(s[4:], 64)
+	if err != nil {
+		p.fail(i, err)
+	}
+	t.Second = int(sec)
+	t.Millisecond = int(math.Round((sec - float64(t.Second)) * 1000))
+	return t
+}
+
+func (p *parser) date(i int) Date {
+	s := p.field(i)
+	if s == "" {
+		return Date{}
+	}
+	if len(s) != 6 {
+		p.fail(i, errors.New("invalid date"))
+		return Date{}
+	}
+	d := Date{Valid: true}
+	var err error
+	if d.Day, err = strconv.Atoi(s[0:2]); err != nil {
+		p.fail(i, err)
+	}
+	if d.Month, err = strconv.Atoi(s[2:4]); err != nil {
+		p.fail(i, err)
+	}
+	if d.Year, err = strconv.Atoi(s[4:6]); err != nil {
+		p.fail(i, err)
+	}
+	// Two digit years, as per the spec; receivers in the wild don't predate
+	// the 1980 GPS epoch.
+	if d.Year < 80 {
+		d.Year += 2000
+	} else {
+		d.Year += 1900
+	}
+	return d
+}
+
+func (p *parser) gga(h Header) *GGA {
+	return &GGA{
+		Header:        h,
+		Time:          p.time(1),
+		Latitude:      p.coordinate(2),
+		Longitude:     p.coordinate(4),
+		FixQuality:    p.int(6),
+		NumSatellites: p.int(7),
+		HDOP:          p.float(8),
+		Altitude:      p.float(9),
+		Separation:    p.float(11),
+	}
+}
+
+func (p *parser) rmc(h Header) *RMC {
+	rmc := &RMC{
+		Header:     h,
+		Time:       p.time(1),
+		Valid:      p.field(2) == "A",
+		Latitude:   p.coordinate(3),
+		Longitude:  p.coordinate(5),
+		SpeedKnots: p.float(7),
+		Course:     p.float(8),
+		Date:       p.date(9),
+		Mode:       p.field(12),
+	}
+	rmc.MagneticVariation = p.float(10)
+	if p.field(11) == "W" {
+		rmc.MagneticVariation = -rmc.MagneticVariation
+	}
+	return rmc
+}
+
+func (p *parser) gsa(h Header) *GSA {
+	gsa := &GSA{
+		Header:  h,
+		Mode:    p.field(1),
+		FixType: p.int(2),
+		PDOP:    p.float(15),
+		HDOP:    p.float(16),
+		VDOP:    p.float(17),
+	}
+	for i := 3; i < 15; i++ {
+		if p.field(i) == "" {
+			continue
+		}
+		gsa.SatelliteIDs = append(gsa.SatelliteIDs, p.int(i))
+	}
+	return gsa
+}
+
+func (p *parser) vtg(h Header) *VTG {
+	return &VTG{
+		Header:         h,
+		TrueCourse:     p.float(1),
+		MagneticCourse: p.float(3),
+		SpeedKnots:     p.float(5),
+		SpeedKmh:       p.float(7),
+		Mode:           p.field(9),
+	}
+}
+
+func (p *parser) gsv(h Header) *GSV {
+	gsv := &GSV{
+		Header:           h,
+		TotalMessages:    p.int(1),
+		MessageNumber:    p.int(2),
+		SatellitesInView: p.int(3),
+	}
+	// Up to four satellites per sentence, four fields each. NMEA 4.10 adds
+	// an optional signal ID as the last field, which is ignored here.
+	for i := 4; i+3 < len(p.fields); i += 4 {
+		if p.field(i) == "" {
+			continue
+		}
+		gsv.Satellites = append(gsv.Satellites, Satellite{
+			PRN:       p.int(i),
+			Elevation: p.int(i + 1),
+			Azimuth:   p.int(i + 2),
+			SNR:       p.int(i + 3),
+		})
+	}
+	return gsv
+}
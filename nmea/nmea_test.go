@@ -0,0 +1,159 @@
+package nmea
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGGA(t *testing.T) {
+	s, err := Parse("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47")
+	assert.NoError(t, err)
+	gga, ok := s.(*GGA)
+	assert.True(t, ok)
+	assert.Equal(t, "GP", gga.Talker())
+	assert.Equal(t, "GGA", gga.Type())
+	assert.Equal(t, Time{Valid: true, Hour: 12, Minute: 35, Second: 19}, gga.Time)
+	assert.InDelta(t, 48.1173, gga.Latitude, 1e-9)
+	assert.InDelta(t, 11.516667, gga.Longitude, 1e-6)
+	assert.Equal(t, 1, gga.FixQuality)
+	assert.Equal(t, 8, gga.NumSatellites)
+	assert.Equal(t, 0.9, gga.HDOP)
+	assert.Equal(t, 545.4, gga.Altitude)
+	assert.Equal(t, 46.9, gga.Separation)
+}
+
+func TestParseGGANoFix(t *testing.T) {
+	s, err := Parse("$GPGGA,,,,,,0,00,99.99,,,,,,*48")
+	assert.NoError(t, err)
+	gga := s.(*GGA)
+	assert.False(t, gga.Time.Valid)
+	assert.Equal(t, 0, gga.FixQuality)
+	assert.True(t, math.IsNaN(gga.Latitude))
+	assert.True(t, math.IsNaN(gga.Longitude))
+	assert.True(t, math.IsNaN(gga.Altitude))
+}
+
+func TestParseRMC(t *testing.T) {
+	s, err := Parse("$GPRMC,123519,A,4807.038,N,01131.000,W,022.4,084.4,230394,003.1,W*78")
+	assert.NoError(t, err)
+	rmc := s.(*RMC)
+	assert.True(t, rmc.Valid)
+	assert.InDelta(t, 48.1173, rmc.Latitude, 1e-9)
+	assert.InDelta(t, -11.516667, rmc.Longitude, 1e-6)
+	assert.Equal(t, 22.4, rmc.SpeedKnots)
+	assert.Equal(t, 84.4, rmc.Course)
+	assert.Equal(t, Date{Valid: true, Day: 23, Month: 3, Year: 1994}, rmc.Date)
+	assert.Equal(t, -3.1, rmc.MagneticVariation)
+	assert.Equal(t,
+		time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC),
+		DateTime(rmc.Date, rmc.Time))
+}
+
+func TestParseGSA(t *testing.T) {
+	s, err := Parse("$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39")
+	assert.NoError(t, err)
+	gsa := s.(*GSA)
+	assert.Equal(t, "A", gsa.Mode)
+	assert.Equal(t, 3, gsa.FixType)
+	assert.Equal(t, []int{4, 5, 9, 12, 24}, gsa.SatelliteIDs)
+	assert.Equal(t, 2.5, gsa.PDOP)
+	assert.Equal(t, 1.3, gsa.HDOP)
+	assert.Equal(t, 2.1, gsa.VDOP)
+}
+
+func TestParseVTG(t *testing.T) {
+	s, err := Parse("$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K*48")
+	assert.NoError(t, err)
+	vtg := s.(*VTG)
+	assert.Equal(t, 54.7, vtg.TrueCourse)
+	assert.Equal(t, 34.4, vtg.MagneticCourse)
+	assert.Equal(t, 5.5, vtg.SpeedKnots)
+	assert.Equal(t, 10.2, vtg.SpeedKmh)
+}
+
+func TestParseGSV(t *testing.T) {
+	s, err := Parse("$GPGSV,2,1,08,01,40,083,46,02,17,308,,12,07,344,39,14,22,228,45*70")
+	assert.NoError(t, err)
+	gsv := s.(*GSV)
+	assert.Equal(t, 2, gsv.TotalMessages)
+	assert.Equal(t, 1, gsv.MessageNumber)
+	assert.Equal(t, 8, gsv.SatellitesInView)
+	assert.Equal(t, []Satellite{
+		{PRN: 1, Elevation: 40, Azimuth: 83, SNR: 46},
+		{PRN: 2, Elevation: 17, Azimuth: 308, SNR: -1},
+		{PRN: 12, Elevation: 7, Azimuth: 344, SNR: 39},
+		{PRN: 14, Elevation: 22, Azimuth: 228, SNR: 45},
+	}, gsv.Satellites)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse("GPGGA,123519")
+	assert.Error(t, err)
+	_, err = Parse("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48")
+	assert.Error(t, err)
+	_, err = Parse("$GPGGA,123519,4807.038,X,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	assert.Error(t, err)
+	_, err = Parse("$GPZDA,201530.00,04,07,2002,00,00*60")
+	assert.Equal(t, ErrUnsupported, err)
+}
+
+func TestChecksum(t *testing.T) {
+	assert.Equal(t, uint8(0x47), Checksum("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"))
+}
+
+func TestFormat(t *testing.T) {
+	for _, line := range []string{
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		"$GPGGA,,,,,,0,00,99.99,,,,,,*48",
+		"$GPRMC,123519,A,4807.038,N,01131.000,W,022.4,084.4,230394,003.1,W*78",
+		"$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K*48",
+	} {
+		s, err := Parse(line)
+		assert.NoError(t, err)
+		formatted, err := Format(s)
+		assert.NoError(t, err)
+		parsed, err := Parse(formatted)
+		assert.NoError(t, err, formatted)
+		assert.Equal(t, s.Type(), parsed.Type())
+		switch v := s.(type) {
+		case *GGA:
+			p := parsed.(*GGA)
+			assert.Equal(t, v.Time, p.Time)
+			assert.Equal(t, v.FixQuality, p.FixQuality)
+			assert.Equal(t, math.IsNaN(v.Latitude), math.IsNaN(p.Latitude))
+			if !math.IsNaN(v.Latitude) {
+				assert.InDelta(t, v.Latitude, p.Latitude, 1e-7)
+				assert.InDelta(t, v.Longitude, p.Longitude, 1e-7)
+				assert.Equal(t, v.Altitude, p.Altitude)
+			}
+		case *RMC:
+			assert.Equal(t, v, parsed.(*RMC))
+		case *VTG:
+			assert.Equal(t, v, parsed.(*VTG))
+		}
+	}
+
+	s, err := Format(&GGA{
+		Time:          Time{Valid: true, Hour: 9, Minute: 5, Second: 3, Millisecond: 250},
+		Latitude:      -33.9,
+		Longitude:     151.2,
+		FixQuality:    1,
+		NumSatellites: -1,
+		HDOP:          1.2,
+		Altitude:      math.NaN(),
+		Separation:    math.NaN(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "$GPGGA,090503.25,3354.00000,S,15112.00000,E,1,,1.2,,,,,,*4D", s)
+
+	// Minutes are rounded without overflowing to 60.
+	lat, ns := formatCoordinate(47.9999999999, 2, "N", "S")
+	assert.Equal(t, "4800.00000", lat)
+	assert.Equal(t, "N", ns)
+
+	_, err = Format(&GSA{})
+	assert.Equal(t, ErrUnsupported, err)
+}
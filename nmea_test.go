@@ -0,0 +1,161 @@
+package geoclue2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ldx/go-geoclue2/nmea"
+	"github.com/stretchr/testify/assert"
+)
+
+func sentence(data string) string {
+	return fmt.Sprintf("$%s*%02X\r\n", data, nmea.Checksum(data))
+}
+
+func TestNMEAProvider(t *testing.T) {
+	input := strings.Join([]string{
+		sentence("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"),
+		sentence("GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1"),
+		sentence("GPGSV,2,1,06,04,40,083,46,05,17,308,,09,07,344,39,12,22,228,45"),
+		sentence("GPGSV,2,2,06,24,10,010,30,30,05,100,"),
+		sentence("GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W"),
+		sentence("GPGGA,123520,4807.100,N,01131.100,E,1,08,,,,,,,"),
+		"garbage\r\n",
+	}, "")
+	p := NewNMEAProvider(strings.NewReader(input))
+	ch := make(chan Location, 10)
+	// Subscribed before Start, so no update is missed.
+	p.Subscribe(ch)
+	p.Start()
+
+	loc := <-ch
+	assert.InDelta(t, 48.1173, loc.Latitude, 1e-9)
+	assert.InDelta(t, 11.516667, loc.Longitude, 1e-6)
+	assert.InDelta(t, 4.5, loc.Accuracy, 1e-9)
+	assert.Equal(t, 545.4, loc.Altitude)
+	assert.InDelta(t, 11.523, loc.Speed, 1e-3)
+	assert.Equal(t, 84.4, loc.Heading)
+	assert.Equal(t, Timestamp{
+		Seconds: uint64(time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC).Unix()),
+	}, loc.Timestamp)
+
+	loc = <-ch
+	assert.InDelta(t, 48.118333, loc.Latitude, 1e-6)
+	assert.Equal(t, nmeaDefaultAccuracy, loc.Accuracy)
+	assert.Equal(t, -math.MaxFloat64, loc.Altitude)
+	assert.Equal(t, -1.0, loc.Speed)
+	assert.Equal(t, -1.0, loc.Heading)
+
+	sats := p.Satellites()
+	assert.Len(t, sats, 6)
+	used := 0
+	for _, sat := range sats {
+		if sat.Used {
+			used++
+		}
+		assert.Equal(t, "GP", sat.Talker)
+	}
+	assert.Equal(t, 5, used)
+	assert.Equal(t, 30, sats[5].PRN)
+	assert.Equal(t, -1, sats[5].SNR)
+
+	p.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestNMEAProviderWaitForLocation(t *testing.T) {
+	r, w := io.Pipe()
+	p := NewNMEAProvider(r)
+	p.Start()
+	go func() {
+		for i := 0; ; i++ {
+			line := sentence(fmt.Sprintf("GNRMC,1235%02d,A,4807.038,S,01131.000,W,,,230394,,", i%60))
+			if _, err := io.WriteString(w, line); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	loc, err := p.WaitForLocation(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, loc)
+	assert.InDelta(t, -48.1173, loc.Latitude, 1e-9)
+	assert.InDelta(t, -11.516667, loc.Longitude, 1e-6)
+	p.Stop()
+	_, err = p.WaitForLocation(ctx)
+	assert.Error(t, err)
+	// Stopping again does nothing.
+	p.Stop()
+}
+
+func TestNMEAProviderFlushResetsFix(t *testing.T) {
+	p := NewNMEAProvider(strings.NewReader(""))
+	process := func(data string) []Location {
+		s, err := nmea.Parse(strings.TrimSpace(sentence(data)))
+		assert.NoError(t, err)
+		return p.processSentence(s)
+	}
+	process("GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	process("GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W")
+	assert.Len(t, process("GPGGA,123520,4807.100,N,01131.100,E,1,08,0.9,545.4,M,46.9,M,,"), 1)
+	// The sentence set is known now, so the fix is flushed when complete.
+	assert.Len(t, process("GPRMC,123520,A,4807.100,N,01131.100,E,022.4,084.4,230394,003.1,W"), 1)
+	assert.True(t, math.IsNaN(p.fix.hdop))
+	assert.True(t, math.IsNaN(p.fix.speed))
+	assert.True(t, math.IsNaN(p.fix.course))
+	process("GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1")
+	assert.Equal(t, 1.3, p.fix.hdop)
+}
+
+func TestNMEAProviderMeasurementCycle(t *testing.T) {
+	p := NewNMEAProvider(strings.NewReader(""))
+	process := func(data string) []Location {
+		s, err := nmea.Parse(strings.TrimSpace(sentence(data)))
+		assert.NoError(t, err)
+		return p.processSentence(s)
+	}
+	measurement := func(time, used string) []Location {
+		var locs []Location
+		for _, data := range []string{
+			"GPRMC," + time + ",A,4807.038,N,01131.000,E,,,230394,003.1,W",
+			"GPGGA," + time + ",4807.038,N,01131.000,E,1,08,,545.4,M,46.9,M,,",
+			"GPGSA,A,3," + used + ",,,,,,,,,,,,2.5,1.3,2.1",
+			"GPVTG,054.7,T,034.4,M,005.5,N,010.2,K",
+		} {
+			locs = append(locs, process(data)...)
+		}
+		return locs
+	}
+	assert.Empty(t, measurement("123519", "04"))
+	// The first measurement is flushed when the next one starts. Since it's
+	// known to be complete then, the next ones are flushed after their last
+	// sentence, with the data of VTG and GSA.
+	for i, used := range []string{"05", "09"} {
+		locs := measurement(fmt.Sprintf("12352%d", i), used)
+		assert.Len(t, locs, 2-i)
+		assert.Equal(t, 1.3*nmeaUERE, locs[len(locs)-1].Accuracy)
+		assert.InDelta(t, 10.2/3.6, locs[len(locs)-1].Speed, 1e-9)
+		assert.Equal(t, 54.7, locs[len(locs)-1].Heading)
+		// Only the satellites of the current measurement are used.
+		assert.Len(t, p.used, 1)
+	}
+}
+
+func TestNearestDateTime(t *testing.T) {
+	now := time.Date(2020, 9, 14, 0, 0, 10, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 9, 13, 23, 59, 50, 0, time.UTC),
+		nearestDateTime(nmea.Time{Valid: true, Hour: 23, Minute: 59, Second: 50}, now))
+	assert.Equal(t, time.Date(2020, 9, 14, 0, 0, 5, 0, time.UTC),
+		nearestDateTime(nmea.Time{Valid: true, Second: 5}, now))
+	now = time.Date(2020, 9, 13, 23, 59, 50, 0, time.UTC)
+	assert.Equal(t, time.Date(2020, 9, 14, 0, 0, 5, 0, time.UTC),
+		nearestDateTime(nmea.Time{Valid: true, Second: 5}, now))
+}
@@ -0,0 +1,350 @@
+package geoclue2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Provider is a source of location updates. GeoClue2 is the canonical
+// implementation; other providers in this package offer the same
+// subscription API on top of different location sources.
+type Provider interface {
+	// Start starts receiving and distributing location updates.
+	Start()
+	// Stop stops the provider and waits until it has shut down.
+	Stop()
+	// GetLatestLocation returns the last location received, or nil.
+	GetLatestLocation() *Location
+	// WaitForLocation waits for the next location update.
+	WaitForLocation(ctx context.Context) (*Location, error)
+	// Subscribe registers ch to receive location updates. Updates are
+	// delivered without blocking, so ch should be buffered if the receiver
+	// can't keep up. Subscribing before Start is allowed, and needed to get
+	// the updates published as soon as the provider starts. The channel is
+	// closed when the provider shuts down.
+	Subscribe(ch chan Location)
+	// SubscribeFiltered is like Subscribe, but only delivers the updates
+	// that pass filter.
+	SubscribeFiltered(ch chan Location, filter SubscriptionFilter)
+	// Unsubscribe stops delivering location updates to ch.
+	Unsubscribe(ch chan Location)
+}
+
+// SubscriptionFilter limits the location updates delivered to a subscriber.
+// An update is delivered if all of these hold, compared to the last update
+// delivered to the same subscriber:
+//
+//   - it's not identical, if Dedupe is set,
+//   - at least MinInterval has passed,
+//   - it has moved at least MinDistance, or its accuracy has improved by at
+//     least MinAccuracyImprovement.
+//
+// If neither MinDistance nor MinAccuracyImprovement is set, the last
+// condition always holds. The first update is always delivered.
+type SubscriptionFilter struct {
+	// MinDistance is the distance in meters.
+	MinDistance float64
+	// MinInterval is the minimum time between updates.
+	MinInterval time.Duration
+	// MinAccuracyImprovement is the decrease of Accuracy in meters.
+	MinAccuracyImprovement float64
+	// Dedupe drops updates identical to the last one, apart from the
+	// timestamp.
+	Dedupe bool
+}
+
+// subscriberState is the filter of a subscriber, and the last update it got.
+type subscriberState struct {
+	filter SubscriptionFilter
+	last   *Location
+	sent   time.Time
+}
+
+func newSubscriberState(filter SubscriptionFilter) *subscriberState {
+	return &subscriberState{filter: filter}
+}
+
+// identical reports whether a and b describe the same fix, ignoring their
+// timestamps.
+func identical(a, b Location) bool {
+	a.Timestamp = Timestamp{}
+	b.Timestamp = Timestamp{}
+	return a == b
+}
+
+func (s *subscriberState) accept(loc Location, now time.Time) bool {
+	if s.last == nil {
+		return true
+	}
+	f := s.filter
+	if f.Dedupe && identical(*s.last, loc) {
+		return false
+	}
+	if f.MinInterval > 0 && now.Sub(s.sent) < f.MinInterval {
+		return false
+	}
+	if f.MinDistance <= 0 && f.MinAccuracyImprovement <= 0 {
+		return true
+	}
+	if f.MinDistance > 0 && s.last.DistanceTo(loc) >= f.MinDistance {
+		return true
+	}
+	if f.MinAccuracyImprovement > 0 && s.last.Accuracy-loc.Accuracy >= f.MinAccuracyImprovement {
+		return true
+	}
+	return false
+}
+
+// subscriber is a subscription request, with an optional filter.
+type subscriber struct {
+	ch    chan Location
+	state *subscriberState
+}
+
+// deliver sends loc to the subscribers whose filters accept it, without
+// blocking. A nil state means the subscriber gets every update.
+func deliver(subscribers map[chan<- Location]*subscriberState, loc Location) {
+	now := time.Now()
+	for ch, state := range subscribers {
+		if state != nil && !state.accept(loc, now) {
+			continue
+		}
+		select {
+		case ch <- loc:
+			if state != nil {
+				l := loc
+				state.last = &l
+				state.sent = now
+			}
+		default:
+		}
+	}
+}
+
+var _ Provider = &GeoClue2{}
+
+// broadcaster implements the subscription side of Provider. Providers embed
+// it and feed it via publish().
+type broadcaster struct {
+	name           string
+	wg             sync.WaitGroup
+	quit           chan interface{}
+	done           chan interface{}
+	updates        chan Location
+	subscribe      chan subscriber
+	unsubscribe    chan chan Location
+	lock           sync.Mutex
+	logger         Logger
+	latestLocation *Location
+	started        bool
+	// Subscribers registered before the loop was started.
+	early map[chan Location]*subscriberState
+}
+
+func newBroadcaster(name string) *broadcaster {
+	return &broadcaster{
+		name:        name,
+		logger:      DefaultLogger(),
+		quit:        make(chan interface{}),
+		done:        make(chan interface{}),
+		updates:     make(chan Location),
+		subscribe:   make(chan subscriber),
+		unsubscribe: make(chan chan Location),
+		early:       make(map[chan Location]*subscriberState),
+	}
+}
+
+// SetLogger sets the Logger of the provider. By default, it's the one set
+// with SetDefaultLogger. A nil Logger discards all messages. It has to be
+// called before Start.
+func (b *broadcaster) SetLogger(l Logger) {
+	b.logger = orNop(l)
+}
+
+func (b *broadcaster) start() {
+	b.lock.Lock()
+	b.started = true
+	early := b.early
+	b.early = nil
+	b.lock.Unlock()
+	b.wg.Add(1)
+	go b.loop(early)
+}
+
+func (b *broadcaster) stop() {
+	b.lock.Lock()
+	started := b.started
+	b.lock.Unlock()
+	if !started {
+		return
+	}
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	b.quit <- struct{}{}
+	b.wg.Wait()
+}
+
+// publish hands loc over to the broadcast loop. It returns false if the loop
+// has already shut down.
+func (b *broadcaster) publish(loc Location) bool {
+	b.lock.Lock()
+	b.latestLocation = &loc
+	b.lock.Unlock()
+	select {
+	case b.updates <- loc:
+		return true
+	case <-b.done:
+		return false
+	}
+}
+
+func (b *broadcaster) loop(early map[chan Location]*subscriberState) {
+	defer b.wg.Done()
+	defer close(b.done)
+	subscribers := make(map[chan<- Location]*subscriberState)
+	for ch, state := range early {
+		subscribers[ch] = state
+	}
+	for {
+		select {
+		case subscribe := <-b.subscribe:
+			b.logger.Debug("new subscriber", "provider", b.name, "subscriber", subscribe.ch)
+			subscribers[subscribe.ch] = subscribe.state
+		case unsubscribe := <-b.unsubscribe:
+			b.logger.Debug("subscriber gone", "provider", b.name, "subscriber", unsubscribe)
+			delete(subscribers, unsubscribe)
+		case loc := <-b.updates:
+			b.logger.Debug("broadcasting location update", "provider", b.name, "subscribers", len(subscribers))
+			deliver(subscribers, loc)
+		case <-b.quit:
+			b.logger.Info("shutting down", "provider", b.name)
+			for sub := range subscribers {
+				close(sub)
+			}
+			return
+		}
+	}
+}
+
+// GetLatestLocation returns the last location published by the provider.
+func (b *broadcaster) GetLatestLocation() *Location {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.latestLocation
+}
+
+// Subscribe registers ch to receive location updates. Subscribing before
+// the provider is started is allowed, so no update is missed.
+func (b *broadcaster) Subscribe(ch chan Location) {
+	b.add(subscriber{ch: ch})
+}
+
+// SubscribeFiltered registers ch to receive the location updates that pass
+// filter.
+func (b *broadcaster) SubscribeFiltered(ch chan Location, filter SubscriptionFilter) {
+	b.add(subscriber{ch: ch, state: newSubscriberState(filter)})
+}
+
+func (b *broadcaster) add(sub subscriber) {
+	b.lock.Lock()
+	if !b.started {
+		b.early[sub.ch] = sub.state
+		b.lock.Unlock()
+		return
+	}
+	b.lock.Unlock()
+	select {
+	case b.subscribe <- sub:
+	case <-b.done:
+		close(sub.ch)
+	}
+}
+
+// Unsubscribe stops delivering location updates to ch.
+func (b *broadcaster) Unsubscribe(ch chan Location) {
+	b.lock.Lock()
+	if !b.started {
+		delete(b.early, ch)
+		b.lock.Unlock()
+		return
+	}
+	b.lock.Unlock()
+	select {
+	case b.unsubscribe <- ch:
+	case <-b.done:
+	}
+}
+
+// WaitForLocation waits for the next location update.
+func (b *broadcaster) WaitForLocation(ctx context.Context) (*Location, error) {
+	ch := make(chan Location)
+	b.Subscribe(ch)
+	select {
+	case loc, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("receiver loop shutting down")
+		}
+		b.Unsubscribe(ch)
+		return &loc, nil
+	case <-ctx.Done():
+		b.Unsubscribe(ch)
+		return nil, ctx.Err()
+	}
+}
+
+// subscription feeds the location updates of a provider to a callback in a
+// separate goroutine, until it's stopped or the provider shuts down. It's
+// used by consumers that follow a provider, such as Recorder, Track and
+// Geofencer. The zero value is ready to use.
+type subscription struct {
+	provider Provider
+	ch       chan Location
+	quit     chan interface{}
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+// start subscribes to p, and calls fn with its location updates.
+func (s *subscription) start(p Provider, fn func(Location)) {
+	s.provider = p
+	s.ch = make(chan Location, 16)
+	s.quit = make(chan interface{})
+	s.closed = false
+	p.Subscribe(s.ch)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.quit:
+				return
+			case loc, ok := <-s.ch:
+				if !ok {
+					// The provider has shut down.
+					s.closed = true
+					return
+				}
+				fn(loc)
+			}
+		}
+	}()
+}
+
+// stop unsubscribes from the provider, and waits until fn has returned. It
+// does nothing if the subscription isn't started.
+func (s *subscription) stop() {
+	if s.provider == nil {
+		return
+	}
+	close(s.quit)
+	s.wg.Wait()
+	if !s.closed {
+		s.provider.Unsubscribe(s.ch)
+	}
+	s.provider = nil
+}
@@ -0,0 +1,64 @@
+package geoclue2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionFilter(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	home := fix(47.4979, 19.0402, 50, start)
+
+	s := newSubscriberState(SubscriptionFilter{MinDistance: 100})
+	assert.True(t, s.accept(home, start))
+	s.last, s.sent = &home, start
+	near := home.DestinationPoint(0, 50)
+	near.Accuracy = 50
+	assert.False(t, s.accept(near, start))
+	far := home.DestinationPoint(0, 150)
+	assert.True(t, s.accept(far, start))
+
+	s = newSubscriberState(SubscriptionFilter{MinDistance: 100, MinAccuracyImprovement: 20})
+	s.last, s.sent = &home, start
+	better := home
+	better.Accuracy = 25
+	assert.True(t, s.accept(better, start))
+	better.Accuracy = 40
+	assert.False(t, s.accept(better, start))
+
+	s = newSubscriberState(SubscriptionFilter{MinInterval: time.Minute})
+	s.last, s.sent = &home, start
+	assert.False(t, s.accept(far, start.Add(30*time.Second)))
+	assert.True(t, s.accept(far, start.Add(time.Minute)))
+
+	s = newSubscriberState(SubscriptionFilter{Dedupe: true})
+	s.last, s.sent = &home, start
+	again := home
+	again.Timestamp = FromTime(start.Add(time.Second))
+	assert.False(t, s.accept(again, start.Add(time.Second)))
+	assert.True(t, s.accept(far, start.Add(time.Second)))
+}
+
+func TestSubscribeFiltered(t *testing.T) {
+	src := newTestProvider()
+	all := make(chan Location, 10)
+	filtered := make(chan Location, 10)
+	src.Subscribe(all)
+	src.SubscribeFiltered(filtered, SubscriptionFilter{MinDistance: 1000, Dedupe: true})
+	src.Start()
+	home := fix(47.4979, 19.0402, 10, time.Now())
+	src.publish(home)
+	src.publish(home)
+	src.publish(home.DestinationPoint(0, 500))
+	far := home.DestinationPoint(0, 2000)
+	src.publish(far)
+	for i := 0; i < 4; i++ {
+		receive(t, all)
+	}
+	assert.Equal(t, home, receive(t, filtered))
+	assert.Equal(t, far, receive(t, filtered))
+	assertNoLocation(t, filtered)
+	src.Stop()
+}
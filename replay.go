@@ -0,0 +1,219 @@
+package geoclue2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ReplayStepwise is the ReplayProvider speed for replaying a trace one entry
+// at a time via Step.
+const ReplayStepwise = 0
+
+// TraceEntry is a location as recorded by Recorder: one JSON object per line.
+type TraceEntry struct {
+	// Received is when the location update was received.
+	Received time.Time `json:"received"`
+	Location Location  `json:"location"`
+}
+
+// Recorder writes location updates to a trace, which can be played back
+// using ReplayProvider.
+type Recorder struct {
+	lock   sync.Mutex
+	enc    *json.Encoder
+	err    error
+	logger Logger
+	sub    subscription
+}
+
+// NewRecorder creates a recorder that writes a trace to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		enc:    json.NewEncoder(w),
+		logger: DefaultLogger(),
+	}
+}
+
+// SetLogger sets the Logger of the recorder. By default, it's the one set
+// with SetDefaultLogger. A nil Logger discards all messages. It has to be
+// called before Record.
+func (r *Recorder) SetLogger(l Logger) {
+	r.logger = orNop(l)
+}
+
+// Write records loc as received at the current time.
+func (r *Recorder) Write(loc Location) error {
+	return r.WriteEntry(TraceEntry{Received: time.Now(), Location: loc})
+}
+
+// WriteEntry records a trace entry.
+func (r *Recorder) WriteEntry(entry TraceEntry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.enc.Encode(entry); err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return err
+	}
+	return nil
+}
+
+// Record subscribes to p and records all location updates it broadcasts in
+// the background, until Stop is called or p shuts down.
+func (r *Recorder) Record(p Provider) {
+	r.sub.start(p, func(loc Location) {
+		if err := r.Write(loc); err != nil {
+			r.logger.Warn("recording location", "error", err)
+		}
+	})
+}
+
+// Stop stops recording and returns the first error encountered writing the
+// trace, if any.
+func (r *Recorder) Stop() error {
+	r.sub.stop()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// ReadTrace reads a trace written by Recorder.
+func ReadTrace(rd io.Reader) ([]TraceEntry, error) {
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(rd)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReplayProvider is a Provider that plays back a recorded trace, with the
+// same timing between updates as when it was recorded, or faster.
+type ReplayProvider struct {
+	*broadcaster
+	// Speed is the playback speed relative to the recording: 1 replays in
+	// real time, 10 ten times faster. With ReplayStepwise, entries are only
+	// replayed when Step is called. It has to be set before calling Start.
+	Speed   float64
+	entries []TraceEntry
+	lock    sync.Mutex
+	next    int
+	started bool
+	wg      sync.WaitGroup
+	stop    sync.Once
+	quit    chan interface{}
+	done    chan interface{}
+}
+
+// NewReplayProvider creates a provider that replays entries in real time.
+func NewReplayProvider(entries []TraceEntry) *ReplayProvider {
+	return &ReplayProvider{
+		broadcaster: newBroadcaster("replay"),
+		Speed:       1,
+		entries:     entries,
+		quit:        make(chan interface{}),
+		done:        make(chan interface{}),
+	}
+}
+
+// Start starts playing back the trace.
+func (p *ReplayProvider) Start() {
+	p.logger.Info("starting up", "provider", "replay", "entries", len(p.entries), "speed", p.Speed)
+	p.broadcaster.start()
+	p.lock.Lock()
+	p.started = true
+	p.lock.Unlock()
+	if len(p.entries) == 0 {
+		close(p.done)
+		return
+	}
+	if p.Speed > 0 {
+		p.wg.Add(1)
+		go p.replayLoop()
+	}
+}
+
+// Stop stops playback and waits until the provider has shut down.
+func (p *ReplayProvider) Stop() {
+	p.logger.Debug("stop requested", "provider", "replay")
+	p.stop.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+		p.broadcaster.stop()
+	})
+}
+
+// Done returns a channel that's closed once all entries have been replayed.
+func (p *ReplayProvider) Done() <-chan interface{} {
+	return p.done
+}
+
+// Step replays the next entry and returns its location. It returns io.EOF
+// once the whole trace has been replayed, and an error if the provider isn't
+// running.
+func (p *ReplayProvider) Step() (*Location, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.started {
+		return nil, fmt.Errorf("replay not started")
+	}
+	select {
+	case <-p.quit:
+		return nil, fmt.Errorf("replay stopped")
+	default:
+	}
+	if p.next >= len(p.entries) {
+		return nil, io.EOF
+	}
+	loc := p.entries[p.next].Location
+	p.next++
+	if !p.publish(loc) {
+		return nil, fmt.Errorf("replay shutting down")
+	}
+	if p.next == len(p.entries) {
+		close(p.done)
+	}
+	return &loc, nil
+}
+
+func (p *ReplayProvider) replayLoop() {
+	defer p.wg.Done()
+	for i, entry := range p.entries {
+		if i > 0 {
+			delay := entry.Received.Sub(p.entries[i-1].Received)
+			delay = time.Duration(float64(delay) / p.Speed)
+			if delay > 0 {
+				select {
+				case <-p.quit:
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+		if _, err := p.Step(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package geoclue2
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	src := newTestProvider()
+	src.Start()
+	buf := &bytes.Buffer{}
+	rec := NewRecorder(buf)
+	rec.Record(src)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		src.publish(fix(float64(i), float64(-i), 10, now.Add(time.Duration(i)*time.Second)))
+	}
+	// Give the recorder a chance to pick up the last update.
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, rec.Stop())
+	src.Stop()
+
+	entries, err := ReadTrace(buf)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, float64(i), entry.Location.Latitude)
+		assert.Equal(t, float64(-i), entry.Location.Longitude)
+		assert.False(t, entry.Received.IsZero())
+	}
+
+	p := NewReplayProvider(entries)
+	p.Speed = 100
+	ch := make(chan Location, 10)
+	p.Subscribe(ch)
+	p.Start()
+	defer p.Stop()
+	for i := 0; i < 3; i++ {
+		loc := receive(t, ch)
+		assert.Equal(t, entries[i].Location, loc)
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for replay to finish")
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	start := time.Now()
+	entries := []TraceEntry{
+		{Received: start, Location: fix(1, 1, 1, start)},
+		{Received: start.Add(time.Second), Location: fix(2, 2, 1, start)},
+	}
+	p := NewReplayProvider(entries)
+	p.Speed = 10
+	p.Start()
+	defer p.Stop()
+	t0 := time.Now()
+	<-p.Done()
+	elapsed := time.Since(t0)
+	assert.True(t, elapsed >= 100*time.Millisecond, "replay took %v", elapsed)
+	assert.True(t, elapsed < time.Second, "replay took %v", elapsed)
+	assert.Equal(t, 2.0, p.GetLatestLocation().Latitude)
+}
+
+func TestReplayStepwise(t *testing.T) {
+	trace := `{"received":"2020-09-13T12:26:40Z","location":{"Latitude":1,"Longitude":2,"Accuracy":3}}
+
+{"received":"2020-09-13T12:26:50Z","location":{"Latitude":4,"Longitude":5,"Accuracy":6}}
+`
+	entries, err := ReadTrace(strings.NewReader(trace))
+	assert.NoError(t, err)
+	p := NewReplayProvider(entries)
+	p.Speed = ReplayStepwise
+	_, err = p.Step()
+	assert.Error(t, err)
+	p.Start()
+	defer p.Stop()
+	loc, err := p.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, loc.Latitude)
+	assert.Equal(t, 1.0, p.GetLatestLocation().Latitude)
+	loc, err = p.Step()
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, loc.Latitude)
+	_, err = p.Step()
+	assert.Equal(t, io.EOF, err)
+	<-p.Done()
+	p.Stop()
+	// Stopping again does nothing.
+	p.Stop()
+
+	p = NewReplayProvider(entries)
+	p.Speed = ReplayStepwise
+	p.Start()
+	p.Stop()
+	_, err = p.Step()
+	assert.Error(t, err)
+
+	_, err = ReadTrace(strings.NewReader("{\n"))
+	assert.Error(t, err)
+}
+
+func TestReplayConcurrentStop(t *testing.T) {
+	p := NewReplayProvider([]TraceEntry{{Location: Location{Latitude: 1}}})
+	p.Speed = ReplayStepwise
+	p.Start()
+	stopped := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			p.Stop()
+			stopped <- struct{}{}
+		}()
+	}
+	<-stopped
+	<-stopped
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package geoclue2
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// openSerial opens a serial device for reading. Setting the baud rate is only
+// supported on Linux; elsewhere the device has to be configured beforehand.
+func openSerial(path string, baud int) (io.ReadCloser, error) {
+	if baud != 0 {
+		return nil, fmt.Errorf("setting the baud rate is not supported on this platform")
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,189 @@
+package solar
+
+import (
+	"sync"
+	"time"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+)
+
+// DefaultSchedulerInterval is how often Scheduler checks the phase of the
+// day.
+const DefaultSchedulerInterval = time.Minute
+
+// Event is a change of the phase of the day.
+type Event struct {
+	// From is the previous phase. It's the same as To for the first event.
+	From Phase
+	To   Phase
+	// Time is when the change was detected.
+	Time time.Time
+	// Location is the location the phase was computed for.
+	Location geoclue2.Location
+	// Elevation of the sun at Time, in degrees.
+	Elevation float64
+}
+
+// Scheduler follows the location updates of a provider, and notifies its
+// subscribers when the phase of the day changes, either because time passed
+// or the location changed. The phase is checked every Interval, so events
+// may be late by up to that much.
+type Scheduler struct {
+	// Interval is how often the phase is checked. It has to be set before
+	// Watch is called.
+	Interval    time.Duration
+	now         func() time.Time
+	lock        sync.Mutex
+	location    *geoclue2.Location
+	phase       Phase
+	subscribers map[chan<- Event]interface{}
+	logger      geoclue2.Logger
+	provider    geoclue2.Provider
+	ch          chan geoclue2.Location
+	quit        chan interface{}
+	wg          sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		Interval:    DefaultSchedulerInterval,
+		now:         time.Now,
+		subscribers: make(map[chan<- Event]interface{}),
+		logger:      geoclue2.DefaultLogger(),
+	}
+}
+
+// SetLogger sets the Logger of the scheduler. By default, it's the one set
+// with geoclue2.SetDefaultLogger. A nil Logger discards all messages.
+func (s *Scheduler) SetLogger(l geoclue2.Logger) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if l == nil {
+		l = geoclue2.DiscardLogger
+	}
+	s.logger = l
+}
+
+// Subscribe registers ch to receive events. Events are delivered without
+// blocking, so ch should be buffered. The channel is closed by Stop.
+func (s *Scheduler) Subscribe(ch chan Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[ch] = ""
+}
+
+// Unsubscribe stops delivering events to ch.
+func (s *Scheduler) Unsubscribe(ch chan Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, ch)
+}
+
+// Phase returns the current phase of the day, and false if no location is
+// known yet.
+func (s *Scheduler) Phase() (Phase, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.phase, s.location != nil
+}
+
+// Watch subscribes to p, and checks the phase of the day on its location
+// updates and every Interval, until Stop is called.
+func (s *Scheduler) Watch(p geoclue2.Provider) {
+	s.provider = p
+	s.ch = make(chan geoclue2.Location, 16)
+	s.quit = make(chan interface{})
+	p.Subscribe(s.ch)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		ch := s.ch
+		for {
+			select {
+			case <-s.quit:
+				if ch != nil {
+					p.Unsubscribe(s.ch)
+				}
+				return
+			case loc, ok := <-ch:
+				if !ok {
+					// The provider has shut down; keep following the
+					// time at the last location.
+					ch = nil
+					continue
+				}
+				s.Update(loc)
+			case <-ticker.C:
+				s.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler, and closes the channels of all subscribers.
+func (s *Scheduler) Stop() {
+	if s.provider != nil {
+		close(s.quit)
+		s.wg.Wait()
+		s.provider = nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = make(map[chan<- Event]interface{})
+}
+
+// Update sets the location, and checks the phase of the day there. The
+// first location always produces an event.
+func (s *Scheduler) Update(loc geoclue2.Location) (Event, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	first := s.location == nil
+	s.location = &loc
+	return s.checkLocked(first)
+}
+
+// Check checks the phase of the day at the current location, and returns
+// the event if it changed.
+func (s *Scheduler) Check() (Event, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.location == nil {
+		return Event{}, false
+	}
+	return s.checkLocked(false)
+}
+
+func (s *Scheduler) checkLocked(force bool) (Event, bool) {
+	now := s.now()
+	elevation := Elevation(now, s.location.Latitude, s.location.Longitude)
+	phase := PhaseOf(elevation)
+	if !force && phase == s.phase {
+		return Event{}, false
+	}
+	from := s.phase
+	if force {
+		from = phase
+	}
+	s.phase = phase
+	ev := Event{
+		From:      from,
+		To:        phase,
+		Time:      now,
+		Location:  *s.location,
+		Elevation: elevation,
+	}
+	s.logger.Info("solar phase changed", "from", ev.From, "to", ev.To)
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return ev, true
+}
@@ -0,0 +1,235 @@
+// Package solar computes the position of the sun, sunrise, sunset and
+// twilight times for a location, using the algorithms of the NOAA solar
+// calculator, which are accurate to about a minute for dates between 1800
+// and 2100.
+package solar
+
+import (
+	"math"
+	"time"
+)
+
+// Elevations of the center of the sun, in degrees, at the boundaries of the
+// phases of the day. Sunrise and sunset account for atmospheric refraction
+// and the radius of the sun.
+const (
+	SunriseElevation              = -0.833
+	CivilTwilightElevation        = -6.0
+	NauticalTwilightElevation     = -12.0
+	AstronomicalTwilightElevation = -18.0
+)
+
+// Phase is a phase of the day, determined by the elevation of the sun.
+type Phase int
+
+const (
+	// Night is when the sun is more than 18 degrees below the horizon.
+	Night Phase = iota
+	// AstronomicalTwilight is when the sun is 12 to 18 degrees below the
+	// horizon.
+	AstronomicalTwilight
+	// NauticalTwilight is when the sun is 6 to 12 degrees below the horizon.
+	NauticalTwilight
+	// CivilTwilight is between sunset and 6 degrees below the horizon.
+	CivilTwilight
+	// Day is between sunrise and sunset.
+	Day
+)
+
+func (p Phase) String() string {
+	switch p {
+	case Night:
+		return "night"
+	case AstronomicalTwilight:
+		return "astronomical twilight"
+	case NauticalTwilight:
+		return "nautical twilight"
+	case CivilTwilight:
+		return "civil twilight"
+	case Day:
+		return "day"
+	}
+	return "unknown"
+}
+
+// PhaseOf returns the phase of the day for a given elevation of the sun.
+func PhaseOf(elevation float64) Phase {
+	switch {
+	case elevation >= SunriseElevation:
+		return Day
+	case elevation >= CivilTwilightElevation:
+		return CivilTwilight
+	case elevation >= NauticalTwilightElevation:
+		return NauticalTwilight
+	case elevation >= AstronomicalTwilightElevation:
+		return AstronomicalTwilight
+	}
+	return Night
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// julianCentury returns the Julian century of t since J2000.0.
+func julianCentury(t time.Time) float64 {
+	jd := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	return (jd - 2451545) / 36525
+}
+
+// sun returns the declination of the sun in degrees and the equation of
+// time in minutes for a Julian century.
+func sun(jc float64) (float64, float64) {
+	meanLong := math.Mod(280.46646+jc*(36000.76983+jc*0.0003032), 360)
+	meanAnom := 357.52911 + jc*(35999.05029-0.0001537*jc)
+	ecc := 0.016708634 - jc*(0.000042037+0.0000001267*jc)
+	center := math.Sin(radians(meanAnom))*(1.914602-jc*(0.004817+0.000014*jc)) +
+		math.Sin(radians(2*meanAnom))*(0.019993-0.000101*jc) +
+		math.Sin(radians(3*meanAnom))*0.000289
+	trueLong := meanLong + center
+	omega := 125.04 - 1934.136*jc
+	appLong := trueLong - 0.00569 - 0.00478*math.Sin(radians(omega))
+	meanObliq := 23 + (26+(21.448-jc*(46.815+jc*(0.00059-jc*0.001813)))/60)/60
+	obliq := meanObliq + 0.00256*math.Cos(radians(omega))
+	decl := degrees(math.Asin(math.Sin(radians(obliq)) * math.Sin(radians(appLong))))
+	y := math.Tan(radians(obliq/2)) * math.Tan(radians(obliq/2))
+	l0 := radians(meanLong)
+	m := radians(meanAnom)
+	eqTime := 4 * degrees(y*math.Sin(2*l0)-2*ecc*math.Sin(m)+
+		4*ecc*y*math.Sin(m)*math.Cos(2*l0)-
+		0.5*y*y*math.Sin(4*l0)-1.25*ecc*ecc*math.Sin(2*m))
+	return decl, eqTime
+}
+
+// Position returns the elevation above the horizon and the azimuth,
+// clockwise from North, of the center of the sun in degrees, at time t and
+// location lat, lon. Atmospheric refraction is not taken into account.
+func Position(t time.Time, lat, lon float64) (elevation, azimuth float64) {
+	decl, eqTime := sun(julianCentury(t))
+	utc := t.UTC()
+	minutes := float64(utc.Hour()*60+utc.Minute()) +
+		(float64(utc.Second())+float64(utc.Nanosecond())/1e9)/60
+	trueSolarTime := math.Mod(minutes+eqTime+4*lon, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	hourAngle := trueSolarTime/4 - 180
+	phi, delta := radians(lat), radians(decl)
+	cosZenith := math.Sin(phi)*math.Sin(delta) +
+		math.Cos(phi)*math.Cos(delta)*math.Cos(radians(hourAngle))
+	zenith := math.Acos(math.Max(-1, math.Min(1, cosZenith)))
+	elevation = 90 - degrees(zenith)
+	denom := math.Cos(phi) * math.Sin(zenith)
+	if math.Abs(denom) < 1e-12 {
+		// At a pole or with the sun at the zenith the azimuth is undefined.
+		return elevation, 180
+	}
+	cosAz := (math.Sin(phi)*math.Cos(zenith) - math.Sin(delta)) / denom
+	az := degrees(math.Acos(math.Max(-1, math.Min(1, cosAz))))
+	if hourAngle > 0 {
+		azimuth = math.Mod(az+180, 360)
+	} else {
+		azimuth = math.Mod(540-az, 360)
+	}
+	return elevation, azimuth
+}
+
+// Elevation returns the elevation of the sun in degrees, see Position.
+func Elevation(t time.Time, lat, lon float64) float64 {
+	elevation, _ := Position(t, lat, lon)
+	return elevation
+}
+
+// PhaseAt returns the phase of the day at time t and location lat, lon.
+func PhaseAt(t time.Time, lat, lon float64) Phase {
+	return PhaseOf(Elevation(t, lat, lon))
+}
+
+// solarNoon returns the solar noon nearest to t at longitude lon.
+func solarNoon(t time.Time, lon float64) time.Time {
+	noon := t
+	for i := 0; i < 3; i++ {
+		_, eqTime := sun(julianCentury(noon))
+		utc := noon.UTC()
+		midnight := time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, time.UTC)
+		minutes := 720 - 4*lon - eqTime
+		noon = midnight.Add(time.Duration(minutes * float64(time.Minute)))
+		// Keep the noon on the same day as t, in terms of solar time.
+		if d := noon.Sub(t); d > 12*time.Hour {
+			noon = noon.Add(-24 * time.Hour)
+		} else if d < -12*time.Hour {
+			noon = noon.Add(24 * time.Hour)
+		}
+	}
+	return noon
+}
+
+// crossing returns the time the sun crosses elevation before (rising) or
+// after the solar noon. It returns false if the sun doesn't cross it on that
+// day.
+func crossing(noon time.Time, lat, elevation float64, rising bool) (time.Time, bool) {
+	t := noon
+	for i := 0; i < 3; i++ {
+		decl, _ := sun(julianCentury(t))
+		phi, delta := radians(lat), radians(decl)
+		cosH := (math.Sin(radians(elevation)) - math.Sin(phi)*math.Sin(delta)) /
+			(math.Cos(phi) * math.Cos(delta))
+		if cosH < -1 || cosH > 1 {
+			return time.Time{}, false
+		}
+		offset := time.Duration(4 * degrees(math.Acos(cosH)) * float64(time.Minute))
+		if rising {
+			t = noon.Add(-offset)
+		} else {
+			t = noon.Add(offset)
+		}
+	}
+	return t, true
+}
+
+// Times are the sunrise, sunset and twilight times of a day. Times that
+// don't occur on the day, e.g. sunrise during the polar night, are zero.
+type Times struct {
+	AstronomicalDawn time.Time
+	NauticalDawn     time.Time
+	CivilDawn        time.Time
+	Sunrise          time.Time
+	Noon             time.Time
+	Sunset           time.Time
+	CivilDusk        time.Time
+	NauticalDusk     time.Time
+	AstronomicalDusk time.Time
+}
+
+// TimesOf returns the times of the day containing date, in the time zone of
+// date, at location lat, lon. The times are in the time zone of date too.
+func TimesOf(date time.Time, lat, lon float64) Times {
+	y, m, d := date.Date()
+	// Start from noon local solar time on that calendar day.
+	approx := time.Date(y, m, d, 12, 0, 0, 0, time.UTC).
+		Add(-time.Duration(lon / 15 * float64(time.Hour)))
+	noon := solarNoon(approx, lon)
+	tz := date.Location()
+	at := func(elevation float64, rising bool) time.Time {
+		t, ok := crossing(noon, lat, elevation, rising)
+		if !ok {
+			return time.Time{}
+		}
+		return t.In(tz)
+	}
+	return Times{
+		AstronomicalDawn: at(AstronomicalTwilightElevation, true),
+		NauticalDawn:     at(NauticalTwilightElevation, true),
+		CivilDawn:        at(CivilTwilightElevation, true),
+		Sunrise:          at(SunriseElevation, true),
+		Noon:             noon.In(tz),
+		Sunset:           at(SunriseElevation, false),
+		CivilDusk:        at(CivilTwilightElevation, false),
+		NauticalDusk:     at(NauticalTwilightElevation, false),
+		AstronomicalDusk: at(AstronomicalTwilightElevation, false),
+	}
+}
@@ -0,0 +1,163 @@
+package solar
+
+import (
+	"testing"
+	"time"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+	"github.com/ldx/go-geoclue2/fakegeoclue"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertTime checks a time against a reference from the NOAA solar
+// calculator, to a minute.
+func assertTime(t *testing.T, expected string, actual time.Time) {
+	assert.False(t, actual.IsZero(), expected)
+	ref, err := time.Parse("15:04", expected)
+	assert.NoError(t, err)
+	y, m, d := actual.Date()
+	ref = time.Date(y, m, d, ref.Hour(), ref.Minute(), 0, 0, actual.Location())
+	diff := actual.Sub(ref)
+	assert.True(t, diff <= time.Minute && diff >= -time.Minute,
+		"expected %s, got %s", expected, actual.Format("15:04:05"))
+}
+
+func TestTimesOf(t *testing.T) {
+	bst := time.FixedZone("BST", 3600)
+	london := TimesOf(time.Date(2020, 6, 21, 0, 0, 0, 0, bst), 51.5074, -0.1278)
+	assertTime(t, "04:43", london.Sunrise)
+	assertTime(t, "13:02", london.Noon)
+	assertTime(t, "21:22", london.Sunset)
+	assertTime(t, "03:55", london.CivilDawn)
+	assertTime(t, "22:09", london.CivilDusk)
+	// London doesn't get astronomical night around the solstice.
+	assert.True(t, london.AstronomicalDawn.IsZero())
+	assert.True(t, london.AstronomicalDusk.IsZero())
+	assert.Equal(t, bst, london.Sunrise.Location())
+
+	hst := time.FixedZone("HST", -10*3600)
+	honolulu := TimesOf(time.Date(2020, 6, 21, 23, 0, 0, 0, hst), 21.3069, -157.8583)
+	assertTime(t, "05:50", honolulu.Sunrise)
+	assertTime(t, "19:16", honolulu.Sunset)
+	assert.Equal(t, 21, honolulu.Sunrise.Day())
+
+	// Midnight sun and polar night in Longyearbyen.
+	summer := TimesOf(time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC), 78.22, 15.65)
+	assert.True(t, summer.Sunrise.IsZero())
+	assert.True(t, summer.Sunset.IsZero())
+	assert.False(t, summer.Noon.IsZero())
+	winter := TimesOf(time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC), 78.22, 15.65)
+	assert.True(t, winter.Sunrise.IsZero())
+	assert.True(t, winter.CivilDawn.IsZero())
+	assert.False(t, winter.NauticalDawn.IsZero())
+}
+
+func TestPosition(t *testing.T) {
+	// At the solar noon on the equinox, the sun is nearly at the zenith of
+	// the equator.
+	noon := TimesOf(time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC), 0, 0).Noon
+	elevation, _ := Position(noon, 0, 0)
+	assert.InDelta(t, 90, elevation, 0.5)
+	// At sunrise the elevation is the sunrise elevation.
+	times := TimesOf(time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC), 47.4979, 19.0402)
+	assert.InDelta(t, SunriseElevation, Elevation(times.Sunrise, 47.4979, 19.0402), 0.01)
+	assert.InDelta(t, CivilTwilightElevation, Elevation(times.CivilDusk, 47.4979, 19.0402), 0.01)
+	// The sun rises in the northeast and sets in the northwest in summer.
+	_, az := Position(times.Sunrise, 47.4979, 19.0402)
+	assert.InDelta(t, 52, az, 2)
+	_, az = Position(times.Sunset, 47.4979, 19.0402)
+	assert.InDelta(t, 308, az, 2)
+	_, az = Position(times.Noon, 47.4979, 19.0402)
+	assert.InDelta(t, 180, az, 0.5)
+}
+
+func TestPhase(t *testing.T) {
+	assert.Equal(t, Day, PhaseOf(10))
+	assert.Equal(t, CivilTwilight, PhaseOf(-3))
+	assert.Equal(t, NauticalTwilight, PhaseOf(-9))
+	assert.Equal(t, AstronomicalTwilight, PhaseOf(-15))
+	assert.Equal(t, Night, PhaseOf(-30))
+	assert.Equal(t, "civil twilight", CivilTwilight.String())
+	noon := time.Date(2020, 6, 21, 11, 0, 0, 0, time.UTC)
+	assert.Equal(t, Day, PhaseAt(noon, 47.4979, 19.0402))
+	assert.Equal(t, Night, PhaseAt(noon, -47.4979, -160.9598))
+}
+
+func TestScheduler(t *testing.T) {
+	budapest := geoclue2.Location{Latitude: 47.4979, Longitude: 19.0402}
+	times := TimesOf(time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC), budapest.Latitude, budapest.Longitude)
+	now := times.Sunset.Add(-time.Minute)
+	s := NewScheduler()
+	s.now = func() time.Time { return now }
+	ch := make(chan Event, 10)
+	s.Subscribe(ch)
+
+	_, ok := s.Check()
+	assert.False(t, ok)
+	_, ok = s.Phase()
+	assert.False(t, ok)
+
+	ev, ok := s.Update(budapest)
+	assert.True(t, ok)
+	assert.Equal(t, Day, ev.From)
+	assert.Equal(t, Day, ev.To)
+	_, ok = s.Check()
+	assert.False(t, ok)
+
+	now = times.Sunset.Add(time.Minute)
+	ev, ok = s.Check()
+	assert.True(t, ok)
+	assert.Equal(t, Day, ev.From)
+	assert.Equal(t, CivilTwilight, ev.To)
+	phase, ok := s.Phase()
+	assert.True(t, ok)
+	assert.Equal(t, CivilTwilight, phase)
+
+	// Moving far west brings back the day.
+	ev, ok = s.Update(geoclue2.Location{Latitude: 40.7, Longitude: -74})
+	assert.True(t, ok)
+	assert.Equal(t, Day, ev.To)
+	assert.Len(t, ch, 3)
+	s.Stop()
+}
+
+func TestSchedulerWatch(t *testing.T) {
+	replay := geoclue2.NewReplayProvider([]geoclue2.TraceEntry{
+		{Location: geoclue2.Location{Latitude: 47.4979, Longitude: 19.0402}},
+	})
+	replay.Speed = geoclue2.ReplayStepwise
+	replay.Start()
+	s := NewScheduler()
+	ch := make(chan Event, 1)
+	s.Subscribe(ch)
+	s.Watch(replay)
+	_, err := replay.Step()
+	assert.NoError(t, err)
+	select {
+	case ev := <-ch:
+		assert.Equal(t, 47.4979, ev.Location.Latitude)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	s.Stop()
+	replay.Stop()
+}
+
+func TestSchedulerStopAfterProvider(t *testing.T) {
+	s := fakegeoclue.New()
+	conn, err := s.ServePeer()
+	assert.NoError(t, err)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "")
+	gc2.Start()
+	w := NewScheduler()
+	ch := make(chan Event, 1)
+	w.Subscribe(ch)
+	w.Watch(gc2)
+	gc2.Stop()
+	w.Stop()
+	// Stopping again does nothing.
+	w.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,174 @@
+package geoclue2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGeolocationFile is where geoclue2 looks for a static location.
+const DefaultGeolocationFile = "/etc/geolocation"
+
+// StaticProvider is a Provider that publishes a fixed location read from a
+// file in the format geoclue2 uses for /etc/geolocation. The file is watched
+// for changes, and a new location is published whenever it's modified.
+type StaticProvider struct {
+	*broadcaster
+	path string
+	wg   sync.WaitGroup
+	stop sync.Once
+	quit chan interface{}
+}
+
+// NewStaticProvider creates a provider for the geolocation file at path. If
+// path is empty, DefaultGeolocationFile is used.
+func NewStaticProvider(path string) *StaticProvider {
+	if path == "" {
+		path = DefaultGeolocationFile
+	}
+	return &StaticProvider{
+		broadcaster: newBroadcaster("static"),
+		path:        path,
+		quit:        make(chan interface{}),
+	}
+}
+
+// Start reads the geolocation file, publishes its location, and starts
+// watching the file for changes.
+func (s *StaticProvider) Start() {
+	s.logger.Info("starting up", "provider", "static", "path", s.path)
+	s.broadcaster.start()
+	s.wg.Add(1)
+	go s.watchLoop()
+}
+
+// Stop stops watching the geolocation file and waits until the provider has
+// shut down.
+func (s *StaticProvider) Stop() {
+	s.logger.Debug("stop requested", "provider", "static")
+	s.stop.Do(func() {
+		close(s.quit)
+		s.broadcaster.stop()
+		s.wg.Wait()
+	})
+}
+
+// fileWatcher notifies about changes to a file.
+type fileWatcher interface {
+	// Events returns a channel that receives a value whenever the file is
+	// created, modified, replaced or removed.
+	Events() <-chan struct{}
+	Close() error
+}
+
+func (s *StaticProvider) watchLoop() {
+	defer s.wg.Done()
+	watcher, err := newFileWatcher(s.path, s.logger)
+	if err != nil {
+		s.logger.Warn("watching file", "provider", "static", "path", s.path, "error", err)
+	} else {
+		defer watcher.Close()
+	}
+	s.update()
+	var events <-chan struct{}
+	if watcher != nil {
+		events = watcher.Events()
+	}
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-events:
+			s.logger.Debug("file changed", "provider", "static", "path", s.path)
+			s.update()
+		}
+	}
+}
+
+func (s *StaticProvider) update() {
+	loc, err := ReadGeolocationFile(s.path)
+	if err != nil {
+		s.logger.Warn("reading location", "provider", "static", "error", err)
+		return
+	}
+	s.publish(*loc)
+}
+
+// ReadGeolocationFile reads a location from a file in the /etc/geolocation
+// format. See ParseGeolocation.
+func ReadGeolocationFile(path string) (*Location, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	loc, err := ParseGeolocation(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return loc, nil
+}
+
+// ParseGeolocation parses a location in the format geoclue2 uses for
+// /etc/geolocation: latitude, longitude, altitude and accuracy radius, one
+// value per line, in that order. Everything after a '#' is a comment, and
+// empty lines are ignored. For example:
+//
+//	# Statue of Liberty
+//	40.6893129   # latitude
+//	-74.0445531  # longitude
+//	96           # altitude
+//	1.83         # accuracy radius
+//
+// The timestamp of the returned location is set to the current time.
+func ParseGeolocation(r io.Reader) (*Location, error) {
+	var values []float64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(values) == 4 {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		v, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q: %v", line, err)
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) != 4 {
+		return nil, fmt.Errorf("expected latitude, longitude, altitude and accuracy, got %d values", len(values))
+	}
+	if values[0] < -90 || values[0] > 90 {
+		return nil, fmt.Errorf("latitude %v out of range", values[0])
+	}
+	if values[1] < -180 || values[1] > 180 {
+		return nil, fmt.Errorf("longitude %v out of range", values[1])
+	}
+	if values[3] < 0 {
+		return nil, fmt.Errorf("negative accuracy %v", values[3])
+	}
+	return &Location{
+		Latitude:  values[0],
+		Longitude: values[1],
+		Altitude:  values[2],
+		Accuracy:  values[3],
+		Speed:     UnknownSpeed,
+		Heading:   UnknownHeading,
+		Timestamp: FromTime(time.Now()),
+	}, nil
+}
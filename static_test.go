@@ -0,0 +1,104 @@
+package geoclue2
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGeolocation(t *testing.T) {
+	loc, err := ParseGeolocation(strings.NewReader(`
+# Statue of Liberty
+40.6893129   # latitude
+-74.0445531  # longitude
+
+96           # altitude
+1.83         # accuracy radius
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, 40.6893129, loc.Latitude)
+	assert.Equal(t, -74.0445531, loc.Longitude)
+	assert.Equal(t, 96.0, loc.Altitude)
+	assert.Equal(t, 1.83, loc.Accuracy)
+	assert.Equal(t, -1.0, loc.Speed)
+	assert.Equal(t, -1.0, loc.Heading)
+	assert.NotZero(t, loc.Timestamp.Seconds)
+}
+
+func TestParseGeolocationErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"40.6\n-74.0\n96\n",
+		"40.6\n-74.0\n96\n1.8\n5\n",
+		"40.6\nwest\n96\n1.8\n",
+		"91\n-74.0\n96\n1.8\n",
+		"40.6\n-181\n96\n1.8\n",
+		"40.6\n-74.0\n96\n-1\n",
+	} {
+		_, err := ParseGeolocation(strings.NewReader(input))
+		assert.Error(t, err, "input %q", input)
+	}
+}
+
+func TestStaticProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoclue2")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "geolocation")
+	err = ioutil.WriteFile(path, []byte("1\n2\n3\n4\n"), 0644)
+	assert.NoError(t, err)
+
+	p := NewStaticProvider(path)
+	ch := make(chan Location, 10)
+	// Subscribed before Start, so the initial location isn't missed.
+	p.Subscribe(ch)
+	p.Start()
+	defer p.Stop()
+
+	select {
+	case loc := <-ch:
+		assert.Equal(t, 1.0, loc.Latitude)
+		assert.Equal(t, 2.0, loc.Longitude)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for initial location")
+	}
+
+	// Replace the file the way editors do.
+	tmp := filepath.Join(dir, "geolocation.tmp")
+	err = ioutil.WriteFile(tmp, []byte("5\n6\n7\n8\n"), 0644)
+	assert.NoError(t, err)
+	err = os.Rename(tmp, path)
+	assert.NoError(t, err)
+
+	select {
+	case loc := <-ch:
+		assert.Equal(t, 5.0, loc.Latitude)
+		assert.Equal(t, 6.0, loc.Longitude)
+		assert.Equal(t, 8.0, loc.Accuracy)
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for updated location")
+	}
+	p.Stop()
+	// Stopping again does nothing.
+	p.Stop()
+}
+
+func TestStaticProviderConcurrentStop(t *testing.T) {
+	p := NewStaticProvider("/nonexistent/geolocation")
+	p.SetLogger(nil)
+	p.Start()
+	stopped := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			p.Stop()
+			stopped <- struct{}{}
+		}()
+	}
+	<-stopped
+	<-stopped
+}
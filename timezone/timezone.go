@@ -0,0 +1,178 @@
+// Package timezone maps locations to IANA time zones, using time zone
+// boundary data from a local file, e.g. combined.json from the releases of
+// https://github.com/evansiroky/timezone-boundary-builder.
+package timezone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+)
+
+// ErrNotFound is returned by Finder.TimeLocation when there's no time zone
+// at a location.
+var ErrNotFound = fmt.Errorf("no time zone found")
+
+// polygon is a polygon with holes, as [lon, lat] rings, and its bounding box.
+type polygon struct {
+	rings                          [][][2]float64
+	minLat, maxLat, minLon, maxLon float64
+}
+
+func newPolygon(rings [][][2]float64) polygon {
+	p := polygon{
+		rings:  rings,
+		minLat: 90, maxLat: -90, minLon: 180, maxLon: -180,
+	}
+	for _, pos := range rings[0] {
+		if pos[1] < p.minLat {
+			p.minLat = pos[1]
+		}
+		if pos[1] > p.maxLat {
+			p.maxLat = pos[1]
+		}
+		if pos[0] < p.minLon {
+			p.minLon = pos[0]
+		}
+		if pos[0] > p.maxLon {
+			p.maxLon = pos[0]
+		}
+	}
+	return p
+}
+
+// ringContains reports whether lat, lon is inside ring, using the even-odd
+// rule.
+func ringContains(ring [][2]float64, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) {
+			x := a[0] + (lat-a[1])/(b[1]-a[1])*(b[0]-a[0])
+			if lon < x {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func (p *polygon) contains(lat, lon float64) bool {
+	if lat < p.minLat || lat > p.maxLat || lon < p.minLon || lon > p.maxLon {
+		return false
+	}
+	if !ringContains(p.rings[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p.rings[1:] {
+		if ringContains(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+type zone struct {
+	id       string
+	polygons []polygon
+}
+
+// Finder looks up the time zone of locations.
+type Finder struct {
+	zones []zone
+}
+
+// Load reads time zone boundaries from a GeoJSON file, see Read.
+func Load(path string) (*Finder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	finder, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return finder, nil
+}
+
+// Read reads time zone boundaries from a GeoJSON FeatureCollection. Each
+// feature is a Polygon or MultiPolygon, with the IANA name of the time zone
+// in its "tzid" property.
+func Read(r io.Reader) (*Finder, error) {
+	var doc struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				TZID string `json:"tzid"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("unexpected GeoJSON type %q", doc.Type)
+	}
+	f := &Finder{}
+	for i, feature := range doc.Features {
+		if feature.Properties.TZID == "" {
+			return nil, fmt.Errorf("feature %d: missing tzid", i)
+		}
+		var polygons [][][][2]float64
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var rings [][][2]float64
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &rings); err != nil {
+				return nil, fmt.Errorf("feature %d: %v", i, err)
+			}
+			polygons = [][][][2]float64{rings}
+		case "MultiPolygon":
+			if err := json.Unmarshal(feature.Geometry.Coordinates, &polygons); err != nil {
+				return nil, fmt.Errorf("feature %d: %v", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("feature %d: unsupported geometry %q", i, feature.Geometry.Type)
+		}
+		z := zone{id: feature.Properties.TZID}
+		for _, rings := range polygons {
+			if len(rings) == 0 || len(rings[0]) < 3 {
+				return nil, fmt.Errorf("feature %d: invalid polygon", i)
+			}
+			z.polygons = append(z.polygons, newPolygon(rings))
+		}
+		f.zones = append(f.zones, z)
+	}
+	return f, nil
+}
+
+// Lookup returns the IANA name of the time zone at lat, lon, e.g.
+// "Europe/Budapest". It returns false if no time zone contains the location.
+func (f *Finder) Lookup(lat, lon float64) (string, bool) {
+	for _, z := range f.zones {
+		for i := range z.polygons {
+			if z.polygons[i].contains(lat, lon) {
+				return z.id, true
+			}
+		}
+	}
+	return "", false
+}
+
+// TimeLocation returns the time zone at loc, loaded from the system's time
+// zone database.
+func (f *Finder) TimeLocation(loc geoclue2.Location) (*time.Location, error) {
+	id, ok := f.Lookup(loc.Latitude, loc.Longitude)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return time.LoadLocation(id)
+}
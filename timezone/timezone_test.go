@@ -0,0 +1,153 @@
+package timezone
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+	"github.com/ldx/go-geoclue2/fakegeoclue"
+	"github.com/stretchr/testify/assert"
+)
+
+// Two zones split at longitude 10, the eastern one with a hole, and an
+// island made of two polygons.
+const boundaries = `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "properties": {"tzid": "Europe/Paris"},
+      "geometry": {
+        "type": "Polygon",
+        "coordinates": [[[0, 40], [10, 40], [10, 50], [0, 50], [0, 40]]]
+      }
+    },
+    {
+      "type": "Feature",
+      "properties": {"tzid": "Europe/Budapest"},
+      "geometry": {
+        "type": "Polygon",
+        "coordinates": [
+          [[10, 40], [20, 40], [20, 50], [10, 50], [10, 40]],
+          [[14, 44], [16, 44], [16, 46], [14, 46], [14, 44]]
+        ]
+      }
+    },
+    {
+      "type": "Feature",
+      "properties": {"tzid": "Europe/Vienna"},
+      "geometry": {
+        "type": "MultiPolygon",
+        "coordinates": [
+          [[[14, 44], [16, 44], [16, 46], [14, 46], [14, 44]]],
+          [[[30, 30], [31, 30], [31, 31], [30, 30]]]
+        ]
+      }
+    }
+  ]
+}`
+
+func TestLookup(t *testing.T) {
+	f, err := Read(strings.NewReader(boundaries))
+	assert.NoError(t, err)
+	for _, tc := range []struct {
+		lat, lon float64
+		id       string
+	}{
+		{45, 5, "Europe/Paris"},
+		{45, 12, "Europe/Budapest"},
+		{45, 15, "Europe/Vienna"},
+		{30.2, 30.8, "Europe/Vienna"},
+		{30.8, 30.2, ""},
+		{0, 0, ""},
+	} {
+		id, ok := f.Lookup(tc.lat, tc.lon)
+		assert.Equal(t, tc.id, id, "%v,%v", tc.lat, tc.lon)
+		assert.Equal(t, tc.id != "", ok)
+	}
+
+	tz, err := f.TimeLocation(geoclue2.Location{Latitude: 45, Longitude: 5})
+	if err == nil {
+		assert.Equal(t, "Europe/Paris", tz.String())
+	}
+	_, err = f.TimeLocation(geoclue2.Location{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, doc := range []string{
+		`not json`,
+		`{"type": "Feature"}`,
+		`{"type": "FeatureCollection", "features": [{"geometry": {"type": "Polygon", "coordinates": []}}]}`,
+		`{"type": "FeatureCollection", "features": [{"properties": {"tzid": "UTC"},
+		  "geometry": {"type": "Point", "coordinates": [0, 0]}}]}`,
+		`{"type": "FeatureCollection", "features": [{"properties": {"tzid": "UTC"},
+		  "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [1, 1]]]}}]}`,
+	} {
+		_, err := Read(strings.NewReader(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func receive(t *testing.T, ch chan Change) Change {
+	select {
+	case c := <-ch:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for time zone change")
+	}
+	return Change{}
+}
+
+func TestWatcher(t *testing.T) {
+	f, err := Read(strings.NewReader(boundaries))
+	assert.NoError(t, err)
+	at := func(lat, lon float64) geoclue2.TraceEntry {
+		return geoclue2.TraceEntry{Location: geoclue2.Location{Latitude: lat, Longitude: lon}}
+	}
+	replay := geoclue2.NewReplayProvider([]geoclue2.TraceEntry{
+		at(45, 5), at(46, 6), at(0, 0), at(45, 12),
+	})
+	replay.Speed = geoclue2.ReplayStepwise
+	replay.Start()
+	w := NewWatcher(f)
+	ch := make(chan Change, 4)
+	w.Subscribe(ch)
+	w.Watch(replay)
+	for i := 0; i < 4; i++ {
+		_, err := replay.Step()
+		assert.NoError(t, err)
+	}
+	c := receive(t, ch)
+	assert.Equal(t, "", c.From)
+	assert.Equal(t, "Europe/Paris", c.To)
+	c = receive(t, ch)
+	assert.Equal(t, "Europe/Paris", c.From)
+	assert.Equal(t, "Europe/Budapest", c.To)
+	assert.Equal(t, 12.0, c.Location.Longitude)
+	assert.Equal(t, "Europe/Budapest", w.Current())
+	w.Stop()
+	replay.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestWatcherStopAfterProvider(t *testing.T) {
+	s := fakegeoclue.New()
+	conn, err := s.ServePeer()
+	assert.NoError(t, err)
+	defer s.Close()
+	gc2 := geoclue2.NewGeoClue2(conn, "")
+	gc2.Start()
+	w := NewWatcher(&Finder{})
+	ch := make(chan Change, 1)
+	w.Subscribe(ch)
+	w.Watch(gc2)
+	gc2.Stop()
+	w.Stop()
+	// Stopping again does nothing.
+	w.Stop()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,137 @@
+package timezone
+
+import (
+	"sync"
+
+	geoclue2 "github.com/ldx/go-geoclue2"
+)
+
+// Change is a time zone change.
+type Change struct {
+	// From is the previous time zone, or "" for the first one found.
+	From string
+	// To is the new time zone.
+	To string
+	// Location is the location update that triggered the change.
+	Location geoclue2.Location
+}
+
+// Watcher follows the location updates of a provider, and notifies its
+// subscribers when the time zone changes. Locations outside of all time
+// zones, e.g. at sea in some datasets, don't change the current time zone.
+type Watcher struct {
+	finder      *Finder
+	lock        sync.Mutex
+	current     string
+	subscribers map[chan<- Change]interface{}
+	logger      geoclue2.Logger
+	provider    geoclue2.Provider
+	ch          chan geoclue2.Location
+	quit        chan interface{}
+	wg          sync.WaitGroup
+}
+
+// NewWatcher creates a watcher looking up time zones with finder.
+func NewWatcher(finder *Finder) *Watcher {
+	return &Watcher{
+		finder:      finder,
+		subscribers: make(map[chan<- Change]interface{}),
+		logger:      geoclue2.DefaultLogger(),
+	}
+}
+
+// SetLogger sets the Logger of the watcher. By default, it's the one set with
+// geoclue2.SetDefaultLogger. A nil Logger discards all messages.
+func (w *Watcher) SetLogger(l geoclue2.Logger) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if l == nil {
+		l = geoclue2.DiscardLogger
+	}
+	w.logger = l
+}
+
+// Current returns the current time zone, or "" if none has been found yet.
+func (w *Watcher) Current() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.current
+}
+
+// Subscribe registers ch to receive time zone changes. Changes are delivered
+// without blocking, so ch should be buffered. The channel is closed by Stop.
+func (w *Watcher) Subscribe(ch chan Change) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.subscribers[ch] = ""
+}
+
+// Unsubscribe stops delivering changes to ch.
+func (w *Watcher) Unsubscribe(ch chan Change) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.subscribers, ch)
+}
+
+// Watch subscribes to p and feeds its location updates to Update, until Stop
+// is called or p shuts down.
+func (w *Watcher) Watch(p geoclue2.Provider) {
+	w.provider = p
+	w.ch = make(chan geoclue2.Location, 16)
+	w.quit = make(chan interface{})
+	p.Subscribe(w.ch)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case <-w.quit:
+				p.Unsubscribe(w.ch)
+				return
+			case loc, ok := <-w.ch:
+				if !ok {
+					// The provider has shut down.
+					return
+				}
+				w.Update(loc)
+			}
+		}
+	}()
+}
+
+// Stop stops watching the provider, and closes the channels of all
+// subscribers.
+func (w *Watcher) Stop() {
+	if w.provider != nil {
+		close(w.quit)
+		w.wg.Wait()
+		w.provider = nil
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for ch := range w.subscribers {
+		close(ch)
+	}
+	w.subscribers = make(map[chan<- Change]interface{})
+}
+
+// Update looks up the time zone of loc. If it differs from the current one,
+// the change is sent to the subscribers and returned.
+func (w *Watcher) Update(loc geoclue2.Location) (Change, bool) {
+	id, ok := w.finder.Lookup(loc.Latitude, loc.Longitude)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !ok || id == w.current {
+		return Change{}, false
+	}
+	w.logger.Info("timezone changed", "from", w.current, "to", id)
+	change := Change{From: w.current, To: id, Location: loc}
+	w.current = id
+	for ch := range w.subscribers {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+	return change, true
+}
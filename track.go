@@ -0,0 +1,376 @@
+package geoclue2
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSegmentGap is the time between two points after which Track
+	// starts a new segment.
+	DefaultSegmentGap = 5 * time.Minute
+	gpxNamespace      = "http://www.topografix.com/GPX/1/1"
+	gpxSchemaLocation = "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd"
+	// Garmin's TrackPointExtension, the de facto standard for speed and
+	// course in GPX 1.1, which dropped them from trkpt.
+	gpxTPXNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+)
+
+// Track accumulates location updates into a track, split into segments where
+// there's a gap in time between consecutive points. Tracks can be exported as
+// GPX or GeoJSON.
+type Track struct {
+	// Name of the track, included in the exported files.
+	Name string
+	// SegmentGap is the time between two points after which a new segment
+	// is started. Segmenting is disabled if it's zero.
+	SegmentGap time.Duration
+	lock       sync.Mutex
+	segments   [][]Location
+	sub        subscription
+}
+
+// NewTrack creates an empty track.
+func NewTrack(name string) *Track {
+	return &Track{
+		Name:       name,
+		SegmentGap: DefaultSegmentGap,
+	}
+}
+
+// Record subscribes to p and adds all location updates it broadcasts to the
+// track, until Stop is called or p shuts down.
+func (t *Track) Record(p Provider) {
+	t.sub.start(p, t.Add)
+}
+
+// Stop stops recording.
+func (t *Track) Stop() {
+	t.sub.stop()
+}
+
+// Add appends loc to the track.
+func (t *Track) Add(loc Location) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := len(t.segments)
+	if n == 0 || t.isGap(t.segments[n-1][len(t.segments[n-1])-1], loc) {
+		t.segments = append(t.segments, []Location{loc})
+		return
+	}
+	t.segments[n-1] = append(t.segments[n-1], loc)
+}
+
+func (t *Track) isGap(prev, next Location) bool {
+	if t.SegmentGap <= 0 {
+		return false
+	}
+	gap := next.Timestamp.Time().Sub(prev.Timestamp.Time())
+	return gap > t.SegmentGap || gap < -t.SegmentGap
+}
+
+// Segments returns a copy of the segments of the track.
+func (t *Track) Segments() [][]Location {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make([][]Location, len(t.segments))
+	for i, seg := range t.segments {
+		ret[i] = append([]Location(nil), seg...)
+	}
+	return ret
+}
+
+// Len returns the number of points in the track.
+func (t *Track) Len() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	n := 0
+	for _, seg := range t.segments {
+		n += len(seg)
+	}
+	return n
+}
+
+type gpx struct {
+	XMLName        xml.Name `xml:"gpx"`
+	Version        string   `xml:"version,attr"`
+	Creator        string   `xml:"creator,attr"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	XmlnsTPX       string   `xml:"xmlns:gpxtpx,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Track          gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Latitude   float64        `xml:"lat,attr"`
+	Longitude  float64        `xml:"lon,attr"`
+	Elevation  *float64       `xml:"ele,omitempty"`
+	Time       string         `xml:"time,omitempty"`
+	Desc       string         `xml:"desc,omitempty"`
+	Extensions *gpxExtensions `xml:"extensions,omitempty"`
+}
+
+type gpxExtensions struct {
+	TrackPoint gpxTPX `xml:"gpxtpx:TrackPointExtension"`
+}
+
+type gpxTPX struct {
+	Speed  *float64 `xml:"gpxtpx:speed,omitempty"`
+	Course *float64 `xml:"gpxtpx:course,omitempty"`
+}
+
+// WriteGPX writes the track as a GPX 1.1 document. Elevation and time are
+// included in each trkpt when known; speed and course are added using the
+// Garmin TrackPointExtension.
+func (t *Track) WriteGPX(w io.Writer) error {
+	doc := gpx{
+		Version:        "1.1",
+		Creator:        "go-geoclue2",
+		Xmlns:          gpxNamespace,
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		XmlnsTPX:       gpxTPXNamespace,
+		SchemaLocation: gpxSchemaLocation,
+		Track:          gpxTrack{Name: t.Name},
+	}
+	for _, seg := range t.Segments() {
+		s := gpxSegment{}
+		for _, loc := range seg {
+			s.Points = append(s.Points, newGPXPoint(loc))
+		}
+		doc.Track.Segments = append(doc.Track.Segments, s)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newGPXPoint(loc Location) gpxPoint {
+	p := gpxPoint{
+		Latitude:  loc.Latitude,
+		Longitude: loc.Longitude,
+		Time:      loc.Timestamp.String(),
+		Desc:      loc.Description,
+	}
+	if loc.HasAltitude() {
+		alt := loc.Altitude
+		p.Elevation = &alt
+	}
+	tpx := gpxTPX{}
+	if loc.HasSpeed() {
+		speed := loc.Speed
+		tpx.Speed = &speed
+	}
+	if loc.HasHeading() {
+		course := loc.Heading
+		tpx.Course = &course
+	}
+	if tpx.Speed != nil || tpx.Course != nil {
+		p.Extensions = &gpxExtensions{TrackPoint: tpx}
+	}
+	return p
+}
+
+// gpxDocument is the part of a GPX 1.0 or 1.1 document read by ReadGPX.
+// Elements are matched by their local names, so the namespaces don't matter.
+type gpxDocument struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxReadPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxReadPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxReadPoint struct {
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+	Desc      string   `xml:"desc"`
+	// GPX 1.0 elements.
+	Speed  *float64 `xml:"speed"`
+	Course *float64 `xml:"course"`
+	// TrackPointExtension elements.
+	ExtSpeed  *float64 `xml:"extensions>TrackPointExtension>speed"`
+	ExtCourse *float64 `xml:"extensions>TrackPointExtension>course"`
+}
+
+func (p gpxReadPoint) location() (Location, error) {
+	loc := Location{
+		Latitude:    p.Latitude,
+		Longitude:   p.Longitude,
+		Altitude:    UnknownAltitude,
+		Speed:       UnknownSpeed,
+		Heading:     UnknownHeading,
+		Description: p.Desc,
+	}
+	if p.Elevation != nil {
+		loc.Altitude = *p.Elevation
+	}
+	if p.ExtSpeed != nil {
+		loc.Speed = *p.ExtSpeed
+	} else if p.Speed != nil {
+		loc.Speed = *p.Speed
+	}
+	if p.ExtCourse != nil {
+		loc.Heading = *p.ExtCourse
+	} else if p.Course != nil {
+		loc.Heading = *p.Course
+	}
+	if p.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(p.Time))
+		if err != nil {
+			return Location{}, fmt.Errorf("invalid time %q: %v", p.Time, err)
+		}
+		loc.Timestamp = FromTime(t)
+	}
+	return loc, nil
+}
+
+// ReadGPX reads the tracks and routes of a GPX document into a track. Each
+// track segment and route becomes a segment; the name is that of the first
+// track. The accuracy of the points is zero, since GPX doesn't have it.
+func ReadGPX(r io.Reader) (*Track, error) {
+	doc := gpxDocument{}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var segments [][]gpxReadPoint
+	name := ""
+	for i, trk := range doc.Tracks {
+		if i == 0 {
+			name = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			segments = append(segments, seg.Points)
+		}
+	}
+	for _, rte := range doc.Routes {
+		segments = append(segments, rte.Points)
+	}
+	t := NewTrack(name)
+	for _, points := range segments {
+		if len(points) == 0 {
+			continue
+		}
+		seg := make([]Location, 0, len(points))
+		for _, p := range points {
+			loc, err := p.location()
+			if err != nil {
+				return nil, err
+			}
+			seg = append(seg, loc)
+		}
+		t.segments = append(t.segments, seg)
+	}
+	return t, nil
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// WriteGeoJSON writes the track as a GeoJSON FeatureCollection, with one
+// LineString feature per segment. The properties of each feature hold the
+// track name, the segment index, and the times, accuracies, speeds and
+// headings of its points, with null for unknown values. Altitudes are
+// included in the coordinates if they're known for every point of the
+// segment.
+func (t *Track) WriteGeoJSON(w io.Writer) error {
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []geoJSONFeature{},
+	}
+	for i, seg := range t.Segments() {
+		withAltitude := true
+		for _, loc := range seg {
+			if !loc.HasAltitude() {
+				withAltitude = false
+				break
+			}
+		}
+		coords := make([][]float64, len(seg))
+		times := make([]interface{}, len(seg))
+		accuracies := make([]float64, len(seg))
+		speeds := make([]interface{}, len(seg))
+		headings := make([]interface{}, len(seg))
+		for j, loc := range seg {
+			coords[j] = []float64{loc.Longitude, loc.Latitude}
+			if withAltitude {
+				coords[j] = append(coords[j], loc.Altitude)
+			}
+			if ts := loc.Timestamp.String(); ts != "" {
+				times[j] = ts
+			}
+			accuracies[j] = loc.Accuracy
+			if loc.HasSpeed() {
+				speeds[j] = loc.Speed
+			}
+			if loc.HasHeading() {
+				headings[j] = loc.Heading
+			}
+		}
+		geomType := "LineString"
+		var geomCoords interface{} = coords
+		if len(coords) == 1 {
+			// A LineString needs at least two positions.
+			geomType = "Point"
+			geomCoords = coords[0]
+		}
+		props := map[string]interface{}{
+			"segment":    i,
+			"times":      times,
+			"accuracies": accuracies,
+			"speeds":     speeds,
+			"headings":   headings,
+		}
+		if t.Name != "" {
+			props["name"] = t.Name
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: geomType, Coordinates: geomCoords},
+			Properties: props,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
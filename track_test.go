@@ -0,0 +1,157 @@
+package geoclue2
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackSegments(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	track := NewTrack("test")
+	track.Add(fix(1, 1, 10, start))
+	track.Add(fix(2, 2, 10, start.Add(time.Minute)))
+	track.Add(fix(3, 3, 10, start.Add(time.Hour)))
+	segs := track.Segments()
+	assert.Len(t, segs, 2)
+	assert.Len(t, segs[0], 2)
+	assert.Len(t, segs[1], 1)
+	assert.Equal(t, 3, track.Len())
+
+	track = NewTrack("test")
+	track.SegmentGap = 0
+	track.Add(fix(1, 1, 10, start))
+	track.Add(fix(3, 3, 10, start.Add(time.Hour)))
+	assert.Len(t, track.Segments(), 1)
+}
+
+func TestTrackRecord(t *testing.T) {
+	src := newTestProvider()
+	src.Start()
+	track := NewTrack("")
+	track.Record(src)
+	src.publish(fix(1, 1, 10, time.Now()))
+	src.publish(fix(2, 2, 10, time.Now()))
+	for i := 0; i < 100 && track.Len() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	track.Stop()
+	src.Stop()
+	assert.Equal(t, 2, track.Len())
+}
+
+func TestTrackWriteGPX(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	track := NewTrack("field test")
+	loc := fix(47.5, 19.05, 10, start)
+	loc.Altitude = 120.5
+	loc.Speed = 1.5
+	loc.Heading = 90
+	track.Add(loc)
+	track.Add(fix(47.6, 19.06, 10, start.Add(time.Second)))
+	track.Add(fix(47.7, 19.07, 10, start.Add(time.Hour)))
+	buf := &bytes.Buffer{}
+	assert.NoError(t, track.WriteGPX(buf))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, xml.Header))
+	assert.Contains(t, out, `<gpx version="1.1" creator="go-geoclue2" xmlns="http://www.topografix.com/GPX/1/1"`)
+	assert.Contains(t, out, `<name>field test</name>`)
+	assert.Contains(t, out, `<trkpt lat="47.5" lon="19.05">`)
+	assert.Contains(t, out, `<ele>120.5</ele>`)
+	assert.Contains(t, out, `<time>2020-09-13T12:26:40Z</time>`)
+	assert.Contains(t, out, `<gpxtpx:speed>1.5</gpxtpx:speed>`)
+	assert.Contains(t, out, `<gpxtpx:course>90</gpxtpx:course>`)
+	assert.Equal(t, 1, strings.Count(out, "<ele>"))
+	assert.Equal(t, 1, strings.Count(out, "<extensions>"))
+	assert.Equal(t, 2, strings.Count(out, "<trkseg>"))
+
+	var doc struct {
+		Segments []struct {
+			Points []struct {
+				Lat float64 `xml:"lat,attr"`
+				Lon float64 `xml:"lon,attr"`
+			} `xml:"trkpt"`
+		} `xml:"trk>trkseg"`
+	}
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &doc))
+	assert.Len(t, doc.Segments, 2)
+	assert.Len(t, doc.Segments[0].Points, 2)
+	assert.Equal(t, 47.6, doc.Segments[0].Points[1].Lat)
+}
+
+func TestReadGPX(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	track := NewTrack("field test")
+	loc := fix(47.5, 19.05, 0, start)
+	loc.Altitude = 120.5
+	loc.Speed = 1.5
+	loc.Heading = 90
+	loc.Description = "start"
+	track.Add(loc)
+	track.Add(fix(47.6, 19.06, 0, start.Add(time.Second)))
+	track.Add(fix(47.7, 19.07, 0, start.Add(time.Hour)))
+	buf := &bytes.Buffer{}
+	assert.NoError(t, track.WriteGPX(buf))
+	read, err := ReadGPX(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "field test", read.Name)
+	assert.Equal(t, track.Segments(), read.Segments())
+
+	// GPX 1.0, with a route and without times.
+	read, err = ReadGPX(strings.NewReader(`<?xml version="1.0"?>
+<gpx version="1.0" xmlns="http://www.topografix.com/GPX/1/0">
+  <trk><trkseg><trkpt lat="1" lon="2"><speed>3</speed><course>4</course></trkpt></trkseg></trk>
+  <rte><rtept lat="5" lon="6"/><rtept lat="7" lon="8"/></rte>
+</gpx>`))
+	assert.NoError(t, err)
+	segs := read.Segments()
+	assert.Len(t, segs, 2)
+	assert.Equal(t, 3.0, segs[0][0].Speed)
+	assert.Equal(t, 4.0, segs[0][0].Heading)
+	assert.False(t, segs[0][0].HasAltitude())
+	assert.True(t, segs[0][0].Timestamp.IsZero())
+	assert.Equal(t, 7.0, segs[1][1].Latitude)
+
+	_, err = ReadGPX(strings.NewReader(`<gpx><trk><trkseg><trkpt lat="1" lon="2"><time>noon</time></trkpt></trkseg></trk></gpx>`))
+	assert.Error(t, err)
+}
+
+func TestTrackWriteGeoJSON(t *testing.T) {
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	track := NewTrack("field test")
+	loc := fix(47.5, 19.05, 10, start)
+	loc.Speed = 1.5
+	track.Add(loc)
+	track.Add(fix(47.6, 19.06, 20, start.Add(time.Second)))
+	track.Add(fix(47.7, 19.07, 10, start.Add(time.Hour)))
+	buf := &bytes.Buffer{}
+	assert.NoError(t, track.WriteGeoJSON(buf))
+	var fc struct {
+		Type     string
+		Features []struct {
+			Type     string
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &fc))
+	assert.Equal(t, "FeatureCollection", fc.Type)
+	assert.Len(t, fc.Features, 2)
+	f := fc.Features[0]
+	assert.Equal(t, "LineString", f.Geometry.Type)
+	assert.JSONEq(t, `[[19.05,47.5],[19.06,47.6]]`, string(f.Geometry.Coordinates))
+	assert.Equal(t, "field test", f.Properties["name"])
+	assert.Equal(t, []interface{}{"2020-09-13T12:26:40Z", "2020-09-13T12:26:41Z"}, f.Properties["times"])
+	assert.Equal(t, []interface{}{1.5, nil}, f.Properties["speeds"])
+	assert.Equal(t, []interface{}{10.0, 20.0}, f.Properties["accuracies"])
+	assert.Equal(t, "Point", fc.Features[1].Geometry.Type)
+	assert.JSONEq(t, `[19.07,47.7]`, string(fc.Features[1].Geometry.Coordinates))
+}
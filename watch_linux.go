@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package geoclue2
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyWatcher watches the directory containing a file, so that the file
+// being replaced (e.g. by an editor writing a new copy and renaming it) is
+// noticed too.
+type inotifyWatcher struct {
+	file   *os.File
+	name   string
+	events chan struct{}
+	done   chan struct{}
+	logger Logger
+}
+
+func newFileWatcher(path string, logger Logger) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		// The descriptor is non-blocking, so reads go through the runtime
+		// poller and Close() interrupts a pending read.
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		name:   filepath.Base(path),
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) readLoop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.logger.Warn("reading inotify events", "error", err)
+			}
+			return
+		}
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			end := start + int(event.Len)
+			if end > n {
+				break
+			}
+			name := string(buf[start:end])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			if name == w.name {
+				changed = true
+			}
+			offset = end
+		}
+		if !changed {
+			continue
+		}
+		// Coalesce events the consumer hasn't picked up yet.
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//go:build !linux
+// +build !linux
+
+package geoclue2
+
+import (
+	"os"
+	"time"
+)
+
+const fileWatchInterval = 5 * time.Second
+
+// pollWatcher watches a file by periodically checking its size and
+// modification time.
+type pollWatcher struct {
+	path   string
+	events chan struct{}
+	done   chan struct{}
+}
+
+func newFileWatcher(path string, logger Logger) (fileWatcher, error) {
+	w := &pollWatcher{
+		path:   path,
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go w.pollLoop()
+	return w, nil
+}
+
+func (w *pollWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) pollLoop() {
+	ticker := time.NewTicker(fileWatchInterval)
+	defer ticker.Stop()
+	last, _ := os.Stat(w.path)
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		fi, _ := os.Stat(w.path)
+		if !changed(last, fi) {
+			continue
+		}
+		last = fi
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func changed(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return a.Size() != b.Size() || !a.ModTime().Equal(b.ModTime())
+}